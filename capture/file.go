@@ -0,0 +1,97 @@
+package capture
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"packet_sniffer/filter"
+	units "packet_sniffer/model"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileReader is a capturer backed by a capture file, Capture returns io.EOF once every frame has been read
+type FileReader interface {
+	Capturer
+	LinkType() units.LinkType
+	SnapLen() int
+}
+
+// OpenFile opens a capture file for reading, whether it's a pcap or a pcapng file is figured out from its contents
+func OpenFile(path string) (FileReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(file, magic)
+	file.Close()
+
+	if err == nil && binary.LittleEndian.Uint32(magic) == pcapngSectionHeaderBlock {
+		reader := PcapngReader{}
+		if err := reader.Init(path); err != nil {
+			return nil, err
+		}
+		return &reader, nil
+	}
+	reader := PcapReader{}
+	if err := reader.Init(path); err != nil {
+		return nil, err
+	}
+	return &reader, nil
+}
+
+// CreateFile opens a capture file for writing, files with the .pcapng extension are written as pcapng and anything
+// else as classic pcap. The link type is only used by the latter, pcapng takes it from the frames themselves
+func CreateFile(path string, linkType units.LinkType, snapLen int) (PacketWriter, error) {
+	if strings.ToLower(filepath.Ext(path)) == ".pcapng" {
+		writer := PcapngWriter{}
+		if err := writer.Init(path, snapLen); err != nil {
+			return nil, err
+		}
+		return &writer, nil
+	}
+	writer := PcapWriter{}
+	if err := writer.Init(path, linkType, snapLen); err != nil {
+		return nil, err
+	}
+	return &writer, nil
+}
+
+// FilteredReader skips the frames of a capture file, or of any other source the kernel doesn't filter, that don't match
+// the filter, the way the kernel does for live captures. The skipped frames are counted as filtered
+type FilteredReader struct {
+	FileReader
+	Filter filter.Program
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+func (r *FilteredReader) Capture(ctx context.Context) ([]byte, units.CaptureInfo, error) {
+	for {
+		data, info, err := r.FileReader.Capture(ctx)
+		if err != nil {
+			return data, info, err
+		}
+		matched := r.Filter.Match(data)
+		r.mu.Lock()
+		if matched {
+			r.stats.Received++
+		} else {
+			r.stats.Filtered++
+		}
+		r.mu.Unlock()
+		if matched {
+			return data, info, err
+		}
+	}
+}
+
+func (r *FilteredReader) Stats() (Stats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats, nil
+}
@@ -0,0 +1,337 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	units "packet_sniffer/model"
+	"packet_sniffer/utils"
+	"sync"
+	"time"
+)
+
+// HotplugCapturer keeps capturing on an interface through the changes the kernel tells about. The link going up and
+// down is annotated, and once the interface is removed, the capture starts over on the next interface that shows up
+// under the same name, whatever its index. On AnyInterface the changes of every interface are annotated. Annotations
+// are attached to the next frame as comments, the pcapng writer keeps them
+type HotplugCapturer struct {
+	Interface string
+	Namespace string                   // path of the network namespace the interface is in, the one of the process when empty
+	Open      func() (Capturer, error) // starts capturing on the interface as it is now
+
+	monitor     LinkMonitor
+	updates     chan linkUpdate
+	frames      chan hotplugFrame
+	links       map[int]LinkEvent // last known state of the interfaces by index
+	index       int               // of the interface captured on, 0 while it's gone
+	annotations []string
+	done        chan struct{}
+	closeOnce   sync.Once
+
+	mu          sync.Mutex // guards the capturer against Stats and Close
+	current     Capturer
+	generation  int // of the capturer, frames of the ones stopped since are dropped
+	cancel      context.CancelFunc
+	closedStats Stats // of the capturers replaced so far
+}
+
+type linkUpdate struct {
+	events []LinkEvent
+	err    error
+}
+
+type hotplugFrame struct {
+	data       []byte
+	info       units.CaptureInfo
+	err        error
+	generation int
+}
+
+// Init subscribes to the link notifications before capturing, so that no change goes unnoticed
+func (h *HotplugCapturer) Init() error {
+	if err := h.monitor.Init(h.Namespace); err != nil {
+		return err
+	}
+	links, err := currentLinks(h.Namespace)
+	if err != nil {
+		h.monitor.Close()
+		return err
+	}
+	h.links = links
+	if h.Interface != AnyInterface {
+		for index, link := range links {
+			if link.Name == h.Interface {
+				h.index = index
+			}
+		}
+	}
+	capturer, err := h.Open()
+	if err != nil {
+		h.monitor.Close()
+		return err
+	}
+	h.updates = make(chan linkUpdate, 16)
+	h.frames = make(chan hotplugFrame, 64)
+	h.done = make(chan struct{})
+	h.start(capturer)
+	go h.watch()
+	return nil
+}
+
+// currentLinks looks up the state of every interface of the namespace
+func currentLinks(namespace string) (map[int]LinkEvent, error) {
+	links := make(map[int]LinkEvent)
+	err := utils.InNetworkNamespace(namespace, func() error {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return err
+		}
+		for _, iface := range ifaces {
+			up := iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagRunning != 0
+			links[iface.Index] = LinkEvent{Index: iface.Index, Name: iface.Name, Up: up}
+		}
+		return nil
+	})
+	return links, err
+}
+
+// watch hands the link notifications over to Capture until the monitor is closed
+func (h *HotplugCapturer) watch() {
+	for {
+		events, err := h.monitor.Next(context.Background())
+		if err == errLinkEventsLost {
+			// Whatever happened in the meantime is found out by comparing with the interfaces as they are now
+			events, err = h.resync()
+		}
+		select {
+		case h.updates <- linkUpdate{events: events, err: err}:
+		case <-h.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// resync makes up the events that would bring the last known state to the current one
+func (h *HotplugCapturer) resync() ([]LinkEvent, error) {
+	links, err := currentLinks(h.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var events []LinkEvent
+	// Reading h.links races with Capture, so the removals are left to be figured out there
+	for _, link := range links {
+		events = append(events, link)
+	}
+	return append(events, LinkEvent{Index: -1, Removed: true}), nil
+}
+
+// start captures on a new capturer in the background
+func (h *HotplugCapturer) start(capturer Capturer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.mu.Lock()
+	h.current = capturer
+	h.generation++
+	generation := h.generation
+	h.cancel = cancel
+	h.mu.Unlock()
+	go func() {
+		for {
+			data, info, err := capturer.Capture(ctx)
+			select {
+			case h.frames <- hotplugFrame{data: data, info: info, err: err, generation: generation}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// stop closes the capturer of the interface that went away, its statistics are kept
+func (h *HotplugCapturer) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.current == nil {
+		return
+	}
+	// What the capturer returns from now on, the error of its cancellation to begin with, is dropped
+	h.generation++
+	h.cancel()
+	h.current.Close()
+	if reporter, ok := h.current.(StatsReporter); ok {
+		if stats, err := reporter.Stats(); err == nil {
+			h.closedStats = h.closedStats.Add(stats)
+		}
+	}
+	h.current = nil
+}
+
+func (h *HotplugCapturer) annotate(format string, args ...any) {
+	annotation := fmt.Sprintf(format, args...)
+	h.annotations = append(h.annotations, fmt.Sprintf("%s at %s", annotation, time.Now().Format("15:04:05.000")))
+}
+
+// handle annotates the changes of the interfaces and follows the one captured on
+func (h *HotplugCapturer) handle(events []LinkEvent) {
+	for _, event := range events {
+		if event.Index < 0 {
+			// The end of a resync, the interfaces it didn't mention are gone
+			h.removeMissing(events)
+			continue
+		}
+		previous, known := h.links[event.Index]
+		if event.Removed {
+			delete(h.links, event.Index)
+			if known {
+				event.Name = previous.Name
+			}
+			h.removed(event)
+			continue
+		}
+		h.links[event.Index] = event
+		switch {
+		case !known:
+			h.added(event)
+		case previous.Name != event.Name:
+			if h.follows(event.Index, previous.Name) {
+				h.annotate("%s: renamed to %s", previous.Name, event.Name)
+			}
+			if event.Name == h.Interface && event.Index != h.index {
+				h.added(event)
+			}
+		case previous.Up != event.Up:
+			if h.follows(event.Index, event.Name) {
+				h.annotate("%s: link %s", event.Name, linkState(event.Up))
+			}
+		}
+	}
+}
+
+// follows tells whether the changes of an interface are annotated
+func (h *HotplugCapturer) follows(index int, name string) bool {
+	return h.Interface == AnyInterface || index == h.index || name == h.Interface
+}
+
+func linkState(up bool) string {
+	if up {
+		return "up"
+	}
+	return "down"
+}
+
+func (h *HotplugCapturer) removed(event LinkEvent) {
+	if h.Interface == AnyInterface {
+		h.annotate("%s: removed", event.Name)
+		return
+	}
+	if event.Index != h.index {
+		return
+	}
+	h.annotate("%s: removed, waiting for it to come back", h.Interface)
+	h.stop()
+	h.index = 0
+}
+
+func (h *HotplugCapturer) removeMissing(events []LinkEvent) {
+	present := make(map[int]bool, len(events))
+	for _, event := range events {
+		present[event.Index] = true
+	}
+	for index, link := range h.links {
+		if !present[index] {
+			delete(h.links, index)
+			h.removed(LinkEvent{Index: index, Name: link.Name, Removed: true})
+		}
+	}
+}
+
+func (h *HotplugCapturer) added(event LinkEvent) {
+	if h.Interface == AnyInterface {
+		h.annotate("%s: added as index %d, link %s", event.Name, event.Index, linkState(event.Up))
+		return
+	}
+	if event.Name != h.Interface {
+		return
+	}
+	// The interface that had the name is still there if it was renamed, its capture makes way for the new one
+	h.stop()
+	h.index = event.Index
+	capturer, err := h.Open()
+	if err != nil {
+		h.annotate("%s: back as index %d but can't be captured on: %v", h.Interface, event.Index, err)
+		return
+	}
+	h.start(capturer)
+	h.annotate("%s: back as index %d, link %s, capturing again", h.Interface, event.Index, linkState(event.Up))
+}
+
+func (h *HotplugCapturer) Capture(ctx context.Context) ([]byte, units.CaptureInfo, error) {
+	for {
+		select {
+		case frame := <-h.frames:
+			if frame.generation != h.generation {
+				continue
+			}
+			if frame.err != nil {
+				return nil, units.CaptureInfo{}, frame.err
+			}
+			if len(h.annotations) > 0 {
+				frame.info.Comments = append(frame.info.Comments, h.annotations...)
+				h.annotations = nil
+			}
+			return frame.data, frame.info, nil
+		case update := <-h.updates:
+			if update.err != nil {
+				if !errors.Is(update.err, ErrClosed) {
+					h.annotate("link changes are no longer followed: %v", update.err)
+				}
+				h.updates = nil
+				continue
+			}
+			h.handle(update.events)
+		case <-ctx.Done():
+			return nil, units.CaptureInfo{}, ctx.Err()
+		case <-h.done:
+			return nil, units.CaptureInfo{}, ErrClosed
+		}
+	}
+}
+
+// Stats adds up the statistics of the capturers of the interface, the replaced ones included
+func (h *HotplugCapturer) Stats() (Stats, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	total := h.closedStats
+	if reporter, ok := h.current.(StatsReporter); ok {
+		stats, err := reporter.Stats()
+		if err != nil {
+			return total, err
+		}
+		total = total.Add(stats)
+	}
+	return total, nil
+}
+
+func (h *HotplugCapturer) Close() error {
+	var err error
+	h.closeOnce.Do(func() {
+		close(h.done)
+		err = h.monitor.Close()
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.current != nil {
+			h.cancel()
+			if closeErr := h.current.Close(); err == nil {
+				err = closeErr
+			}
+			h.current = nil
+		}
+	})
+	return err
+}
@@ -0,0 +1,55 @@
+package capture
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Injector sends frames out of a network interface, the counterpart of Capturer
+type Injector interface {
+	Inject(data []byte) error
+	Close() error
+}
+
+// UnixInjector sends complete link layer frames through an AF_PACKET socket, the frames go out as they are
+type UnixInjector struct {
+	sock    int
+	ifindex int
+}
+
+func (ui *UnixInjector) Init(iface string) error {
+	if iface == AnyInterface {
+		return fmt.Errorf("frames can't be sent to %q, it has to be a network interface", AnyInterface)
+	}
+	// The socket is only there to send, it's bound without a protocol so that nothing is ever queued on it
+	sock, err := createPacketSocket(false)
+	if err != nil {
+		return err
+	}
+	ifindex, err := interfaceIndex(sock, iface)
+	if err != nil {
+		syscall.Close(sock)
+		return err
+	}
+	if err := syscall.Bind(sock, &syscall.SockaddrLinklayer{Ifindex: ifindex}); err != nil {
+		syscall.Close(sock)
+		return err
+	}
+	ui.sock, ui.ifindex = sock, ifindex
+	return nil
+}
+
+func (ui *UnixInjector) Inject(data []byte) error {
+	n, err := syscall.Write(ui.sock, data)
+	if err != nil {
+		return fmt.Errorf("failed to send a frame of %d bytes: %v", len(data), err)
+	}
+	if n != len(data) {
+		return fmt.Errorf("sent %d bytes of a frame of %d bytes", n, len(data))
+	}
+	return nil
+}
+
+func (ui *UnixInjector) Close() error {
+	return syscall.Close(ui.sock)
+}
@@ -1,5 +1,29 @@
 package capture
 
+import (
+	"context"
+	"errors"
+	units "packet_sniffer/model"
+)
+
+// ErrClosed is returned by Capture once the capturer has been closed
+var ErrClosed = errors.New("capturer closed")
+
+// Capturer hands over frames one at a time along with whatever is known about them. Capture blocks until there is a
+// frame, the context is done or the capturer is closed from another goroutine. Close releases whatever the capturer
+// holds on to, live capturers give the interface back in the state they found it
 type Capturer interface {
-	Capture() ([]byte, error)
+	Capture(ctx context.Context) ([]byte, units.CaptureInfo, error)
+	Close() error
+}
+
+type PacketWriter interface {
+	WritePacket(info units.CaptureInfo, data []byte) error
+	Close() error
+}
+
+// SizedWriter is implemented by the writers that know how much they have written
+type SizedWriter interface {
+	PacketWriter
+	Size() int64
 }
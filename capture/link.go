@@ -0,0 +1,100 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"packet_sniffer/filter"
+	units "packet_sniffer/model"
+	"packet_sniffer/utils"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// cookedHeaderLength is the length of the Linux cooked v2 header put in front of frames received on cooked sockets
+const cookedHeaderLength = 20
+
+// hardwareLinkTypes maps the ARPHRD_* types of the interfaces whose frames are captured as they are to their link type,
+// the frames of the others are captured cooked
+var hardwareLinkTypes = map[uint16]units.LinkType{
+	unix.ARPHRD_ETHER:    units.LinkTypeEthernet,
+	unix.ARPHRD_LOOPBACK: units.LinkTypeEthernet,
+	// tun devices, WireGuard and IP tunnels hand over bare IP packets
+	unix.ARPHRD_NONE:    units.LinkTypeRaw,
+	unix.ARPHRD_RAWIP:   units.LinkTypeRaw,
+	unix.ARPHRD_TUNNEL:  units.LinkTypeRaw,
+	unix.ARPHRD_TUNNEL6: units.LinkTypeRaw,
+	unix.ARPHRD_SIT:     units.LinkTypeRaw,
+}
+
+// InterfaceLinkType returns the link type of the frames captured on an interface of the network namespace of the file
+// at the path, or of the process' own one when empty. AnyInterface and interfaces whose link-layer header isn't
+// understood are captured cooked, with a Linux cooked v2 header in place of their own
+func InterfaceLinkType(iface string, namespace string) (units.LinkType, error) {
+	var linkType units.LinkType
+	err := utils.InNetworkNamespace(namespace, func() error {
+		var err error
+		linkType, err = interfaceLinkType(iface)
+		return err
+	})
+	return linkType, err
+}
+
+func interfaceLinkType(iface string) (units.LinkType, error) {
+	if iface == AnyInterface {
+		return units.LinkTypeLinuxSLL2, nil
+	}
+	sock, err := createPacketSocket(false)
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.Close(sock)
+	ifreq, err := unix.NewIfreq(iface)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interface name %q: %v", iface, err)
+	}
+	// The hardware address comes as a sockaddr whose family is the ARPHRD_* type of the interface
+	if err := unix.IoctlIfreq(sock, unix.SIOCGIFHWADDR, ifreq); err != nil {
+		return 0, fmt.Errorf("failed to get the hardware type of %s: %v", iface, err)
+	}
+	if linkType, hit := hardwareLinkTypes[ifreq.Uint16()]; hit {
+		return linkType, nil
+	}
+	return units.LinkTypeLinuxSLL2, nil
+}
+
+// compileFilter compiles the filter expression for the frames the way the kernel sees them, that is without the cooked
+// header on cooked sockets
+func compileFilter(expression *filter.Expression, cooked bool, linkType units.LinkType) (filter.Program, error) {
+	if cooked {
+		return filter.CompileCooked(expression, DefaultSnapLen)
+	}
+	return filter.Compile(expression, DefaultSnapLen, linkType)
+}
+
+// putCookedHeader writes the Linux cooked v2 header of a frame received on a cooked socket out of the sockaddr_ll it
+// came with. The protocol is expected in network byte order, the way sockaddr_ll holds it
+func putCookedHeader(header []byte, protocol uint16, ifindex int, hatype uint16, pkttype uint8, halen uint8, addr []byte) {
+	binary.BigEndian.PutUint16(header[0:2], NTOHS(protocol))
+	binary.BigEndian.PutUint16(header[2:4], 0)
+	binary.BigEndian.PutUint32(header[4:8], uint32(ifindex))
+	binary.BigEndian.PutUint16(header[8:10], hatype)
+	header[10] = pkttype
+	header[11] = halen
+	clear(header[12:20])
+	copy(header[12:20], addr[:min(int(halen), 8)])
+}
+
+// insertVLANTag puts the VLAN tag the kernel took off a received Ethernet frame, and reported out of band, back after
+// the MAC addresses
+func insertVLANTag(frame []byte, tpid uint16, tci uint16) []byte {
+	if len(frame) < 12 {
+		return frame
+	}
+	tagged := make([]byte, len(frame)+4)
+	copy(tagged, frame[:12])
+	binary.BigEndian.PutUint16(tagged[12:14], tpid)
+	binary.BigEndian.PutUint16(tagged[14:16], tci)
+	copy(tagged[16:], frame[12:])
+	return tagged
+}
@@ -0,0 +1,286 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"packet_sniffer/filter"
+	units "packet_sniffer/model"
+	"packet_sniffer/utils"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	DefaultBlockSize    = 1 << 20
+	DefaultBlockCount   = 64
+	DefaultBlockTimeout = 64 * time.Millisecond
+
+	// TPACKET_V3 packs frames of variable size into blocks, the frame size only has to be set for the kernel's sanity
+	// checks
+	mmapFrameSize = 1 << 11
+
+	mmapSockaddrOffset = (unix.SizeofTpacket3Hdr + unix.TPACKET_ALIGNMENT - 1) &^ (unix.TPACKET_ALIGNMENT - 1)
+)
+
+// MmapCapturer captures frames through a PACKET_RX_RING ring buffer shared with the kernel. With TPACKET_V3 the kernel
+// fills whole blocks of frames, so frames are read without a syscall each as long as there is a block ready
+type MmapCapturer struct {
+	BlockSize    int // has to be a multiple of the page size
+	BlockCount   int
+	BlockTimeout time.Duration // a block that isn't full is handed over after this long
+	Filter       *filter.Expression
+	Promiscuous  bool
+	AllMulticast bool
+	FanoutMode   FanoutMode
+	FanoutGroup  uint16
+	Cooked       bool
+	Namespace    string
+
+	sock        int
+	iface       string
+	ifindex     int
+	linkType    units.LinkType
+	memberships []uint16
+	stats       socketStats
+
+	mu     sync.Mutex // held by Capture, so that Close doesn't unmap the ring from under it
+	waker  waker
+	closed atomic.Bool
+
+	ring        []byte
+	block       int // index of the block being read
+	packetsLeft int // packets of the current block that have not been read yet
+	offset      int // offset of the next packet within the current block
+}
+
+// Init opens the socket and maps its ring inside the namespace of the interface, where the socket stays
+func (mc *MmapCapturer) Init(iface string) error {
+	return utils.InNetworkNamespace(mc.Namespace, func() error {
+		return mc.init(iface)
+	})
+}
+
+func (mc *MmapCapturer) init(iface string) error {
+	if mc.BlockSize == 0 {
+		mc.BlockSize = DefaultBlockSize
+	}
+	if mc.BlockCount == 0 {
+		mc.BlockCount = DefaultBlockCount
+	}
+	if mc.BlockTimeout == 0 {
+		mc.BlockTimeout = DefaultBlockTimeout
+	}
+	if pageSize := os.Getpagesize(); mc.BlockSize%pageSize != 0 || mc.BlockSize < mmapFrameSize {
+		return fmt.Errorf("block size %d has to be a multiple of the page size %d", mc.BlockSize, pageSize)
+	}
+
+	linkType, err := interfaceLinkType(iface)
+	if err != nil {
+		return err
+	}
+	cooked := mc.Cooked || linkType == units.LinkTypeLinuxSLL2
+	program, err := compileFilter(mc.Filter, cooked, linkType)
+	if err != nil {
+		return fmt.Errorf("%s: %v", iface, err)
+	}
+	sock, err := createPacketSocket(cooked)
+	if err != nil {
+		return err
+	}
+	mc.sock = sock
+	if mc.waker, err = newWaker(); err != nil {
+		mc.release()
+		return err
+	}
+	if err := attachFilter(mc.sock, program); err != nil {
+		mc.release()
+		return err
+	}
+	if err := mc.setupRing(); err != nil {
+		mc.release()
+		return err
+	}
+	// Binding only once the ring is in place makes sure no frame is queued outside of it
+	ifindex, err := bindToInterface(mc.sock, iface)
+	if err != nil {
+		mc.release()
+		return err
+	}
+	if mc.FanoutGroup, err = joinFanout(mc.sock, mc.FanoutGroup, mc.FanoutMode); err != nil {
+		mc.release()
+		return err
+	}
+	if mc.memberships, err = addMemberships(mc.sock, ifindex, mc.Promiscuous, mc.AllMulticast); err != nil {
+		mc.release()
+		return err
+	}
+	mc.iface, mc.ifindex = iface, ifindex
+	mc.linkType = linkType
+	if cooked {
+		mc.linkType = units.LinkTypeLinuxSLL2
+	}
+	return nil
+}
+
+// LinkType returns the link type of the captured frames, it's known once Init succeeded
+func (mc *MmapCapturer) LinkType() units.LinkType {
+	return mc.linkType
+}
+
+func (mc *MmapCapturer) setupRing() error {
+	if err := unix.SetsockoptInt(mc.sock, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		return fmt.Errorf("failed to switch to TPACKET_V3: %v", err)
+	}
+	req := unix.TpacketReq3{
+		Block_size:     uint32(mc.BlockSize),
+		Block_nr:       uint32(mc.BlockCount),
+		Frame_size:     mmapFrameSize,
+		Frame_nr:       uint32(mc.BlockSize / mmapFrameSize * mc.BlockCount),
+		Retire_blk_tov: uint32(mc.BlockTimeout / time.Millisecond),
+	}
+	if err := unix.SetsockoptTpacketReq3(mc.sock, unix.SOL_PACKET, unix.PACKET_RX_RING, &req); err != nil {
+		return fmt.Errorf("failed to set up the receive ring: %v", err)
+	}
+	ring, err := unix.Mmap(mc.sock, 0, mc.BlockSize*mc.BlockCount, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("failed to map the receive ring: %v", err)
+	}
+	mc.ring = ring
+	return nil
+}
+
+func (mc *MmapCapturer) blockHeader(block int) *unix.TpacketHdrV1 {
+	// The block descriptor starts with its version and private data offset, followed by the header itself
+	return (*unix.TpacketHdrV1)(unsafe.Pointer(&mc.ring[block*mc.BlockSize+8]))
+}
+
+func (mc *MmapCapturer) blockStatus(block int) *uint32 {
+	return &mc.blockHeader(block).Block_status
+}
+
+// waitForBlock blocks until the kernel hands the current block over to userspace
+func (mc *MmapCapturer) waitForBlock(ctx context.Context) error {
+	for atomic.LoadUint32(mc.blockStatus(mc.block))&unix.TP_STATUS_USER == 0 {
+		if mc.closed.Load() {
+			return ErrClosed
+		}
+		if err := mc.waker.wait(ctx, mc.sock); err != nil {
+			return err
+		}
+		// Nothing reads the socket to clear the error it reports when the interface goes down, poll would keep
+		// returning right away otherwise. The kernel hooks the socket up again once the interface is back up
+		if errno, err := unix.GetsockoptInt(mc.sock, unix.SOL_SOCKET, unix.SO_ERROR); err != nil {
+			return err
+		} else if errno != 0 && syscall.Errno(errno) != syscall.ENETDOWN {
+			return fmt.Errorf("failed to capture: %v", syscall.Errno(errno))
+		}
+	}
+	header := mc.blockHeader(mc.block)
+	mc.packetsLeft = int(header.Num_pkts)
+	mc.offset = int(header.Offset_to_first_pkt)
+	return nil
+}
+
+// releaseBlock gives the current block back to the kernel and moves on to the next one
+func (mc *MmapCapturer) releaseBlock() {
+	atomic.StoreUint32(mc.blockStatus(mc.block), unix.TP_STATUS_KERNEL)
+	mc.block = (mc.block + 1) % mc.BlockCount
+}
+
+func (mc *MmapCapturer) Capture(ctx context.Context) ([]byte, units.CaptureInfo, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.closed.Load() {
+		return nil, units.CaptureInfo{}, ErrClosed
+	}
+	for mc.packetsLeft == 0 {
+		if err := mc.waitForBlock(ctx); err != nil {
+			return nil, units.CaptureInfo{}, err
+		}
+		// Blocks retired by the timeout may carry no frames at all
+		if mc.packetsLeft == 0 {
+			mc.releaseBlock()
+		}
+	}
+
+	blockStart := mc.block * mc.BlockSize
+	header := (*unix.Tpacket3Hdr)(unsafe.Pointer(&mc.ring[blockStart+mc.offset]))
+	frameStart := blockStart + mc.offset + int(header.Mac)
+	// The kernel places a sockaddr_ll right after the header
+	sll := (*unix.RawSockaddrLinklayer)(unsafe.Pointer(&mc.ring[blockStart+mc.offset+mmapSockaddrOffset]))
+	// The ring is handed back to the kernel, so the frame has to be copied out of it, after the cooked header if any
+	headerLength := 0
+	if mc.linkType == units.LinkTypeLinuxSLL2 {
+		headerLength = cookedHeaderLength
+	}
+	data := make([]byte, headerLength+int(header.Snaplen))
+	copy(data[headerLength:], mc.ring[frameStart:frameStart+int(header.Snaplen)])
+	if headerLength > 0 {
+		putCookedHeader(data, sll.Protocol, int(sll.Ifindex), sll.Hatype, sll.Pkttype, sll.Halen, sll.Addr[:])
+	}
+	length := headerLength + int(header.Len)
+	if header.Status&unix.TP_STATUS_VLAN_VALID != 0 && mc.linkType == units.LinkTypeEthernet {
+		// Kernels that don't tell the TPID only strip 802.1Q tags
+		tpid := uint16(0x8100)
+		if header.Status&unix.TP_STATUS_VLAN_TPID_VALID != 0 {
+			tpid = header.Hv1.Vlan_tpid
+		}
+		data = insertVLANTag(data, tpid, uint16(header.Hv1.Vlan_tci))
+		length += 4
+	}
+	info := units.CaptureInfo{
+		Timestamp:      time.Unix(int64(header.Sec), int64(header.Nsec)),
+		CaptureLength:  len(data),
+		Length:         length,
+		LinkType:       mc.linkType,
+		PacketType:     packetType(sll.Pkttype),
+		InterfaceIndex: int(sll.Ifindex),
+		InterfaceName:  mc.iface,
+	}
+	if mc.ifindex == 0 {
+		info.InterfaceName = interfaceName(mc.Namespace, info.InterfaceIndex)
+	}
+
+	mc.offset += int(header.Next_offset)
+	mc.packetsLeft--
+	if mc.packetsLeft == 0 {
+		mc.releaseBlock()
+	}
+	return data, info, nil
+}
+
+func (mc *MmapCapturer) Stats() (Stats, error) {
+	return mc.stats.update(mc.sock, true)
+}
+
+// Close makes a Capture running in another goroutine return ErrClosed and waits for it before unmapping the ring
+func (mc *MmapCapturer) Close() error {
+	if mc.closed.Swap(true) {
+		return nil
+	}
+	mc.waker.wake()
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.stats.close(mc.sock, true)
+	err := dropMemberships(mc.sock, mc.ifindex, mc.memberships)
+	mc.memberships = nil
+	if releaseErr := mc.release(); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+func (mc *MmapCapturer) release() error {
+	if mc.ring != nil {
+		unix.Munmap(mc.ring)
+		mc.ring = nil
+	}
+	mc.waker.close()
+	return syscall.Close(mc.sock)
+}
@@ -0,0 +1,150 @@
+package capture
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	units "packet_sniffer/model"
+	"time"
+)
+
+// DefaultReorderWindow is how long MultiCapturer holds on to a frame in case an earlier one is still on its way
+const DefaultReorderWindow = 20 * time.Millisecond
+
+// MultiCapturer merges the frames of several capturers into one stream ordered by their timestamps. Each capturer
+// hands over its own frames in order, but the capturers run independently of each other, so every frame is held back
+// for the reorder window to let earlier frames from the other capturers catch up
+type MultiCapturer struct {
+	Capturers     []Capturer
+	ReorderWindow time.Duration
+
+	frames  chan capturedFrame
+	pending frameHeap
+	running int // capturers that haven't stopped yet
+	cancel  context.CancelFunc
+	done    <-chan struct{}
+}
+
+type capturedFrame struct {
+	data     []byte
+	info     units.CaptureInfo
+	err      error
+	received time.Time
+}
+
+// frameHeap keeps the pending frames ordered by their timestamps
+type frameHeap []capturedFrame
+
+func (h frameHeap) Len() int { return len(h) }
+func (h frameHeap) Less(i, j int) bool {
+	if h[i].info.Timestamp.Equal(h[j].info.Timestamp) {
+		return h[i].received.Before(h[j].received)
+	}
+	return h[i].info.Timestamp.Before(h[j].info.Timestamp)
+}
+func (h frameHeap) Swap(i, j int)   { h[i], h[j] = h[j], h[i] }
+func (h *frameHeap) Push(frame any) { *h = append(*h, frame.(capturedFrame)) }
+func (h *frameHeap) Pop() any {
+	old := *h
+	frame := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return frame
+}
+
+// Init starts reading from every capturer, the capturers have to be initialized already
+func (mc *MultiCapturer) Init() {
+	if mc.ReorderWindow == 0 {
+		mc.ReorderWindow = DefaultReorderWindow
+	}
+	mc.frames = make(chan capturedFrame, 1024)
+	mc.running = len(mc.Capturers)
+	// The capturers keep running in between calls to Capture, so they can't be tied to the context of any of them
+	ctx, cancel := context.WithCancel(context.Background())
+	mc.cancel, mc.done = cancel, ctx.Done()
+	for _, capturer := range mc.Capturers {
+		go mc.run(ctx, capturer)
+	}
+}
+
+func (mc *MultiCapturer) run(ctx context.Context, capturer Capturer) {
+	for {
+		data, info, err := capturer.Capture(ctx)
+		select {
+		case mc.frames <- capturedFrame{data: data, info: info, err: err, received: time.Now()}:
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// add queues a frame, errors other than io.EOF are handed back right away
+func (mc *MultiCapturer) add(frame capturedFrame) error {
+	if frame.err == nil {
+		heap.Push(&mc.pending, frame)
+		return nil
+	}
+	mc.running--
+	if frame.err == io.EOF {
+		return nil
+	}
+	return frame.err
+}
+
+func (mc *MultiCapturer) Capture(ctx context.Context) ([]byte, units.CaptureInfo, error) {
+	for {
+		var timeout <-chan time.Time
+		if len(mc.pending) > 0 {
+			wait := time.Until(mc.pending[0].received.Add(mc.ReorderWindow))
+			if wait <= 0 || mc.running == 0 {
+				frame := heap.Pop(&mc.pending).(capturedFrame)
+				return frame.data, frame.info, nil
+			}
+			timeout = time.After(wait)
+		} else if mc.running == 0 {
+			return nil, units.CaptureInfo{}, io.EOF
+		}
+
+		select {
+		case frame := <-mc.frames:
+			if err := mc.add(frame); err != nil {
+				return nil, units.CaptureInfo{}, err
+			}
+		case <-timeout:
+		case <-ctx.Done():
+			return nil, units.CaptureInfo{}, ctx.Err()
+		case <-mc.done:
+			return nil, units.CaptureInfo{}, ErrClosed
+		}
+	}
+}
+
+// Stats adds up the Stats of the capturers that keep track of them
+func (mc *MultiCapturer) Stats() (Stats, error) {
+	var total Stats
+	for _, capturer := range mc.Capturers {
+		if reporter, ok := capturer.(StatsReporter); ok {
+			stats, err := reporter.Stats()
+			if err != nil {
+				return total, err
+			}
+			total = total.Add(stats)
+		}
+	}
+	return total, nil
+}
+
+func (mc *MultiCapturer) Close() error {
+	if mc.cancel != nil {
+		mc.cancel()
+	}
+	var firstErr error
+	for _, capturer := range mc.Capturers {
+		if err := capturer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
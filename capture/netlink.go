@@ -0,0 +1,142 @@
+package capture
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"packet_sniffer/utils"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// errLinkEventsLost is returned by LinkMonitor.Next when the kernel had to drop notifications because they weren't
+// read fast enough, the state of the interfaces has to be looked up again
+var errLinkEventsLost = errors.New("link notifications lost")
+
+// LinkEvent is a change of a network interface the kernel told about
+type LinkEvent struct {
+	Index   int
+	Name    string
+	Up      bool // administratively up and with a carrier
+	Removed bool
+}
+
+// LinkMonitor receives the rtnetlink notifications about the network interfaces of a network namespace, the ones
+// that are created, removed, renamed or go up or down
+type LinkMonitor struct {
+	sock int
+
+	mu     sync.Mutex // held by Next, so that Close doesn't pull the socket from under it
+	waker  waker
+	closed atomic.Bool
+}
+
+// Init subscribes to the notifications of the namespace of the file at the path, or of the process' own one when
+// empty
+func (m *LinkMonitor) Init(namespace string) error {
+	m.sock, m.waker = -1, waker{fd: -1}
+	err := utils.InNetworkNamespace(namespace, func() error {
+		sock, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+		if err != nil {
+			return err
+		}
+		m.sock = sock
+		return unix.Bind(sock, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: unix.RTMGRP_LINK})
+	})
+	if err != nil {
+		m.release()
+		return fmt.Errorf("failed to subscribe to link notifications: %v", err)
+	}
+	if m.waker, err = newWaker(); err != nil {
+		m.release()
+		return err
+	}
+	return nil
+}
+
+// Next waits for notifications until the context is done or the monitor is closed
+func (m *LinkMonitor) Next(ctx context.Context) ([]LinkEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	buf := make([]byte, 64*1024)
+	for {
+		if m.closed.Load() {
+			return nil, ErrClosed
+		}
+		n, _, err := unix.Recvfrom(m.sock, buf, unix.MSG_DONTWAIT)
+		if err == nil {
+			return parseLinkMessages(buf[:n])
+		}
+		if err == unix.ENOBUFS {
+			return nil, errLinkEventsLost
+		}
+		if err != unix.EAGAIN && err != unix.EINTR {
+			return nil, fmt.Errorf("failed to receive link notifications: %v", err)
+		}
+		if err := m.waker.wait(ctx, m.sock); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseLinkMessages picks the link changes out of rtnetlink messages, an ifinfomsg followed by attributes
+func parseLinkMessages(buf []byte) ([]LinkEvent, error) {
+	messages, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return nil, fmt.Errorf("malformed link notification: %v", err)
+	}
+	var events []LinkEvent
+	for _, message := range messages {
+		if message.Header.Type != unix.RTM_NEWLINK && message.Header.Type != unix.RTM_DELLINK {
+			continue
+		}
+		if len(message.Data) < unix.SizeofIfInfomsg {
+			continue
+		}
+		flags := binary.NativeEndian.Uint32(message.Data[8:12])
+		event := LinkEvent{
+			Index:   int(int32(binary.NativeEndian.Uint32(message.Data[4:8]))),
+			Up:      flags&unix.IFF_UP != 0 && flags&unix.IFF_RUNNING != 0,
+			Removed: message.Header.Type == unix.RTM_DELLINK,
+		}
+		attributes, err := syscall.ParseNetlinkRouteAttr(&message)
+		if err != nil {
+			continue
+		}
+		for _, attribute := range attributes {
+			if attribute.Attr.Type == unix.IFLA_IFNAME {
+				event.Name = strings.TrimRight(string(attribute.Value), "\x00")
+			}
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Close makes a Next waiting in another goroutine return ErrClosed and waits for it before closing the socket
+func (m *LinkMonitor) Close() error {
+	if m.closed.Swap(true) {
+		return nil
+	}
+	m.waker.wake()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.release()
+}
+
+func (m *LinkMonitor) release() error {
+	var err error
+	if m.sock >= 0 {
+		err = unix.Close(m.sock)
+		m.sock = -1
+	}
+	if wakerErr := m.waker.close(); err == nil {
+		err = wakerErr
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	units "packet_sniffer/model"
+	"time"
+)
+
+const (
+	pcapMagicMicroseconds uint32 = 0xa1b2c3d4
+	pcapMagicNanoseconds  uint32 = 0xa1b23c4d
+
+	pcapGlobalHeaderLength = 24
+	pcapRecordHeaderLength = 16
+
+	// Frames larger than this are considered a sign of a corrupted file rather than a jumbo frame
+	pcapMaxRecordLength = 256 * 1024
+)
+
+// PcapReader reads frames from a classic libpcap capture file
+type PcapReader struct {
+	file        *os.File
+	reader      *bufio.Reader
+	byteOrder   binary.ByteOrder
+	nanoseconds bool
+	snapLen     uint32
+	linkType    units.LinkType
+}
+
+func (r *PcapReader) Init(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.reader = bufio.NewReader(file)
+	if err := r.readGlobalHeader(); err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+func (r *PcapReader) readGlobalHeader() error {
+	buf := make([]byte, pcapGlobalHeaderLength)
+	if _, err := io.ReadFull(r.reader, buf); err != nil {
+		return fmt.Errorf("failed to read pcap header: %v", err)
+	}
+
+	// The magic number is written in the byte order of the host that produced the file
+	switch magic := binary.LittleEndian.Uint32(buf[0:4]); magic {
+	case pcapMagicMicroseconds:
+		r.byteOrder = binary.LittleEndian
+	case pcapMagicNanoseconds:
+		r.byteOrder, r.nanoseconds = binary.LittleEndian, true
+	default:
+		switch binary.BigEndian.Uint32(buf[0:4]) {
+		case pcapMagicMicroseconds:
+			r.byteOrder = binary.BigEndian
+		case pcapMagicNanoseconds:
+			r.byteOrder, r.nanoseconds = binary.BigEndian, true
+		default:
+			return fmt.Errorf("not a pcap file (magic %08x)", magic)
+		}
+	}
+
+	if major := r.byteOrder.Uint16(buf[4:6]); major != 2 {
+		return fmt.Errorf("unsupported pcap version %d.%d", major, r.byteOrder.Uint16(buf[6:8]))
+	}
+	r.snapLen = r.byteOrder.Uint32(buf[16:20])
+	// The upper 16 bits of the link type field may carry FCS information that we have no use for
+	r.linkType = units.LinkType(r.byteOrder.Uint32(buf[20:24]) & 0xffff)
+	return nil
+}
+
+func (r *PcapReader) LinkType() units.LinkType {
+	return r.linkType
+}
+
+func (r *PcapReader) SnapLen() int {
+	return int(r.snapLen)
+}
+
+// Capture returns the next frame along with the metadata stored in its record header, io.EOF is returned once
+// the file is exhausted
+func (r *PcapReader) Capture(ctx context.Context) ([]byte, units.CaptureInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, units.CaptureInfo{}, err
+	}
+	header := make([]byte, pcapRecordHeaderLength)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, units.CaptureInfo{}, fmt.Errorf("truncated pcap record header")
+		}
+		return nil, units.CaptureInfo{}, err
+	}
+
+	seconds := int64(r.byteOrder.Uint32(header[0:4]))
+	fraction := int64(r.byteOrder.Uint32(header[4:8]))
+	if !r.nanoseconds {
+		fraction *= int64(time.Microsecond)
+	}
+	captureLength := r.byteOrder.Uint32(header[8:12])
+	if captureLength > pcapMaxRecordLength {
+		return nil, units.CaptureInfo{}, fmt.Errorf("pcap record of %d bytes exceeds the %d bytes limit", captureLength, pcapMaxRecordLength)
+	}
+
+	data := make([]byte, captureLength)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, units.CaptureInfo{}, fmt.Errorf("truncated pcap record: %v", err)
+	}
+	return data, units.CaptureInfo{
+		Timestamp:     time.Unix(seconds, fraction),
+		CaptureLength: int(captureLength),
+		Length:        int(r.byteOrder.Uint32(header[12:16])),
+		LinkType:      r.linkType,
+	}, nil
+}
+
+func (r *PcapReader) Close() error {
+	return r.file.Close()
+}
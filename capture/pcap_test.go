@@ -0,0 +1,236 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	units "packet_sniffer/model"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sampleFrame returns a frame of the given length whose bytes count up, so that truncations are easy to spot
+func sampleFrame(length int) []byte {
+	frame := make([]byte, length)
+	for i := range frame {
+		frame[i] = byte(i)
+	}
+	return frame
+}
+
+// readAll reads every frame of a capture file
+func readAll(t *testing.T, reader FileReader) ([][]byte, []units.CaptureInfo) {
+	t.Helper()
+	var frames [][]byte
+	var infos []units.CaptureInfo
+	for {
+		data, info, err := reader.Capture(context.Background())
+		if err == io.EOF {
+			return frames, infos
+		}
+		if err != nil {
+			t.Fatalf("frame %d: %v", len(frames), err)
+		}
+		frames = append(frames, data)
+		infos = append(infos, info)
+	}
+}
+
+func TestPcapRoundTrip(t *testing.T) {
+	tests := []struct {
+		name          string
+		snapLen       int
+		length        int // bytes handed to the writer
+		wireLength    int // length on the wire reported by the capturer
+		timestamp     time.Time
+		wantCaptured  int
+		wantLength    int
+		wantTimestamp time.Time
+	}{
+		{
+			name: "whole frame", snapLen: 65535, length: 60, wireLength: 60,
+			timestamp: time.Unix(1700000000, 123456000), wantCaptured: 60, wantLength: 60,
+			wantTimestamp: time.Unix(1700000000, 123456000),
+		},
+		{
+			name: "frame cut by the capturer", snapLen: 65535, length: 96, wireLength: 1514,
+			timestamp: time.Unix(1700000000, 0), wantCaptured: 96, wantLength: 1514,
+			wantTimestamp: time.Unix(1700000000, 0),
+		},
+		{
+			name: "frame cut by the writer", snapLen: 64, length: 1514, wireLength: 1514,
+			timestamp: time.Unix(1700000000, 999999000), wantCaptured: 64, wantLength: 1514,
+			wantTimestamp: time.Unix(1700000000, 999999000),
+		},
+		{
+			name: "frame of exactly the snaplen", snapLen: 64, length: 64, wireLength: 64,
+			timestamp: time.Unix(1, 1000), wantCaptured: 64, wantLength: 64,
+			wantTimestamp: time.Unix(1, 1000),
+		},
+		{
+			// The wire length can't be less than what was captured
+			name: "missing wire length", snapLen: 65535, length: 42, wireLength: 0,
+			timestamp: time.Unix(1700000000, 0), wantCaptured: 42, wantLength: 42,
+			wantTimestamp: time.Unix(1700000000, 0),
+		},
+		{
+			// Files are written with microsecond timestamps, the nanoseconds are dropped
+			name: "nanosecond timestamp", snapLen: 65535, length: 60, wireLength: 60,
+			timestamp: time.Unix(1700000000, 123456789), wantCaptured: 60, wantLength: 60,
+			wantTimestamp: time.Unix(1700000000, 123456000),
+		},
+		{
+			name: "empty frame", snapLen: 65535, length: 0, wireLength: 0,
+			timestamp: time.Unix(1700000000, 0), wantCaptured: 0, wantLength: 0,
+			wantTimestamp: time.Unix(1700000000, 0),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "capture.pcap")
+			writer, err := CreateFile(path, units.LinkTypeEthernet, test.snapLen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frame := sampleFrame(test.length)
+			info := units.CaptureInfo{Timestamp: test.timestamp, CaptureLength: test.length, Length: test.wireLength}
+			if err := writer.WritePacket(info, frame); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			reader, err := OpenFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			if _, ok := reader.(*PcapReader); !ok {
+				t.Fatalf("got a %T", reader)
+			}
+			if reader.LinkType() != units.LinkTypeEthernet || reader.SnapLen() != test.snapLen {
+				t.Errorf("got link type %d and snaplen %d", reader.LinkType(), reader.SnapLen())
+			}
+			frames, infos := readAll(t, reader)
+			if len(frames) != 1 {
+				t.Fatalf("got %d frames", len(frames))
+			}
+			if !bytes.Equal(frames[0], frame[:test.wantCaptured]) {
+				t.Errorf("got frame % x", frames[0])
+			}
+			got := infos[0]
+			if got.CaptureLength != test.wantCaptured || got.Length != test.wantLength {
+				t.Errorf("got lengths %d/%d, want %d/%d", got.CaptureLength, got.Length, test.wantCaptured, test.wantLength)
+			}
+			if !got.Timestamp.Equal(test.wantTimestamp) {
+				t.Errorf("got timestamp %v, want %v", got.Timestamp, test.wantTimestamp)
+			}
+			if got.LinkType != units.LinkTypeEthernet {
+				t.Errorf("got link type %d", got.LinkType)
+			}
+		})
+	}
+}
+
+// pcapFile builds a capture file holding a single 4 bytes frame the way another host would have written it
+func pcapFile(byteOrder binary.ByteOrder, magic uint32, seconds uint32, fraction uint32) []byte {
+	file := make([]byte, pcapGlobalHeaderLength+pcapRecordHeaderLength+4)
+	byteOrder.PutUint32(file[0:4], magic)
+	byteOrder.PutUint16(file[4:6], 2)
+	byteOrder.PutUint16(file[6:8], 4)
+	byteOrder.PutUint32(file[16:20], 262144)
+	byteOrder.PutUint32(file[20:24], uint32(units.LinkTypeLinuxSLL))
+	record := file[pcapGlobalHeaderLength:]
+	byteOrder.PutUint32(record[0:4], seconds)
+	byteOrder.PutUint32(record[4:8], fraction)
+	byteOrder.PutUint32(record[8:12], 4)
+	byteOrder.PutUint32(record[12:16], 4)
+	copy(record[pcapRecordHeaderLength:], []byte{0xde, 0xad, 0xbe, 0xef})
+	return file
+}
+
+func writeTemp(t *testing.T, contents []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "capture")
+	if err := os.WriteFile(path, contents, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPcapReaderTimestampResolutions(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      []byte
+		timestamp time.Time
+	}{
+		{"little endian microseconds", pcapFile(binary.LittleEndian, pcapMagicMicroseconds, 1700000000, 999999), time.Unix(1700000000, 999999000)},
+		{"big endian microseconds", pcapFile(binary.BigEndian, pcapMagicMicroseconds, 1700000000, 1), time.Unix(1700000000, 1000)},
+		{"little endian nanoseconds", pcapFile(binary.LittleEndian, pcapMagicNanoseconds, 1700000000, 999999999), time.Unix(1700000000, 999999999)},
+		{"big endian nanoseconds", pcapFile(binary.BigEndian, pcapMagicNanoseconds, 1700000000, 1), time.Unix(1700000000, 1)},
+		{"last second of 32 bits", pcapFile(binary.LittleEndian, pcapMagicNanoseconds, 0xffffffff, 0), time.Unix(0xffffffff, 0)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, err := OpenFile(writeTemp(t, test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			if reader.LinkType() != units.LinkTypeLinuxSLL || reader.SnapLen() != 262144 {
+				t.Errorf("got link type %d and snaplen %d", reader.LinkType(), reader.SnapLen())
+			}
+			frames, infos := readAll(t, reader)
+			if len(frames) != 1 || !bytes.Equal(frames[0], []byte{0xde, 0xad, 0xbe, 0xef}) {
+				t.Fatalf("got frames %x", frames)
+			}
+			if !infos[0].Timestamp.Equal(test.timestamp) {
+				t.Errorf("got timestamp %v, want %v", infos[0].Timestamp, test.timestamp)
+			}
+			if infos[0].CaptureLength != 4 || infos[0].Length != 4 {
+				t.Errorf("got lengths %d/%d", infos[0].CaptureLength, infos[0].Length)
+			}
+		})
+	}
+}
+
+func TestPcapReaderRejectsMalformedFiles(t *testing.T) {
+	valid := pcapFile(binary.LittleEndian, pcapMagicMicroseconds, 0, 0)
+	withRecordLength := func(length uint32) []byte {
+		file := append([]byte{}, valid...)
+		binary.LittleEndian.PutUint32(file[pcapGlobalHeaderLength+8:], length)
+		return file
+	}
+	withVersion := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint16(withVersion[4:6], 3)
+
+	tests := []struct {
+		name string
+		file []byte
+		want string // in the error of OpenFile, or else of the first Capture
+	}{
+		{"empty file", nil, "failed to read pcap header"},
+		{"bad magic", append([]byte{1, 2, 3, 4}, valid[4:]...), "not a pcap file"},
+		{"unsupported version", withVersion, "unsupported pcap version 3.4"},
+		{"truncated record header", valid[:pcapGlobalHeaderLength+8], "truncated pcap record header"},
+		{"truncated record", valid[:len(valid)-1], "truncated pcap record"},
+		{"oversized record", withRecordLength(pcapMaxRecordLength + 1), "exceeds the 262144 bytes limit"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, err := OpenFile(writeTemp(t, test.file))
+			if err == nil {
+				defer reader.Close()
+				_, _, err = reader.Capture(context.Background())
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error about %q", err, test.want)
+			}
+		})
+	}
+}
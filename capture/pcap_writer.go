@@ -0,0 +1,68 @@
+package capture
+
+import (
+	"encoding/binary"
+	"os"
+	units "packet_sniffer/model"
+)
+
+// PcapWriter writes frames to a classic libpcap capture file with microsecond timestamps, which every tool can read
+type PcapWriter struct {
+	file    *os.File
+	snapLen int
+	size    int64
+}
+
+func (w *PcapWriter) Init(path string, linkType units.LinkType, snapLen int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.snapLen = snapLen
+
+	header := make([]byte, pcapGlobalHeaderLength)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagicMicroseconds)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	// thiszone and sigfigs are always zero in practice
+	binary.LittleEndian.PutUint32(header[16:20], uint32(snapLen))
+	binary.LittleEndian.PutUint32(header[20:24], uint32(linkType))
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return err
+	}
+	w.size = int64(len(header))
+	return nil
+}
+
+// WritePacket appends a record to the file. The record is written with a single call so that an interrupted capture
+// leaves behind a file that is readable up to the last complete frame
+func (w *PcapWriter) WritePacket(info units.CaptureInfo, data []byte) error {
+	if len(data) > w.snapLen {
+		data = data[:w.snapLen]
+	}
+	length := info.Length
+	if length < len(data) {
+		length = len(data)
+	}
+
+	record := make([]byte, pcapRecordHeaderLength+len(data))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(info.Timestamp.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(info.Timestamp.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(length))
+	copy(record[pcapRecordHeaderLength:], data)
+	n, err := w.file.Write(record)
+	w.size += int64(n)
+	return err
+}
+
+// Size is the number of bytes written to the file so far
+func (w *PcapWriter) Size() int64 {
+	return w.size
+}
+
+func (w *PcapWriter) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,457 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	units "packet_sniffer/model"
+	"time"
+)
+
+const (
+	pcapngSectionHeaderBlock        uint32 = 0x0A0D0D0A
+	pcapngInterfaceDescriptionBlock uint32 = 0x00000001
+	pcapngObsoletePacketBlock       uint32 = 0x00000002
+	pcapngSimplePacketBlock         uint32 = 0x00000003
+	pcapngNameResolutionBlock       uint32 = 0x00000004
+	pcapngEnhancedPacketBlock       uint32 = 0x00000006
+
+	pcapngByteOrderMagic uint32 = 0x1A2B3C4D
+
+	// Options shared by every block type
+	pcapngOptionEnd     uint16 = 0
+	pcapngOptionComment uint16 = 1
+
+	pcapngSectionOptionOS          uint16 = 3
+	pcapngSectionOptionApplication uint16 = 4
+
+	pcapngPacketOptionFlags uint16 = 2
+
+	pcapngInterfaceOptionName         uint16 = 2
+	pcapngInterfaceOptionDescription  uint16 = 3
+	pcapngInterfaceOptionTSResolution uint16 = 9
+	pcapngInterfaceOptionTSOffset     uint16 = 14
+
+	pcapngNameRecordEnd  uint16 = 0
+	pcapngNameRecordIPv4 uint16 = 1
+	pcapngNameRecordIPv6 uint16 = 2
+
+	// Blocks larger than this are considered a sign of a corrupted file
+	pcapngMaxBlockLength = 16 * 1024 * 1024
+)
+
+type PcapngInterface struct {
+	Name        string
+	Description string
+	LinkType    units.LinkType
+	SnapLen     int
+
+	// Timestamps are expressed in units of 1/tsUnitsPerSecond seconds, offset by tsOffset seconds
+	tsUnitsPerSecond uint64
+	tsOffset         int64
+}
+
+// PcapngReader reads frames from a pcapng capture file. Files made of several sections are read through, the
+// interfaces of a section are forgotten once the next one starts
+type PcapngReader struct {
+	file          *os.File
+	reader        *bufio.Reader
+	byteOrder     binary.ByteOrder
+	interfaces    []PcapngInterface
+	comments      []string
+	resolvedNames map[string][]string
+}
+
+func (r *PcapngReader) Init(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.reader = bufio.NewReader(file)
+	r.resolvedNames = make(map[string][]string)
+
+	blockType, body, err := r.readBlock()
+	if err == nil && blockType != pcapngSectionHeaderBlock {
+		err = fmt.Errorf("not a pcapng file")
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	r.readSectionHeader(body)
+
+	// The link type is only known after the first interface has been described
+	for len(r.interfaces) == 0 {
+		blockType, body, err := r.readBlock()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if _, _, err := r.processBlock(blockType, body); err != nil {
+			file.Close()
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// readBlock returns the body of the next block, the byte order of a section is figured out from its header block
+func (r *PcapngReader) readBlock() (uint32, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("truncated pcapng block header")
+		}
+		return 0, nil, err
+	}
+
+	// The section header block type reads the same in both byte orders
+	if binary.LittleEndian.Uint32(header[0:4]) == pcapngSectionHeaderBlock {
+		magic, err := r.reader.Peek(4)
+		if err != nil {
+			return 0, nil, fmt.Errorf("truncated pcapng section header: %v", err)
+		}
+		switch pcapngByteOrderMagic {
+		case binary.LittleEndian.Uint32(magic):
+			r.byteOrder = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic):
+			r.byteOrder = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("invalid pcapng byte order magic %08x", binary.BigEndian.Uint32(magic))
+		}
+	}
+	if r.byteOrder == nil {
+		return 0, nil, fmt.Errorf("pcapng block found before the section header")
+	}
+
+	blockType := r.byteOrder.Uint32(header[0:4])
+	length := r.byteOrder.Uint32(header[4:8])
+	if length < 12 || length%4 != 0 || length > pcapngMaxBlockLength {
+		return 0, nil, fmt.Errorf("invalid pcapng block length %d", length)
+	}
+	block := make([]byte, length-8)
+	if _, err := io.ReadFull(r.reader, block); err != nil {
+		return 0, nil, fmt.Errorf("truncated pcapng block: %v", err)
+	}
+	if trailer := r.byteOrder.Uint32(block[len(block)-4:]); trailer != length {
+		return 0, nil, fmt.Errorf("pcapng block length mismatch (%d at the start, %d at the end)", length, trailer)
+	}
+	return blockType, block[:len(block)-4], nil
+}
+
+func (r *PcapngReader) readSectionHeader(body []byte) {
+	r.interfaces = nil
+	r.comments = nil
+	// byte order magic, major and minor version and section length precede the options
+	if len(body) < 16 {
+		return
+	}
+	r.forEachOption(body[16:], func(code uint16, value []byte) {
+		if code == pcapngOptionComment {
+			r.comments = append(r.comments, string(value))
+		}
+	})
+}
+
+// processBlock keeps track of the section state, the returned frame is nil unless the block carried one
+func (r *PcapngReader) processBlock(blockType uint32, body []byte) ([]byte, units.CaptureInfo, error) {
+	switch blockType {
+	case pcapngSectionHeaderBlock:
+		r.readSectionHeader(body)
+	case pcapngInterfaceDescriptionBlock:
+		return nil, units.CaptureInfo{}, r.readInterfaceDescription(body)
+	case pcapngNameResolutionBlock:
+		r.readNameResolution(body)
+	case pcapngEnhancedPacketBlock:
+		return r.readEnhancedPacket(body)
+	case pcapngSimplePacketBlock:
+		return r.readSimplePacket(body)
+	case pcapngObsoletePacketBlock:
+		return r.readObsoletePacket(body)
+	}
+	// Statistics and custom blocks are of no use to us
+	return nil, units.CaptureInfo{}, nil
+}
+
+func (r *PcapngReader) forEachOption(options []byte, handle func(code uint16, value []byte)) {
+	for len(options) >= 4 {
+		code := r.byteOrder.Uint16(options[0:2])
+		length := int(r.byteOrder.Uint16(options[2:4]))
+		if code == pcapngOptionEnd || 4+length > len(options) {
+			return
+		}
+		handle(code, options[4:4+length])
+		options = options[4+pcapngPadding(length):]
+	}
+}
+
+func pcapngPadding(length int) int {
+	return (length + 3) &^ 3
+}
+
+func (r *PcapngReader) readInterfaceDescription(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("truncated pcapng interface description")
+	}
+	iface := PcapngInterface{
+		LinkType:         units.LinkType(r.byteOrder.Uint16(body[0:2])),
+		SnapLen:          int(r.byteOrder.Uint32(body[4:8])),
+		tsUnitsPerSecond: 1000000,
+	}
+	var err error
+	r.forEachOption(body[8:], func(code uint16, value []byte) {
+		switch code {
+		case pcapngInterfaceOptionName:
+			iface.Name = string(value)
+		case pcapngInterfaceOptionDescription:
+			iface.Description = string(value)
+		case pcapngInterfaceOptionTSOffset:
+			if len(value) == 8 {
+				iface.tsOffset = int64(r.byteOrder.Uint64(value))
+			}
+		case pcapngInterfaceOptionTSResolution:
+			if len(value) != 1 {
+				return
+			}
+			// The most significant bit tells whether the rest is a negative power of two or of ten
+			exponent := value[0] & 0x7f
+			if value[0]&0x80 != 0 {
+				if exponent > 63 {
+					err = fmt.Errorf("unsupported pcapng timestamp resolution 2^-%d", exponent)
+					return
+				}
+				iface.tsUnitsPerSecond = 1 << exponent
+			} else {
+				if exponent > 19 {
+					err = fmt.Errorf("unsupported pcapng timestamp resolution 10^-%d", exponent)
+					return
+				}
+				iface.tsUnitsPerSecond = uint64(math.Pow10(int(exponent)))
+			}
+		}
+	})
+	r.interfaces = append(r.interfaces, iface)
+	return err
+}
+
+func (r *PcapngReader) readNameResolution(body []byte) {
+	for len(body) >= 4 {
+		recordType := r.byteOrder.Uint16(body[0:2])
+		length := int(r.byteOrder.Uint16(body[2:4]))
+		if recordType == pcapngNameRecordEnd || 4+length > len(body) {
+			break
+		}
+		value := body[4 : 4+length]
+		addressLength := 0
+		switch recordType {
+		case pcapngNameRecordIPv4:
+			addressLength = net.IPv4len
+		case pcapngNameRecordIPv6:
+			addressLength = net.IPv6len
+		}
+		if addressLength != 0 && len(value) > addressLength {
+			address := net.IP(value[:addressLength]).String()
+			// Names are zero terminated strings following the address
+			start := addressLength
+			for i := start; i < len(value); i++ {
+				if value[i] == 0 {
+					if i > start {
+						r.resolvedNames[address] = append(r.resolvedNames[address], string(value[start:i]))
+					}
+					start = i + 1
+				}
+			}
+		}
+		body = body[4+pcapngPadding(length):]
+	}
+}
+
+func (r *PcapngReader) packetInfo(interfaceID int, timestamp uint64, captureLength int, length int) (units.CaptureInfo, error) {
+	if interfaceID >= len(r.interfaces) {
+		return units.CaptureInfo{}, fmt.Errorf("pcapng packet refers to the undescribed interface %d", interfaceID)
+	}
+	iface := r.interfaces[interfaceID]
+	seconds := timestamp / iface.tsUnitsPerSecond
+	fraction := timestamp % iface.tsUnitsPerSecond
+	nanoseconds := fraction * uint64(time.Second) / iface.tsUnitsPerSecond
+	if iface.tsUnitsPerSecond > uint64(time.Second) {
+		// The multiplication above may overflow for resolutions finer than a nanosecond
+		nanoseconds = fraction / (iface.tsUnitsPerSecond / uint64(time.Second))
+	}
+	return units.CaptureInfo{
+		Timestamp:      time.Unix(int64(seconds)+iface.tsOffset, int64(nanoseconds)),
+		CaptureLength:  captureLength,
+		Length:         length,
+		LinkType:       iface.LinkType,
+		InterfaceIndex: interfaceID,
+		InterfaceName:  iface.Name,
+	}, nil
+}
+
+func (r *PcapngReader) readEnhancedPacket(body []byte) ([]byte, units.CaptureInfo, error) {
+	if len(body) < 20 {
+		return nil, units.CaptureInfo{}, fmt.Errorf("truncated pcapng enhanced packet")
+	}
+	captureLength := int(r.byteOrder.Uint32(body[12:16]))
+	if 20+captureLength > len(body) {
+		return nil, units.CaptureInfo{}, fmt.Errorf("pcapng enhanced packet of %d bytes exceeds its block", captureLength)
+	}
+	timestamp := uint64(r.byteOrder.Uint32(body[4:8]))<<32 | uint64(r.byteOrder.Uint32(body[8:12]))
+	info, err := r.packetInfo(int(r.byteOrder.Uint32(body[0:4])), timestamp, captureLength, int(r.byteOrder.Uint32(body[16:20])))
+	if err != nil {
+		return nil, units.CaptureInfo{}, err
+	}
+	r.forEachOption(body[20+pcapngPadding(captureLength):], func(code uint16, value []byte) {
+		switch code {
+		case pcapngOptionComment:
+			info.Comments = append(info.Comments, string(value))
+		case pcapngPacketOptionFlags:
+			if len(value) == 4 {
+				info.PacketType = pcapngPacketType(r.byteOrder.Uint32(value))
+			}
+		}
+	})
+	return body[20 : 20+captureLength], info, nil
+}
+
+// The lowest bits of epb_flags tell the direction of a frame, followed by the way it was received
+const (
+	pcapngDirectionInbound  = 1
+	pcapngDirectionOutbound = 2
+
+	pcapngReceptionUnicast     = 1
+	pcapngReceptionMulticast   = 2
+	pcapngReceptionBroadcast   = 3
+	pcapngReceptionPromiscuous = 4
+)
+
+func pcapngPacketType(flags uint32) units.PacketType {
+	if flags&0x3 == pcapngDirectionOutbound {
+		return units.PacketTypeOutgoing
+	}
+	switch flags >> 2 & 0x7 {
+	case pcapngReceptionUnicast:
+		return units.PacketTypeHost
+	case pcapngReceptionMulticast:
+		return units.PacketTypeMulticast
+	case pcapngReceptionBroadcast:
+		return units.PacketTypeBroadcast
+	case pcapngReceptionPromiscuous:
+		return units.PacketTypeOtherHost
+	}
+	if flags&0x3 == pcapngDirectionInbound {
+		return units.PacketTypeHost
+	}
+	return units.PacketTypeUnknown
+}
+
+func pcapngFlags(packetType units.PacketType) uint32 {
+	switch packetType {
+	case units.PacketTypeOutgoing:
+		return pcapngDirectionOutbound
+	case units.PacketTypeHost:
+		return pcapngDirectionInbound | pcapngReceptionUnicast<<2
+	case units.PacketTypeMulticast:
+		return pcapngDirectionInbound | pcapngReceptionMulticast<<2
+	case units.PacketTypeBroadcast:
+		return pcapngDirectionInbound | pcapngReceptionBroadcast<<2
+	case units.PacketTypeOtherHost:
+		return pcapngDirectionInbound | pcapngReceptionPromiscuous<<2
+	}
+	return 0
+}
+
+func (r *PcapngReader) readObsoletePacket(body []byte) ([]byte, units.CaptureInfo, error) {
+	if len(body) < 20 {
+		return nil, units.CaptureInfo{}, fmt.Errorf("truncated pcapng packet")
+	}
+	captureLength := int(r.byteOrder.Uint32(body[12:16]))
+	if 20+captureLength > len(body) {
+		return nil, units.CaptureInfo{}, fmt.Errorf("pcapng packet of %d bytes exceeds its block", captureLength)
+	}
+	timestamp := uint64(r.byteOrder.Uint32(body[4:8]))<<32 | uint64(r.byteOrder.Uint32(body[8:12]))
+	info, err := r.packetInfo(int(r.byteOrder.Uint16(body[0:2])), timestamp, captureLength, int(r.byteOrder.Uint32(body[16:20])))
+	if err != nil {
+		return nil, units.CaptureInfo{}, err
+	}
+	return body[20 : 20+captureLength], info, nil
+}
+
+// readSimplePacket reads a block that has neither a timestamp nor an interface ID, the first interface is implied
+func (r *PcapngReader) readSimplePacket(body []byte) ([]byte, units.CaptureInfo, error) {
+	if len(body) < 4 {
+		return nil, units.CaptureInfo{}, fmt.Errorf("truncated pcapng simple packet")
+	}
+	length := int(r.byteOrder.Uint32(body[0:4]))
+	captureLength := min(length, len(body)-4)
+	if len(r.interfaces) > 0 && r.interfaces[0].SnapLen > 0 {
+		captureLength = min(captureLength, r.interfaces[0].SnapLen)
+	}
+	info, err := r.packetInfo(0, 0, captureLength, length)
+	if err != nil {
+		return nil, units.CaptureInfo{}, err
+	}
+	info.Timestamp = time.Time{}
+	return body[4 : 4+captureLength], info, nil
+}
+
+// LinkType returns the link type of the first interface of the current section
+func (r *PcapngReader) LinkType() units.LinkType {
+	if len(r.interfaces) == 0 {
+		return units.LinkTypeEthernet
+	}
+	return r.interfaces[0].LinkType
+}
+
+func (r *PcapngReader) SnapLen() int {
+	if len(r.interfaces) == 0 || r.interfaces[0].SnapLen == 0 {
+		return DefaultSnapLen
+	}
+	return r.interfaces[0].SnapLen
+}
+
+func (r *PcapngReader) Interfaces() []PcapngInterface {
+	return r.interfaces
+}
+
+// Comments returns the comments attached to the current section
+func (r *PcapngReader) Comments() []string {
+	return r.comments
+}
+
+// ResolvedNames maps addresses to the host names recorded in the name resolution blocks read so far
+func (r *PcapngReader) ResolvedNames() map[string][]string {
+	return r.resolvedNames
+}
+
+func (r *PcapngReader) Capture(ctx context.Context) ([]byte, units.CaptureInfo, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, units.CaptureInfo{}, err
+		}
+		blockType, body, err := r.readBlock()
+		if err != nil {
+			return nil, units.CaptureInfo{}, err
+		}
+		data, info, err := r.processBlock(blockType, body)
+		if err != nil {
+			return nil, units.CaptureInfo{}, err
+		}
+		if data != nil {
+			return data, info, nil
+		}
+	}
+}
+
+func (r *PcapngReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,302 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	units "packet_sniffer/model"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPcapngRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	writer := PcapngWriter{Comment: "test section"}
+	if err := writer.Init(path, 128); err != nil {
+		t.Fatal(err)
+	}
+	frames := []struct {
+		info units.CaptureInfo
+		data []byte
+		want []byte // the captured bytes read back
+	}{
+		{
+			info: units.CaptureInfo{Timestamp: time.Unix(1700000000, 123456789), Length: 60, LinkType: units.LinkTypeEthernet,
+				PacketType: units.PacketTypeHost, InterfaceIndex: 2, InterfaceName: "eth0"},
+			data: sampleFrame(60), want: sampleFrame(60),
+		},
+		{
+			// Cut to the snaplen of the writer, with padding after an odd length
+			info: units.CaptureInfo{Timestamp: time.Unix(1700000000, 1), Length: 1514, LinkType: units.LinkTypeEthernet,
+				PacketType: units.PacketTypeOutgoing, InterfaceIndex: 2, InterfaceName: "eth0", Comments: []string{"cut", "twice commented"}},
+			data: sampleFrame(1514), want: sampleFrame(128),
+		},
+		{
+			info: units.CaptureInfo{Timestamp: time.Unix(1700000001, 999999999), Length: 45, LinkType: units.LinkTypeLinuxSLL,
+				PacketType: units.PacketTypeBroadcast, InterfaceIndex: 1, InterfaceName: "lo"},
+			data: sampleFrame(45), want: sampleFrame(45),
+		},
+		{
+			// Frames without a packet type are written without options
+			info: units.CaptureInfo{Timestamp: time.Unix(1700000002, 0), Length: 0, LinkType: units.LinkTypeEthernet,
+				InterfaceIndex: 2, InterfaceName: "eth0"},
+			data: sampleFrame(33), want: sampleFrame(33),
+		},
+	}
+	for _, frame := range frames {
+		if err := writer.WritePacket(frame.info, frame.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := map[string][]string{"10.0.0.1": {"gateway", "router.lan"}, "fe80::1": {"gateway6"}}
+	if err := writer.WriteNameResolution(names); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	reader, ok := opened.(*PcapngReader)
+	if !ok {
+		t.Fatalf("got a %T", opened)
+	}
+	if reader.LinkType() != units.LinkTypeEthernet || reader.SnapLen() != 128 {
+		t.Errorf("got link type %d and snaplen %d", reader.LinkType(), reader.SnapLen())
+	}
+	if !reflect.DeepEqual(reader.Comments(), []string{"test section"}) {
+		t.Errorf("got section comments %q", reader.Comments())
+	}
+
+	got, infos := readAll(t, reader)
+	if len(got) != len(frames) {
+		t.Fatalf("got %d frames, want %d", len(got), len(frames))
+	}
+	// Interfaces are numbered in the order they first show up
+	ids := []int{0, 0, 1, 0}
+	for i, frame := range frames {
+		if !bytes.Equal(got[i], frame.want) {
+			t.Errorf("frame %d: got % x", i, got[i])
+		}
+		info := infos[i]
+		wantLength := max(frame.info.Length, len(frame.want))
+		if info.CaptureLength != len(frame.want) || info.Length != wantLength {
+			t.Errorf("frame %d: got lengths %d/%d, want %d/%d", i, info.CaptureLength, info.Length, len(frame.want), wantLength)
+		}
+		if !info.Timestamp.Equal(frame.info.Timestamp) {
+			t.Errorf("frame %d: got timestamp %v, want %v", i, info.Timestamp, frame.info.Timestamp)
+		}
+		if info.LinkType != frame.info.LinkType || info.PacketType != frame.info.PacketType {
+			t.Errorf("frame %d: got link type %d and packet type %d", i, info.LinkType, info.PacketType)
+		}
+		if info.InterfaceIndex != ids[i] || info.InterfaceName != frame.info.InterfaceName {
+			t.Errorf("frame %d: got interface %d (%s)", i, info.InterfaceIndex, info.InterfaceName)
+		}
+		if !reflect.DeepEqual(info.Comments, frame.info.Comments) {
+			t.Errorf("frame %d: got comments %q", i, info.Comments)
+		}
+	}
+
+	interfaces := reader.Interfaces()
+	if len(interfaces) != 2 || interfaces[0].Name != "eth0" || interfaces[1].Name != "lo" ||
+		interfaces[1].LinkType != units.LinkTypeLinuxSLL || interfaces[1].SnapLen != 128 {
+		t.Errorf("got interfaces %+v", interfaces)
+	}
+	if !reflect.DeepEqual(reader.ResolvedNames(), names) {
+		t.Errorf("got resolved names %q", reader.ResolvedNames())
+	}
+}
+
+type pcapngByteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// pcapngBuilder writes blocks the way other tools may, in either byte order
+type pcapngBuilder struct {
+	byteOrder pcapngByteOrder
+	file      []byte
+}
+
+func (b *pcapngBuilder) option(options []byte, code uint16, value []byte) []byte {
+	options = b.byteOrder.AppendUint16(options, code)
+	options = b.byteOrder.AppendUint16(options, uint16(len(value)))
+	options = append(options, value...)
+	return append(options, make([]byte, pcapngPadding(len(value))-len(value))...)
+}
+
+func (b *pcapngBuilder) block(blockType uint32, body []byte) *pcapngBuilder {
+	length := uint32(12 + len(body))
+	b.file = b.byteOrder.AppendUint32(b.file, blockType)
+	b.file = b.byteOrder.AppendUint32(b.file, length)
+	b.file = append(b.file, body...)
+	b.file = b.byteOrder.AppendUint32(b.file, length)
+	return b
+}
+
+func (b *pcapngBuilder) section() *pcapngBuilder {
+	body := b.byteOrder.AppendUint32(nil, pcapngByteOrderMagic)
+	body = b.byteOrder.AppendUint16(body, 1)
+	body = b.byteOrder.AppendUint16(body, 0)
+	body = b.byteOrder.AppendUint64(body, 0xffffffffffffffff)
+	return b.block(pcapngSectionHeaderBlock, body)
+}
+
+// iface describes an interface, options are given as code and value pairs
+func (b *pcapngBuilder) iface(linkType units.LinkType, snapLen uint32, options ...any) *pcapngBuilder {
+	body := b.byteOrder.AppendUint16(nil, uint16(linkType))
+	body = b.byteOrder.AppendUint16(body, 0)
+	body = b.byteOrder.AppendUint32(body, snapLen)
+	for i := 0; i+1 < len(options); i += 2 {
+		body = b.option(body, options[i].(uint16), options[i+1].([]byte))
+	}
+	if len(options) > 0 {
+		body = b.option(body, pcapngOptionEnd, nil)
+	}
+	return b.block(pcapngInterfaceDescriptionBlock, body)
+}
+
+func (b *pcapngBuilder) packet(interfaceID uint32, timestamp uint64, data []byte) *pcapngBuilder {
+	body := b.byteOrder.AppendUint32(nil, interfaceID)
+	body = b.byteOrder.AppendUint32(body, uint32(timestamp>>32))
+	body = b.byteOrder.AppendUint32(body, uint32(timestamp))
+	body = b.byteOrder.AppendUint32(body, uint32(len(data)))
+	body = b.byteOrder.AppendUint32(body, uint32(len(data)))
+	body = append(body, data...)
+	body = append(body, make([]byte, pcapngPadding(len(data))-len(data))...)
+	return b.block(pcapngEnhancedPacketBlock, body)
+}
+
+func (b *pcapngBuilder) simplePacket(length uint32, data []byte) *pcapngBuilder {
+	body := b.byteOrder.AppendUint32(nil, length)
+	body = append(body, data...)
+	body = append(body, make([]byte, pcapngPadding(len(data))-len(data))...)
+	return b.block(pcapngSimplePacketBlock, body)
+}
+
+func TestPcapngReaderTimestampResolutions(t *testing.T) {
+	offset := binary.LittleEndian.AppendUint64(nil, 1700000000)
+	tests := []struct {
+		name      string
+		byteOrder pcapngByteOrder
+		options   []any
+		timestamp uint64
+		want      time.Time
+	}{
+		{"default microseconds", binary.LittleEndian, nil, 1700000000_123456, time.Unix(1700000000, 123456000)},
+		{"microseconds", binary.BigEndian, []any{pcapngInterfaceOptionTSResolution, []byte{6}}, 1700000000_000001, time.Unix(1700000000, 1000)},
+		{"nanoseconds", binary.LittleEndian, []any{pcapngInterfaceOptionTSResolution, []byte{9}}, 1700000000_999999999, time.Unix(1700000000, 999999999)},
+		{"milliseconds", binary.BigEndian, []any{pcapngInterfaceOptionTSResolution, []byte{3}}, 1700000000_500, time.Unix(1700000000, 500000000)},
+		{"power of two", binary.LittleEndian, []any{pcapngInterfaceOptionTSResolution, []byte{0x80 | 10}}, 5<<10 | 512, time.Unix(5, 500000000)},
+		// Finer than the nanoseconds of time.Time, the rest is dropped
+		{"picoseconds", binary.LittleEndian, []any{pcapngInterfaceOptionTSResolution, []byte{12}}, 5_123456789999, time.Unix(5, 123456789)},
+		{"offset", binary.LittleEndian, []any{pcapngInterfaceOptionTSOffset, offset}, 1_000001, time.Unix(1700000001, 1000)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := &pcapngBuilder{byteOrder: test.byteOrder}
+			b.section().iface(units.LinkTypeEthernet, 0, test.options...).packet(0, test.timestamp, []byte{1, 2, 3})
+			reader, err := OpenFile(writeTemp(t, b.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			if reader.SnapLen() != DefaultSnapLen {
+				t.Errorf("got snaplen %d for an interface without one", reader.SnapLen())
+			}
+			frames, infos := readAll(t, reader)
+			if len(frames) != 1 || !bytes.Equal(frames[0], []byte{1, 2, 3}) {
+				t.Fatalf("got frames %x", frames)
+			}
+			if !infos[0].Timestamp.Equal(test.want) {
+				t.Errorf("got timestamp %v, want %v", infos[0].Timestamp, test.want)
+			}
+		})
+	}
+}
+
+func TestPcapngReaderSections(t *testing.T) {
+	// The interfaces of a section are forgotten once the next one starts, whatever its byte order
+	little := &pcapngBuilder{byteOrder: binary.LittleEndian}
+	little.section().iface(units.LinkTypeEthernet, 65535).iface(units.LinkTypeRaw, 65535).packet(1, 1, []byte{1})
+	big := &pcapngBuilder{byteOrder: binary.BigEndian}
+	big.section().iface(units.LinkTypeLinuxSLL, 4).packet(0, 2, []byte{2}).simplePacket(6, []byte{3, 3, 3, 3, 3, 3})
+
+	reader, err := OpenFile(writeTemp(t, append(little.file, big.file...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	frames, infos := readAll(t, reader)
+	want := [][]byte{{1}, {2}, {3, 3, 3, 3}}
+	if !reflect.DeepEqual(frames, want) {
+		t.Fatalf("got frames %x, want %x", frames, want)
+	}
+	linkTypes := []units.LinkType{units.LinkTypeRaw, units.LinkTypeLinuxSLL, units.LinkTypeLinuxSLL}
+	for i, info := range infos {
+		if info.LinkType != linkTypes[i] {
+			t.Errorf("frame %d: got link type %d, want %d", i, info.LinkType, linkTypes[i])
+		}
+	}
+	// Simple packets are cut to the snaplen of the first interface and keep their original length
+	if infos[2].CaptureLength != 4 || infos[2].Length != 6 || !infos[2].Timestamp.IsZero() {
+		t.Errorf("got simple packet info %+v", infos[2])
+	}
+}
+
+func TestPcapngReaderRejectsMalformedFiles(t *testing.T) {
+	valid := func() *pcapngBuilder {
+		b := &pcapngBuilder{byteOrder: binary.LittleEndian}
+		return b.section().iface(units.LinkTypeEthernet, 65535)
+	}
+	mismatch := valid()
+	mismatch.file[len(mismatch.file)-1] = 0xff
+	oddLength := valid()
+	binary.LittleEndian.PutUint32(oddLength.file[len(oddLength.file)-16:], 21)
+	badMagic := valid()
+	badMagic.file[8] = 0
+
+	tests := []struct {
+		name string
+		file []byte
+		want string // in the error of OpenFile, or else of the first Capture
+	}{
+		{"block length mismatch", mismatch.file, "pcapng block length mismatch"},
+		{"invalid block length", oddLength.file, "invalid pcapng block length 21"},
+		{"bad byte order magic", badMagic.file, "invalid pcapng byte order magic"},
+		{"truncated block", valid().file[:40], "truncated pcapng block"},
+		{"undescribed interface", valid().packet(1, 0, []byte{1}).file, "undescribed interface 1"},
+		{"unsupported power of ten", valid().iface(units.LinkTypeEthernet, 0, pcapngInterfaceOptionTSResolution, []byte{20}).packet(0, 0, nil).file,
+			"unsupported pcapng timestamp resolution 10^-20"},
+		{"unsupported power of two", valid().iface(units.LinkTypeEthernet, 0, pcapngInterfaceOptionTSResolution, []byte{0x80 | 64}).packet(0, 0, nil).file,
+			"unsupported pcapng timestamp resolution 2^-64"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, err := OpenFile(writeTemp(t, test.file))
+			if err == nil {
+				defer reader.Close()
+				_, _, err = reader.Capture(context.Background())
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error about %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestPcapngReaderRejectsBlocksBeforeTheSection(t *testing.T) {
+	b := &pcapngBuilder{byteOrder: binary.LittleEndian}
+	reader := PcapngReader{}
+	err := reader.Init(writeTemp(t, b.iface(units.LinkTypeEthernet, 0).file))
+	if err == nil || !strings.Contains(err.Error(), "before the section header") {
+		t.Errorf("got %v", err)
+	}
+}
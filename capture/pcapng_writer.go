@@ -0,0 +1,168 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	units "packet_sniffer/model"
+	"runtime"
+)
+
+type pcapngInterfaceKey struct {
+	index int
+	name  string
+}
+
+// PcapngWriter writes frames to a pcapng capture file. An interface description is added the first time a frame from
+// a given interface shows up, so frames captured on several interfaces keep track of where they came from
+type PcapngWriter struct {
+	Comment string // written into the section header when set before Init
+
+	file       *os.File
+	snapLen    int
+	interfaces map[pcapngInterfaceKey]uint32
+	size       int64
+}
+
+func (w *PcapngWriter) Init(path string, snapLen int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.snapLen = snapLen
+	w.interfaces = make(map[pcapngInterfaceKey]uint32)
+
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body[0:4], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:6], 1)
+	binary.LittleEndian.PutUint16(body[6:8], 0)
+	// The section length is not known upfront
+	binary.LittleEndian.PutUint64(body[8:16], 0xffffffffffffffff)
+	if w.Comment != "" {
+		body = appendPcapngOption(body, pcapngOptionComment, []byte(w.Comment))
+	}
+	body = appendPcapngOption(body, pcapngSectionOptionOS, []byte(runtime.GOOS+"/"+runtime.GOARCH))
+	body = appendPcapngOption(body, pcapngSectionOptionApplication, []byte("packet_sniffer"))
+	body = appendPcapngOption(body, pcapngOptionEnd, nil)
+	if err := w.writeBlock(pcapngSectionHeaderBlock, body); err != nil {
+		file.Close()
+		return err
+	}
+	return nil
+}
+
+func appendPcapngOption(options []byte, code uint16, value []byte) []byte {
+	options = binary.LittleEndian.AppendUint16(options, code)
+	options = binary.LittleEndian.AppendUint16(options, uint16(len(value)))
+	options = append(options, value...)
+	return append(options, make([]byte, pcapngPadding(len(value))-len(value))...)
+}
+
+// writeBlock wraps the body into a block and writes it with a single call, so that an interrupted capture leaves
+// behind a file that is readable up to the last complete block
+func (w *PcapngWriter) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(12 + len(body))
+	block := make([]byte, 0, length)
+	block = binary.LittleEndian.AppendUint32(block, blockType)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	n, err := w.file.Write(block)
+	w.size += int64(n)
+	return err
+}
+
+// Size is the number of bytes written to the file so far
+func (w *PcapngWriter) Size() int64 {
+	return w.size
+}
+
+func (w *PcapngWriter) interfaceID(info units.CaptureInfo) (uint32, error) {
+	key := pcapngInterfaceKey{index: info.InterfaceIndex, name: info.InterfaceName}
+	if id, hit := w.interfaces[key]; hit {
+		return id, nil
+	}
+
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:2], uint16(info.LinkType))
+	binary.LittleEndian.PutUint32(body[4:8], uint32(w.snapLen))
+	if info.InterfaceName != "" {
+		body = appendPcapngOption(body, pcapngInterfaceOptionName, []byte(info.InterfaceName))
+	}
+	// Timestamps are written with nanosecond resolution
+	body = appendPcapngOption(body, pcapngInterfaceOptionTSResolution, []byte{9})
+	body = appendPcapngOption(body, pcapngOptionEnd, nil)
+	if err := w.writeBlock(pcapngInterfaceDescriptionBlock, body); err != nil {
+		return 0, err
+	}
+	id := uint32(len(w.interfaces))
+	w.interfaces[key] = id
+	return id, nil
+}
+
+func (w *PcapngWriter) WritePacket(info units.CaptureInfo, data []byte) error {
+	id, err := w.interfaceID(info)
+	if err != nil {
+		return err
+	}
+	if len(data) > w.snapLen {
+		data = data[:w.snapLen]
+	}
+	length := info.Length
+	if length < len(data) {
+		length = len(data)
+	}
+
+	timestamp := uint64(info.Timestamp.UnixNano())
+	body := make([]byte, 20, 20+pcapngPadding(len(data)))
+	binary.LittleEndian.PutUint32(body[0:4], id)
+	binary.LittleEndian.PutUint32(body[4:8], uint32(timestamp>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(timestamp))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(length))
+	body = append(body, data...)
+	body = append(body, make([]byte, pcapngPadding(len(data))-len(data))...)
+	withOptions := false
+	for _, comment := range info.Comments {
+		body = appendPcapngOption(body, pcapngOptionComment, []byte(comment))
+		withOptions = true
+	}
+	if flags := pcapngFlags(info.PacketType); flags != 0 {
+		body = appendPcapngOption(body, pcapngPacketOptionFlags, binary.LittleEndian.AppendUint32(nil, flags))
+		withOptions = true
+	}
+	if withOptions {
+		body = appendPcapngOption(body, pcapngOptionEnd, nil)
+	}
+	return w.writeBlock(pcapngEnhancedPacketBlock, body)
+}
+
+// WriteNameResolution records the host names of the given addresses
+func (w *PcapngWriter) WriteNameResolution(names map[string][]string) error {
+	var body []byte
+	for address, hostNames := range names {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return fmt.Errorf("invalid address %q", address)
+		}
+		recordType, value := pcapngNameRecordIPv6, []byte(ip.To16())
+		if ip4 := ip.To4(); ip4 != nil {
+			recordType, value = pcapngNameRecordIPv4, []byte(ip4)
+		}
+		for _, name := range hostNames {
+			value = append(append(value, name...), 0)
+		}
+		body = appendPcapngOption(body, recordType, value)
+	}
+	if body == nil {
+		return nil
+	}
+	body = appendPcapngOption(body, pcapngNameRecordEnd, nil)
+	return w.writeBlock(pcapngNameResolutionBlock, body)
+}
+
+func (w *PcapngWriter) Close() error {
+	return w.file.Close()
+}
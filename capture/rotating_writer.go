@@ -0,0 +1,117 @@
+package capture
+
+import (
+	"fmt"
+	"os"
+	units "packet_sniffer/model"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RotatingWriter writes frames to a series of capture files the way dumpcap's ring buffer does. A new file is started
+// once the current one reaches any of the limits, and only the newest files are kept around. The files are named after
+// Path with a sequence number and the time they were started, e.g. trace_00001_20240131235959.pcapng
+type RotatingWriter struct {
+	Path        string
+	LinkType    units.LinkType
+	SnapLen     int
+	MaxSize     int64         // in bytes, a file ends up larger by the last frame written to it
+	MaxDuration time.Duration // checked whenever a frame is written, there are no empty files for idle periods
+	MaxPackets  int
+	Keep        int // number of files to keep, every file is kept when 0
+
+	current  PacketWriter
+	sequence int
+	started  time.Time
+	packets  int
+	files    []string // files still around, the oldest first
+	written  int64    // bytes written to the files that have been closed
+}
+
+func (w *RotatingWriter) Init() error {
+	return w.rotate()
+}
+
+func (w *RotatingWriter) fileName() string {
+	ext := filepath.Ext(w.Path)
+	return fmt.Sprintf("%s_%05d_%s%s", strings.TrimSuffix(w.Path, ext), w.sequence, w.started.Format("20060102150405"), ext)
+}
+
+// rotate closes the current file, starts the next one and removes the ones that are no longer to be kept
+func (w *RotatingWriter) rotate() error {
+	if w.current != nil {
+		if sized, ok := w.current.(SizedWriter); ok {
+			w.written += sized.Size()
+		}
+		err := w.current.Close()
+		w.current = nil
+		if err != nil {
+			return err
+		}
+	}
+	w.sequence++
+	w.started = time.Now()
+	w.packets = 0
+	path := w.fileName()
+	writer, err := CreateFile(path, w.LinkType, w.SnapLen)
+	if err != nil {
+		return err
+	}
+	w.current = writer
+	w.files = append(w.files, path)
+	for w.Keep > 0 && len(w.files) > w.Keep {
+		if err := os.Remove(w.files[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		w.files = w.files[1:]
+	}
+	return nil
+}
+
+func (w *RotatingWriter) full() bool {
+	if w.MaxPackets > 0 && w.packets >= w.MaxPackets {
+		return true
+	}
+	if w.MaxDuration > 0 && time.Since(w.started) >= w.MaxDuration {
+		return true
+	}
+	if sized, ok := w.current.(SizedWriter); ok && w.MaxSize > 0 && sized.Size() >= w.MaxSize {
+		return true
+	}
+	return false
+}
+
+func (w *RotatingWriter) WritePacket(info units.CaptureInfo, data []byte) error {
+	// Every file gets at least one frame, however small the limits are
+	if w.packets > 0 && w.full() {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	w.packets++
+	return w.current.WritePacket(info, data)
+}
+
+// Size is the number of bytes written to all of the files, including the ones that have been removed since
+func (w *RotatingWriter) Size() int64 {
+	size := w.written
+	if sized, ok := w.current.(SizedWriter); ok {
+		size += sized.Size()
+	}
+	return size
+}
+
+// Files lists the files written so far that are still kept, the oldest first
+func (w *RotatingWriter) Files() []string {
+	return w.files
+}
+
+func (w *RotatingWriter) Close() error {
+	if w.current == nil {
+		return nil
+	}
+	err := w.current.Close()
+	w.current = nil
+	return err
+}
@@ -0,0 +1,85 @@
+package capture
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Stats counts what happened to the frames since a capturer was initialized
+type Stats struct {
+	Received         uint64 // frames that made it through the capture filter, the dropped ones included
+	Dropped          uint64 // frames the kernel dropped because they weren't read fast enough
+	Filtered         uint64 // frames the capture filter discarded in userspace, for sources the kernel doesn't filter
+	FreezeQueueCount uint64 // times the kernel found the mmap ring full
+}
+
+// StatsReporter is implemented by the capturers that keep track of their Stats
+type StatsReporter interface {
+	Stats() (Stats, error)
+}
+
+func (s Stats) Add(other Stats) Stats {
+	return Stats{
+		Received:         s.Received + other.Received,
+		Dropped:          s.Dropped + other.Dropped,
+		Filtered:         s.Filtered + other.Filtered,
+		FreezeQueueCount: s.FreezeQueueCount + other.FreezeQueueCount,
+	}
+}
+
+func (s Stats) String() string {
+	parts := []string{
+		fmt.Sprintf("%d received", s.Received),
+		fmt.Sprintf("%d dropped by the kernel", s.Dropped),
+		fmt.Sprintf("%d filtered", s.Filtered),
+	}
+	if s.FreezeQueueCount > 0 {
+		parts = append(parts, fmt.Sprintf("ring full %d times", s.FreezeQueueCount))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// socketStats adds up the PACKET_STATISTICS of a packet socket, which the kernel resets every time they're read
+type socketStats struct {
+	mu     sync.Mutex
+	total  Stats
+	closed bool
+}
+
+// update reads the statistics the kernel gathered since the last time, the socket has to use TPACKET_V3 for the
+// freeze queue count to be there
+func (s *socketStats) update(sock int, v3 bool) (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return s.total, nil
+	}
+	if v3 {
+		stats, err := unix.GetsockoptTpacketStatsV3(sock, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+		if err != nil {
+			return s.total, fmt.Errorf("failed to get the capture statistics: %v", err)
+		}
+		s.total.Received += uint64(stats.Packets)
+		s.total.Dropped += uint64(stats.Drops)
+		s.total.FreezeQueueCount += uint64(stats.Freeze_q_cnt)
+	} else {
+		stats, err := unix.GetsockoptTpacketStats(sock, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+		if err != nil {
+			return s.total, fmt.Errorf("failed to get the capture statistics: %v", err)
+		}
+		s.total.Received += uint64(stats.Packets)
+		s.total.Dropped += uint64(stats.Drops)
+	}
+	return s.total, nil
+}
+
+// close takes the last reading before the socket goes away, the totals stay available afterwards
+func (s *socketStats) close(sock int, v3 bool) {
+	s.update(sock, v3)
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+}
@@ -0,0 +1,468 @@
+package capture
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	units "packet_sniffer/model"
+	"sync"
+	"time"
+)
+
+// SyntheticInterface is the name of the interface synthetic frames pretend to be captured on
+const SyntheticInterface = "synthetic"
+
+// DefaultSyntheticRate is the number of frames generated per second
+const DefaultSyntheticRate = 50
+
+// SyntheticKinds lists the kinds of traffic the SyntheticCapturer can generate
+var SyntheticKinds = []string{"arp", "icmp", "tcp", "udp", "dns"}
+
+// DefaultSyntheticMix is roughly what the traffic of a workstation looks like
+var DefaultSyntheticMix = map[string]int{"arp": 1, "icmp": 2, "tcp": 10, "udp": 3, "dns": 4}
+
+// SyntheticCapturer generates the Ethernet frames of made-up but plausible conversations between a host and its
+// neighbours and the servers it talks to, without any privilege or network interface. The same seed yields the same
+// frames in the same order
+type SyntheticCapturer struct {
+	Seed int64
+	Rate float64        // frames per second, as fast as they are read when not positive
+	Mix  map[string]int // weight of every kind of traffic among SyntheticKinds, DefaultSyntheticMix when empty
+
+	random        *rand.Rand
+	kinds         []string
+	weights       []int // cumulative weights of kinds
+	conversations map[string][]*conversation
+	start         time.Time
+	generated     int
+
+	mu        sync.Mutex // held by Capture
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// conversation holds the frames of an exchange that haven't been handed over yet
+type conversation struct {
+	frames      [][]byte
+	packetTypes []units.PacketType
+}
+
+func (c *conversation) add(packetType units.PacketType, frame []byte) {
+	c.frames = append(c.frames, frame)
+	c.packetTypes = append(c.packetTypes, packetType)
+}
+
+// host is a station of the made-up network, frames to hosts off the LAN go through the gateway
+type host struct {
+	mac net.HardwareAddr
+	ip  net.IP
+	ttl byte // TTL of the packets it sends as seen on the LAN
+}
+
+func newHost(ip string, ttl byte) host {
+	address := net.ParseIP(ip).To4()
+	// Locally administered MAC addresses derived from the IP address
+	return host{mac: net.HardwareAddr{0x02, 0x00, address[0], address[1], address[2], address[3]}, ip: address, ttl: ttl}
+}
+
+var (
+	syntheticLocal     = newHost("192.0.2.10", 64)
+	syntheticGateway   = newHost("192.0.2.1", 64)
+	syntheticNeighbors = []host{
+		newHost("192.0.2.20", 64), newHost("192.0.2.21", 128), newHost("192.0.2.35", 64), newHost("192.0.2.54", 255),
+	}
+	syntheticServers = []host{
+		newHost("198.51.100.7", 54), newHost("198.51.100.23", 57), newHost("198.51.100.80", 118),
+		newHost("203.0.113.5", 49), newHost("203.0.113.44", 243),
+	}
+	syntheticNames = []string{
+		"example.com", "www.example.com", "api.example.org", "cdn.example.net", "mail.example.org", "updates.example.net",
+	}
+)
+
+func (sc *SyntheticCapturer) Init() error {
+	mix := sc.Mix
+	if len(mix) == 0 {
+		mix = DefaultSyntheticMix
+	}
+	total := 0
+	for _, kind := range SyntheticKinds {
+		weight := mix[kind]
+		if weight < 0 {
+			return fmt.Errorf("negative weight for %s traffic", kind)
+		}
+		if weight == 0 {
+			continue
+		}
+		total += weight
+		sc.kinds = append(sc.kinds, kind)
+		sc.weights = append(sc.weights, total)
+	}
+	for kind := range mix {
+		if !isSyntheticKind(kind) {
+			return fmt.Errorf("unknown kind of synthetic traffic %q", kind)
+		}
+	}
+	if total == 0 {
+		return fmt.Errorf("no kind of synthetic traffic has a weight")
+	}
+	sc.random = rand.New(rand.NewSource(sc.Seed))
+	sc.conversations = make(map[string][]*conversation)
+	sc.done = make(chan struct{})
+	return nil
+}
+
+func isSyntheticKind(kind string) bool {
+	for _, known := range SyntheticKinds {
+		if kind == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (sc *SyntheticCapturer) Capture(ctx context.Context) ([]byte, units.CaptureInfo, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	select {
+	case <-sc.done:
+		return nil, units.CaptureInfo{}, ErrClosed
+	case <-ctx.Done():
+		// Frames are always at hand, so this is the only chance to notice when they come at full speed
+		return nil, units.CaptureInfo{}, ctx.Err()
+	default:
+	}
+
+	timestamp := time.Now()
+	if sc.Rate > 0 {
+		if sc.generated == 0 {
+			sc.start = timestamp
+		}
+		// Frames are due at a steady pace from the first one on, whenever they are read
+		timestamp = sc.start.Add(time.Duration(float64(sc.generated) / sc.Rate * float64(time.Second)))
+		if wait := time.Until(timestamp); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return nil, units.CaptureInfo{}, ctx.Err()
+			case <-sc.done:
+				return nil, units.CaptureInfo{}, ErrClosed
+			case <-timer.C:
+			}
+		}
+	}
+
+	frame, packetType := sc.next()
+	sc.generated++
+	info := units.CaptureInfo{
+		Timestamp:      timestamp,
+		CaptureLength:  len(frame),
+		Length:         len(frame),
+		LinkType:       units.LinkTypeEthernet,
+		PacketType:     packetType,
+		InterfaceIndex: 1,
+		InterfaceName:  SyntheticInterface,
+	}
+	return frame, info, nil
+}
+
+// next picks a kind of traffic by its weight and carries on with one of its conversations, or starts a new one
+func (sc *SyntheticCapturer) next() ([]byte, units.PacketType) {
+	pick := sc.random.Intn(sc.weights[len(sc.weights)-1])
+	kind := sc.kinds[0]
+	for i, weight := range sc.weights {
+		if pick < weight {
+			kind = sc.kinds[i]
+			break
+		}
+	}
+
+	active := sc.conversations[kind]
+	var index int
+	if len(active) > 0 && sc.random.Intn(4) > 0 {
+		index = sc.random.Intn(len(active))
+	} else {
+		active = append(active, sc.newConversation(kind))
+		index = len(active) - 1
+	}
+	c := active[index]
+	frame, packetType := c.frames[0], c.packetTypes[0]
+	c.frames, c.packetTypes = c.frames[1:], c.packetTypes[1:]
+	if len(c.frames) == 0 {
+		active = append(active[:index], active[index+1:]...)
+	}
+	sc.conversations[kind] = active
+	return frame, packetType
+}
+
+func (sc *SyntheticCapturer) newConversation(kind string) *conversation {
+	switch kind {
+	case "arp":
+		return sc.arpConversation()
+	case "icmp":
+		return sc.icmpConversation()
+	case "tcp":
+		return sc.tcpConversation()
+	case "udp":
+		return sc.udpConversation()
+	}
+	return sc.dnsConversation()
+}
+
+func (sc *SyntheticCapturer) pickHost(hosts []host) host {
+	return hosts[sc.random.Intn(len(hosts))]
+}
+
+func (sc *SyntheticCapturer) ephemeralPort() uint16 {
+	return uint16(32768 + sc.random.Intn(28232))
+}
+
+func (sc *SyntheticCapturer) randomBytes(n int) []byte {
+	b := make([]byte, n)
+	sc.random.Read(b)
+	return b
+}
+
+// ipFrame wraps a transport payload sent from one host to another, through the gateway if either is off the LAN
+func (sc *SyntheticCapturer) ipFrame(from, to host, protocol byte, payload []byte) []byte {
+	source, destination := from.mac, to.mac
+	if isServer(from) {
+		source = syntheticGateway.mac
+	}
+	if isServer(to) {
+		destination = syntheticGateway.mac
+	}
+	packet := ipv4Packet(from.ip, to.ip, protocol, uint16(sc.random.Intn(1<<16)), from.ttl, payload)
+	return ethernetFrame(destination, source, etherTypeIPv4, packet)
+}
+
+func isServer(h host) bool {
+	for _, server := range syntheticServers {
+		if server.ip.Equal(h.ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// direction tells the packet type of a frame sent by a host as seen by the local one
+func direction(from host) units.PacketType {
+	if from.ip.Equal(syntheticLocal.ip) {
+		return units.PacketTypeOutgoing
+	}
+	return units.PacketTypeHost
+}
+
+func (sc *SyntheticCapturer) arpConversation() *conversation {
+	c := &conversation{}
+	neighbor := sc.pickHost(append([]host{syntheticGateway}, syntheticNeighbors...))
+	asking, answering := syntheticLocal, neighbor
+	if sc.random.Intn(2) == 0 {
+		asking, answering = neighbor, syntheticLocal
+	}
+	request := arpPacket(1, asking.mac, asking.ip, make(net.HardwareAddr, 6), answering.ip)
+	requestType := units.PacketTypeOutgoing
+	if asking.ip.Equal(neighbor.ip) {
+		requestType = units.PacketTypeBroadcast
+	}
+	c.add(requestType, ethernetFrame(broadcastMAC, asking.mac, etherTypeARP, request))
+	reply := arpPacket(2, answering.mac, answering.ip, asking.mac, asking.ip)
+	c.add(direction(answering), ethernetFrame(asking.mac, answering.mac, etherTypeARP, reply))
+	return c
+}
+
+func (sc *SyntheticCapturer) icmpConversation() *conversation {
+	c := &conversation{}
+	target := sc.pickHost(append(append([]host{syntheticGateway}, syntheticNeighbors...), syntheticServers...))
+	id := uint16(sc.random.Intn(1 << 16))
+	// A ping sends a few requests in a row, each with the time it was sent and a fixed pattern
+	count := uint16(1 + sc.random.Intn(4))
+	for seq := uint16(1); seq <= count; seq++ {
+		payload := binary.LittleEndian.AppendUint64(nil, uint64(sc.random.Int63()))
+		for i := 0; i < 48; i++ {
+			payload = append(payload, byte(0x10+i))
+		}
+		c.add(units.PacketTypeOutgoing, sc.ipFrame(syntheticLocal, target, ipProtocolICMP, icmpEcho(8, id, seq, payload)))
+		c.add(units.PacketTypeHost, sc.ipFrame(target, syntheticLocal, ipProtocolICMP, icmpEcho(0, id, seq, payload)))
+	}
+	return c
+}
+
+func (sc *SyntheticCapturer) dnsConversation() *conversation {
+	c := &conversation{}
+	name := syntheticNames[sc.random.Intn(len(syntheticNames))]
+	recordType := uint16(dnsTypeA)
+	if sc.random.Intn(3) == 0 {
+		recordType = dnsTypeAAAA
+	}
+	id, port := uint16(sc.random.Intn(1<<16)), sc.ephemeralPort()
+	query := dnsMessage(id, false, name, recordType, nil)
+	c.add(units.PacketTypeOutgoing, sc.ipFrame(syntheticLocal, syntheticGateway, ipProtocolUDP,
+		udpDatagram(syntheticLocal.ip, syntheticGateway.ip, port, 53, query)))
+
+	var answers []dnsAnswer
+	ttl := uint32(60 + sc.random.Intn(3540))
+	for i := 0; i < 1+sc.random.Intn(3); i++ {
+		server := sc.pickHost(syntheticServers)
+		if recordType == dnsTypeA {
+			answers = append(answers, dnsAnswer{recordType: dnsTypeA, ttl: ttl, data: server.ip})
+		} else {
+			// 2001:db8::/32 is reserved for documentation, just like the IPv4 addresses used here
+			address := net.ParseIP("2001:db8::").To16()
+			copy(address[12:], server.ip)
+			answers = append(answers, dnsAnswer{recordType: dnsTypeAAAA, ttl: ttl, data: address})
+		}
+	}
+	response := dnsMessage(id, true, name, recordType, answers)
+	c.add(units.PacketTypeHost, sc.ipFrame(syntheticGateway, syntheticLocal, ipProtocolUDP,
+		udpDatagram(syntheticGateway.ip, syntheticLocal.ip, 53, port, response)))
+	return c
+}
+
+func (sc *SyntheticCapturer) udpConversation() *conversation {
+	c := &conversation{}
+	port := sc.ephemeralPort()
+	switch sc.random.Intn(3) {
+	case 0:
+		// NTP client and server messages are 48 bytes long
+		server := sc.pickHost(syntheticServers)
+		request := append([]byte{0x23}, make([]byte, 47)...)
+		response := append([]byte{0x24, 2}, sc.randomBytes(46)...)
+		c.add(units.PacketTypeOutgoing, sc.ipFrame(syntheticLocal, server, ipProtocolUDP,
+			udpDatagram(syntheticLocal.ip, server.ip, port, 123, request)))
+		c.add(units.PacketTypeHost, sc.ipFrame(server, syntheticLocal, ipProtocolUDP,
+			udpDatagram(server.ip, syntheticLocal.ip, 123, port, response)))
+	case 1:
+		neighbor := sc.pickHost(syntheticNeighbors)
+		message := fmt.Sprintf("<%d>%s synthetic[%d]: message %d", 8+sc.random.Intn(8),
+			time.Unix(1700000000+sc.random.Int63n(1e7), 0).UTC().Format(time.Stamp), 1000+sc.random.Intn(9000),
+			sc.random.Intn(1e6))
+		c.add(units.PacketTypeOutgoing, sc.ipFrame(syntheticLocal, neighbor, ipProtocolUDP,
+			udpDatagram(syntheticLocal.ip, neighbor.ip, port, 514, []byte(message))))
+	default:
+		// A burst of a real time media stream going both ways
+		server := sc.pickHost(syntheticServers)
+		serverPort := uint16(10000 + sc.random.Intn(10000))
+		for i := 0; i < 4+sc.random.Intn(8); i++ {
+			from, to, fromPort, toPort := syntheticLocal, server, port, serverPort
+			if sc.random.Intn(2) == 0 {
+				from, to, fromPort, toPort = server, syntheticLocal, serverPort, port
+			}
+			payload := sc.randomBytes(160 + sc.random.Intn(40))
+			c.add(direction(from), sc.ipFrame(from, to, ipProtocolUDP, udpDatagram(from.ip, to.ip, fromPort, toPort, payload)))
+		}
+	}
+	return c
+}
+
+// tcpConversation goes through a whole connection: handshake, a request and its response, and the teardown
+func (sc *SyntheticCapturer) tcpConversation() *conversation {
+	c := &conversation{}
+	server := sc.pickHost(append([]host{syntheticNeighbors[0]}, syntheticServers...))
+	serverPorts := []uint16{80, 443, 443, 443, 22, 8080}
+	clientPort, serverPort := sc.ephemeralPort(), serverPorts[sc.random.Intn(len(serverPorts))]
+	clientSeq, serverSeq := sc.random.Uint32(), sc.random.Uint32()
+	clientTS, serverTS := sc.random.Uint32(), sc.random.Uint32()
+
+	send := func(fromClient bool, flags byte, options []byte, payload []byte) {
+		from, to := syntheticLocal, server
+		h := tcpHeader{sourcePort: clientPort, destinationPort: serverPort, seq: clientSeq, ack: serverSeq, flags: flags,
+			window: 502, options: options}
+		if !fromClient {
+			from, to = server, syntheticLocal
+			h.sourcePort, h.destinationPort, h.seq, h.ack, h.window = serverPort, clientPort, serverSeq, clientSeq, 509
+		}
+		if flags&tcpFlagACK == 0 {
+			h.ack = 0
+		}
+		if flags&tcpFlagSYN != 0 {
+			h.window = 64240
+		}
+		c.add(direction(from), sc.ipFrame(from, to, ipProtocolTCP, tcpSegment(from.ip, to.ip, h, payload)))
+		advance := uint32(len(payload))
+		if flags&(tcpFlagSYN|tcpFlagFIN) != 0 {
+			advance++
+		}
+		if fromClient {
+			clientSeq += advance
+		} else {
+			serverSeq += advance
+		}
+	}
+
+	send(true, tcpFlagSYN, synOptions(1460, clientTS, 0, 7), nil)
+	send(false, tcpFlagSYN|tcpFlagACK, synOptions(1460, serverTS, clientTS, 7), nil)
+	send(true, tcpFlagACK, nil, nil)
+
+	request, response := sc.applicationData(serverPort)
+	send(true, tcpFlagPSH|tcpFlagACK, nil, request)
+	send(false, tcpFlagACK, nil, nil)
+	for offset, segments := 0, 0; offset < len(response); segments++ {
+		end := min(offset+1460, len(response))
+		flags := byte(tcpFlagACK)
+		if end == len(response) {
+			flags |= tcpFlagPSH
+		}
+		send(false, flags, nil, response[offset:end])
+		offset = end
+		// Delayed ACKs acknowledge every other segment
+		if segments%2 == 1 || offset == len(response) {
+			send(true, tcpFlagACK, nil, nil)
+		}
+	}
+
+	send(true, tcpFlagFIN|tcpFlagACK, nil, nil)
+	send(false, tcpFlagFIN|tcpFlagACK, nil, nil)
+	send(true, tcpFlagACK, nil, nil)
+	return c
+}
+
+// applicationData makes up a request and its response for the protocol usually found on a port
+func (sc *SyntheticCapturer) applicationData(port uint16) ([]byte, []byte) {
+	switch port {
+	case 80, 8080:
+		name := syntheticNames[sc.random.Intn(len(syntheticNames))]
+		paths := []string{"/", "/index.html", "/api/v1/status", "/static/app.js", "/images/logo.png"}
+		request := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: synthetic/1.0\r\nAccept: */*\r\n\r\n",
+			paths[sc.random.Intn(len(paths))], name)
+		body := sc.randomBytes(200 + sc.random.Intn(6000))
+		response := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\n\r\n",
+			len(body))
+		return []byte(request), append([]byte(response), body...)
+	case 443:
+		return sc.tlsRecords(1+sc.random.Intn(2), 100, 600), sc.tlsRecords(1+sc.random.Intn(4), 500, 4000)
+	}
+	// SSH, or anything else encrypted
+	return sc.randomBytes(36 + sc.random.Intn(200)), sc.randomBytes(36 + sc.random.Intn(1500))
+}
+
+// tlsRecords makes up TLS application data records of random contents
+func (sc *SyntheticCapturer) tlsRecords(count int, minLength int, maxLength int) []byte {
+	var records []byte
+	for i := 0; i < count; i++ {
+		length := minLength + sc.random.Intn(maxLength-minLength)
+		records = append(records, 0x17, 0x03, 0x03)
+		records = binary.BigEndian.AppendUint16(records, uint16(length))
+		records = append(records, sc.randomBytes(length)...)
+	}
+	return records
+}
+
+func (sc *SyntheticCapturer) LinkType() units.LinkType {
+	return units.LinkTypeEthernet
+}
+
+func (sc *SyntheticCapturer) SnapLen() int {
+	return DefaultSnapLen
+}
+
+// Close makes a Capture waiting for the next frame in another goroutine return ErrClosed
+func (sc *SyntheticCapturer) Close() error {
+	sc.closeOnce.Do(func() {
+		close(sc.done)
+	})
+	return nil
+}
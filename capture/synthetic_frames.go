@@ -0,0 +1,182 @@
+package capture
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeARP  = 0x0806
+
+	ipProtocolICMP = 1
+	ipProtocolTCP  = 6
+	ipProtocolUDP  = 17
+
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+)
+
+var broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// internetChecksum is the one's complement sum used by IPv4, ICMP, TCP and UDP
+func internetChecksum(parts ...[]byte) uint16 {
+	var sum uint32
+	odd := false
+	for _, part := range parts {
+		for _, b := range part {
+			if odd {
+				sum += uint32(b)
+			} else {
+				sum += uint32(b) << 8
+			}
+			odd = !odd
+		}
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+func ethernetFrame(destination, source net.HardwareAddr, etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, destination...)
+	frame = append(frame, source...)
+	frame = binary.BigEndian.AppendUint16(frame, etherType)
+	return append(frame, payload...)
+}
+
+func arpPacket(operation uint16, senderMAC net.HardwareAddr, senderIP net.IP, targetMAC net.HardwareAddr, targetIP net.IP) []byte {
+	packet := []byte{0, 1, 0x08, 0x00, 6, 4}
+	packet = binary.BigEndian.AppendUint16(packet, operation)
+	packet = append(packet, senderMAC...)
+	packet = append(packet, senderIP.To4()...)
+	packet = append(packet, targetMAC...)
+	return append(packet, targetIP.To4()...)
+}
+
+func ipv4Packet(source, destination net.IP, protocol byte, id uint16, ttl byte, payload []byte) []byte {
+	header := make([]byte, 20, 20+len(payload))
+	header[0] = 0x45
+	binary.BigEndian.PutUint16(header[2:4], uint16(20+len(payload)))
+	binary.BigEndian.PutUint16(header[4:6], id)
+	if protocol == ipProtocolTCP {
+		// Don't fragment, the way TCP stacks send their segments
+		header[6] = 0x40
+	}
+	header[8] = ttl
+	header[9] = protocol
+	copy(header[12:16], source.To4())
+	copy(header[16:20], destination.To4())
+	binary.BigEndian.PutUint16(header[10:12], internetChecksum(header))
+	return append(header, payload...)
+}
+
+func pseudoHeader(source, destination net.IP, protocol byte, length int) []byte {
+	header := make([]byte, 0, 12)
+	header = append(header, source.To4()...)
+	header = append(header, destination.To4()...)
+	header = append(header, 0, protocol)
+	return binary.BigEndian.AppendUint16(header, uint16(length))
+}
+
+func udpDatagram(source, destination net.IP, sourcePort, destinationPort uint16, payload []byte) []byte {
+	datagram := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(datagram[0:2], sourcePort)
+	binary.BigEndian.PutUint16(datagram[2:4], destinationPort)
+	binary.BigEndian.PutUint16(datagram[4:6], uint16(8+len(payload)))
+	datagram = append(datagram, payload...)
+	checksum := internetChecksum(pseudoHeader(source, destination, ipProtocolUDP, len(datagram)), datagram)
+	if checksum == 0 {
+		checksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(datagram[6:8], checksum)
+	return datagram
+}
+
+type tcpHeader struct {
+	sourcePort, destinationPort uint16
+	seq, ack                    uint32
+	flags                       byte
+	window                      uint16
+	options                     []byte // padded to a multiple of 4 bytes
+}
+
+func tcpSegment(source, destination net.IP, h tcpHeader, payload []byte) []byte {
+	headerLength := 20 + len(h.options)
+	segment := make([]byte, 20, headerLength+len(payload))
+	binary.BigEndian.PutUint16(segment[0:2], h.sourcePort)
+	binary.BigEndian.PutUint16(segment[2:4], h.destinationPort)
+	binary.BigEndian.PutUint32(segment[4:8], h.seq)
+	binary.BigEndian.PutUint32(segment[8:12], h.ack)
+	segment[12] = byte(headerLength/4) << 4
+	segment[13] = h.flags
+	binary.BigEndian.PutUint16(segment[14:16], h.window)
+	segment = append(segment, h.options...)
+	segment = append(segment, payload...)
+	checksum := internetChecksum(pseudoHeader(source, destination, ipProtocolTCP, len(segment)), segment)
+	binary.BigEndian.PutUint16(segment[16:18], checksum)
+	return segment
+}
+
+// synOptions are the options Linux puts in SYN segments: MSS, SACK permitted, timestamps and window scale
+func synOptions(mss uint16, tsValue, tsEcho uint32, windowScale byte) []byte {
+	options := []byte{2, 4}
+	options = binary.BigEndian.AppendUint16(options, mss)
+	options = append(options, 4, 2, 8, 10)
+	options = binary.BigEndian.AppendUint32(options, tsValue)
+	options = binary.BigEndian.AppendUint32(options, tsEcho)
+	return append(options, 1, 3, 3, windowScale)
+}
+
+func icmpEcho(messageType byte, id, seq uint16, payload []byte) []byte {
+	message := []byte{messageType, 0, 0, 0}
+	message = binary.BigEndian.AppendUint16(message, id)
+	message = binary.BigEndian.AppendUint16(message, seq)
+	message = append(message, payload...)
+	binary.BigEndian.PutUint16(message[2:4], internetChecksum(message))
+	return message
+}
+
+type dnsAnswer struct {
+	recordType uint16
+	ttl        uint32
+	data       []byte
+}
+
+// dnsMessage builds a query for a single name, or the response to it when answers are given
+func dnsMessage(id uint16, response bool, name string, recordType uint16, answers []dnsAnswer) []byte {
+	message := binary.BigEndian.AppendUint16(nil, id)
+	flags := uint16(0x0100) // recursion desired
+	if response {
+		flags = 0x8180 // and available
+	}
+	message = binary.BigEndian.AppendUint16(message, flags)
+	message = binary.BigEndian.AppendUint16(message, 1)
+	message = binary.BigEndian.AppendUint16(message, uint16(len(answers)))
+	message = append(message, 0, 0, 0, 0)
+	for _, label := range strings.Split(name, ".") {
+		message = append(message, byte(len(label)))
+		message = append(message, label...)
+	}
+	message = append(message, 0)
+	message = binary.BigEndian.AppendUint16(message, recordType)
+	message = binary.BigEndian.AppendUint16(message, 1)
+	for _, answer := range answers {
+		// The name points back to the one of the question
+		message = append(message, 0xc0, 12)
+		message = binary.BigEndian.AppendUint16(message, answer.recordType)
+		message = binary.BigEndian.AppendUint16(message, 1)
+		message = binary.BigEndian.AppendUint32(message, answer.ttl)
+		message = binary.BigEndian.AppendUint16(message, uint16(len(answer.data)))
+		message = append(message, answer.data...)
+	}
+	return message
+}
@@ -1,11 +1,20 @@
 package capture
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
-	"log"
+	"net"
+	"packet_sniffer/filter"
+	units "packet_sniffer/model"
+	"packet_sniffer/utils"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
+
+	"golang.org/x/sys/unix"
 )
 
 type ifReq struct {
@@ -20,55 +29,411 @@ func NTOHS(i uint16) uint16 {
 	return binary.LittleEndian.Uint16(data)
 }
 
+// DefaultSnapLen is the largest frame the live capturers hand over
+const DefaultSnapLen = 65536
+
+// AnyInterface is the name of the pseudo-interface that captures on every network interface at once
+const AnyInterface = "any"
+
+// FanoutMode selects how a PACKET_FANOUT group spreads the frames of an interface over its sockets
+type FanoutMode int
+
+const (
+	FanoutNone        FanoutMode = iota
+	FanoutHash                   // by flow, so every frame of a flow ends up on the same socket
+	FanoutLoadBalance            // round robin
+	FanoutCPU                    // by the CPU the frame was received on
+)
+
+var FanoutModes = map[string]FanoutMode{
+	"hash": FanoutHash,
+	"lb":   FanoutLoadBalance,
+	"cpu":  FanoutCPU,
+}
+
 type UnixCapturer struct {
-	sock int // socket file descriptor
+	Filter       *filter.Expression // expression compiled for the link type of the interface and applied by the kernel on Init
+	Promiscuous  bool               // receive frames that are not addressed to the interface
+	AllMulticast bool               // receive frames sent to every multicast group, not only the joined ones
+	FanoutMode   FanoutMode         // the socket shares the frames with the others of FanoutGroup unless FanoutNone
+	FanoutGroup  uint16             // a new group when 0, its ID is set on Init for the other sockets to join
+	Cooked       bool               // capture with a Linux cooked v2 header in place of the link-layer header, whatever the interface
+	Namespace    string             // path of the network namespace the interface is in, the one of the process when empty
+
+	sock        int // socket file descriptor
+	iface       string
+	ifindex     int
+	linkType    units.LinkType
+	memberships []uint16
+	stats       socketStats
+
+	mu     sync.Mutex // held by Capture, so that Close doesn't pull the socket from under it
+	waker  waker
+	closed atomic.Bool
 }
 
-func (us *UnixCapturer) createSock() error {
-	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(NTOHS(syscall.ETH_P_ALL)))
-	if err != nil {
-		return err
+// createPacketSocket returns a socket that doesn't receive anything until it's bound to an interface, which gives a
+// chance to attach a filter before the first frame arrives. Cooked sockets receive frames without their link-layer
+// header
+func createPacketSocket(cooked bool) (int, error) {
+	if cooked {
+		return syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, 0)
+	}
+	return syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+}
+
+func attachFilter(sock int, program filter.Program) error {
+	if len(program) == 0 {
+		return nil
+	}
+	prog := unix.SockFprog{
+		Len:    uint16(len(program)),
+		Filter: (*unix.SockFilter)(unsafe.Pointer(&program[0])),
+	}
+	if err := unix.SetsockoptSockFprog(sock, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		return fmt.Errorf("failed to attach the capture filter: %v", err)
 	}
-	us.sock = fd
 	return nil
 }
 
-func (us *UnixCapturer) bindToInterface(iface string) error {
+// interfaceIndex looks up the index of an interface by its name
+func interfaceIndex(sock int, iface string) (int, error) {
 	ifreq := ifReq{}
 	copy(ifreq.name[:], iface)
 
 	// The underlying syscall populates ifreq with ifindex that is needed to bind socket to an interface
-	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(us.sock), syscall.SIOCGIFINDEX, uintptr(unsafe.Pointer(&ifreq)))
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(sock), syscall.SIOCGIFINDEX, uintptr(unsafe.Pointer(&ifreq)))
 	if errno != 0 {
-		return fmt.Errorf("failed to get interface index: %v", errno)
+		return 0, fmt.Errorf("failed to get interface index of %s: %v", iface, errno)
+	}
+	return int(ifreq.index), nil
+}
+
+// bindToInterface binds a packet socket to an interface and returns the interface's index. AnyInterface binds to
+// index 0, which makes the kernel hand over the frames of every interface
+func bindToInterface(sock int, iface string) (int, error) {
+	ifindex := 0
+	if iface != AnyInterface {
+		var err error
+		if ifindex, err = interfaceIndex(sock, iface); err != nil {
+			return 0, err
+		}
 	}
 
 	// Bind socket to an interface
 	sll := syscall.SockaddrLinklayer{
 		Protocol: NTOHS(syscall.ETH_P_ALL),
-		Ifindex:  int(ifreq.index),
+		Ifindex:  ifindex,
 	}
-	err := syscall.Bind(us.sock, &sll)
-	return err
+	if err := syscall.Bind(sock, &sll); err != nil {
+		return 0, err
+	}
+	return ifindex, nil
+}
+
+// interfaceNames caches the names of the interfaces by namespace and index
+var interfaceNames sync.Map
+
+type namespacedIndex struct {
+	namespace string
+	ifindex   int
+}
+
+// interfaceName looks up the name of the interface a frame came from when capturing on AnyInterface
+func interfaceName(namespace string, ifindex int) string {
+	key := namespacedIndex{namespace, ifindex}
+	if name, ok := interfaceNames.Load(key); ok {
+		return name.(string)
+	}
+	var iface *net.Interface
+	err := utils.InNetworkNamespace(namespace, func() error {
+		var err error
+		iface, err = net.InterfaceByIndex(ifindex)
+		return err
+	})
+	if err != nil {
+		return ""
+	}
+	interfaceNames.Store(key, iface.Name)
+	return iface.Name
+}
+
+// joinFanout adds a bound socket to a fanout group, every socket of the group has to be bound to the same interface.
+// Group IDs are shared by the whole system, for group 0 the kernel creates a group under an ID no other one has. The ID
+// of the group joined is returned
+func joinFanout(sock int, group uint16, mode FanoutMode) (uint16, error) {
+	var arg int
+	switch mode {
+	case FanoutNone:
+		return group, nil
+	case FanoutHash:
+		// Fragments carry no ports, they are reassembled for the whole datagram to be hashed to the same socket
+		arg = unix.PACKET_FANOUT_HASH | unix.PACKET_FANOUT_FLAG_DEFRAG
+	case FanoutLoadBalance:
+		arg = unix.PACKET_FANOUT_LB
+	case FanoutCPU:
+		arg = unix.PACKET_FANOUT_CPU
+	default:
+		return 0, fmt.Errorf("unknown fanout mode %d", mode)
+	}
+	if group == 0 {
+		arg |= unix.PACKET_FANOUT_FLAG_UNIQUEID
+	}
+	if err := unix.SetsockoptInt(sock, unix.SOL_PACKET, unix.PACKET_FANOUT, int(group)|arg<<16); err != nil {
+		return 0, fmt.Errorf("failed to join fanout group %d: %v", group, err)
+	}
+	if group == 0 {
+		// The ID comes back along with the mode and the flags
+		value, err := unix.GetsockoptInt(sock, unix.SOL_PACKET, unix.PACKET_FANOUT)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get the fanout group ID: %v", err)
+		}
+		group = uint16(value)
+	}
+	return group, nil
+}
+
+// addMemberships puts the interface into promiscuous and/or all multicast mode. The kernel counts the memberships per
+// socket and drops them when the socket is closed, so the interface goes back to its previous state even if the
+// process is killed. The membership types that were added are returned to be dropped on Close
+func addMemberships(sock, ifindex int, promiscuous, allMulticast bool) ([]uint16, error) {
+	if ifindex == 0 && (promiscuous || allMulticast) {
+		return nil, fmt.Errorf("promiscuous and all multicast modes can't be enabled on %q, list the interfaces instead", AnyInterface)
+	}
+	var types []uint16
+	if promiscuous {
+		types = append(types, unix.PACKET_MR_PROMISC)
+	}
+	if allMulticast {
+		types = append(types, unix.PACKET_MR_ALLMULTI)
+	}
+	for i, mrType := range types {
+		mreq := unix.PacketMreq{Ifindex: int32(ifindex), Type: mrType}
+		if err := unix.SetsockoptPacketMreq(sock, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+			dropMemberships(sock, ifindex, types[:i])
+			return nil, fmt.Errorf("failed to enable %s mode: %v", membershipNames[mrType], err)
+		}
+	}
+	return types, nil
+}
+
+var membershipNames = map[uint16]string{
+	unix.PACKET_MR_PROMISC:  "promiscuous",
+	unix.PACKET_MR_ALLMULTI: "all multicast",
+}
+
+func dropMemberships(sock, ifindex int, types []uint16) error {
+	var firstErr error
+	for _, mrType := range types {
+		mreq := unix.PacketMreq{Ifindex: int32(ifindex), Type: mrType}
+		if err := unix.SetsockoptPacketMreq(sock, unix.SOL_PACKET, unix.PACKET_DROP_MEMBERSHIP, &mreq); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to disable %s mode: %v", membershipNames[mrType], err)
+		}
+	}
+	return firstErr
+}
+
+// packetType converts the pkttype of sockaddr_ll
+func packetType(pkttype uint8) units.PacketType {
+	if pkttype > unix.PACKET_OUTGOING {
+		return units.PacketTypeUnknown
+	}
+	return units.PacketType(pkttype + 1)
 }
 
-func (us *UnixCapturer) Capture() ([]byte, error) {
-	buf := make([]byte, 65536)
-	n, _, err := syscall.Recvfrom(us.sock, buf, 0)
+// receiveTimestamp looks for the SO_TIMESTAMPNS control message among the ones received along with a frame
+func receiveTimestamp(oob []byte) (time.Time, bool) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
 	if err != nil {
-		log.Fatalf("Failed to receive units: %v", err)
-		return nil, err
+		return time.Time{}, false
+	}
+	for _, message := range messages {
+		if message.Header.Level == syscall.SOL_SOCKET && message.Header.Type == syscall.SO_TIMESTAMPNS &&
+			len(message.Data) >= int(unsafe.Sizeof(syscall.Timespec{})) {
+			ts := (*syscall.Timespec)(unsafe.Pointer(&message.Data[0]))
+			return time.Unix(ts.Unix()), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// receiveVLANTag looks for the VLAN tag of a frame among the PACKET_AUXDATA control messages received along with it
+func receiveVLANTag(oob []byte) (tpid uint16, tci uint16, ok bool) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, 0, false
+	}
+	for _, message := range messages {
+		if message.Header.Level != syscall.SOL_PACKET || message.Header.Type != unix.PACKET_AUXDATA ||
+			len(message.Data) < int(unsafe.Sizeof(unix.TpacketAuxdata{})) {
+			continue
+		}
+		auxdata := (*unix.TpacketAuxdata)(unsafe.Pointer(&message.Data[0]))
+		if auxdata.Status&unix.TP_STATUS_VLAN_VALID == 0 {
+			return 0, 0, false
+		}
+		// Kernels that don't tell the TPID only strip 802.1Q tags
+		tpid = 0x8100
+		if auxdata.Status&unix.TP_STATUS_VLAN_TPID_VALID != 0 {
+			tpid = auxdata.Vlan_tpid
+		}
+		return tpid, auxdata.Vlan_tci, true
 	}
-	return buf[:n], nil
+	return 0, 0, false
 }
 
+func (us *UnixCapturer) Capture(ctx context.Context) ([]byte, units.CaptureInfo, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	buf := make([]byte, DefaultSnapLen)
+	oob := make([]byte, syscall.CmsgSpace(int(unsafe.Sizeof(syscall.Timespec{})))+
+		syscall.CmsgSpace(int(unsafe.Sizeof(unix.TpacketAuxdata{}))))
+	// Frames of cooked sockets are received after the room left for the cooked header
+	frame := buf
+	if us.linkType == units.LinkTypeLinuxSLL2 {
+		frame = buf[cookedHeaderLength:]
+	}
+	var n, oobn int
+	var from syscall.Sockaddr
+	for {
+		if us.closed.Load() {
+			return nil, units.CaptureInfo{}, ErrClosed
+		}
+		var err error
+		// MSG_TRUNC makes the length of the whole frame come back even if it didn't fit into the buffer. The socket is
+		// only read once poll says so, a blocking read couldn't be interrupted
+		n, oobn, _, from, err = syscall.Recvmsg(us.sock, frame, oob, syscall.MSG_TRUNC|syscall.MSG_DONTWAIT)
+		if err == nil {
+			break
+		}
+		// The interface went down, the kernel hooks the socket up again once it's back up
+		if err != syscall.EAGAIN && err != syscall.EINTR && err != syscall.ENETDOWN {
+			return nil, units.CaptureInfo{}, fmt.Errorf("failed to receive a frame: %v", err)
+		}
+		if err := us.waker.wait(ctx, us.sock); err != nil {
+			return nil, units.CaptureInfo{}, err
+		}
+	}
+	n += len(buf) - len(frame)
+	info := units.CaptureInfo{
+		CaptureLength:  min(n, len(buf)),
+		Length:         n,
+		LinkType:       us.linkType,
+		InterfaceIndex: us.ifindex,
+		InterfaceName:  us.iface,
+	}
+	if sll, ok := from.(*syscall.SockaddrLinklayer); ok {
+		info.PacketType = packetType(sll.Pkttype)
+		info.InterfaceIndex = sll.Ifindex
+		if us.ifindex == 0 {
+			info.InterfaceName = interfaceName(us.Namespace, sll.Ifindex)
+		}
+		if us.linkType == units.LinkTypeLinuxSLL2 {
+			putCookedHeader(buf, sll.Protocol, sll.Ifindex, sll.Hatype, sll.Pkttype, sll.Halen, sll.Addr[:])
+		}
+	}
+	if timestamp, ok := receiveTimestamp(oob[:oobn]); ok {
+		info.Timestamp = timestamp
+	} else {
+		info.Timestamp = time.Now()
+	}
+	frame = buf[:info.CaptureLength]
+	if tpid, tci, ok := receiveVLANTag(oob[:oobn]); ok && us.linkType == units.LinkTypeEthernet {
+		frame = insertVLANTag(frame, tpid, tci)
+		info.CaptureLength, info.Length = len(frame), info.Length+4
+	}
+	return frame, info, nil
+}
+
+// Init opens the socket inside the namespace of the interface, where it stays
 func (us *UnixCapturer) Init(iface string) error {
-	if err := us.createSock(); err != nil {
+	return utils.InNetworkNamespace(us.Namespace, func() error {
+		return us.init(iface)
+	})
+}
+
+func (us *UnixCapturer) init(iface string) error {
+	linkType, err := interfaceLinkType(iface)
+	if err != nil {
 		return err
 	}
-	if err := us.bindToInterface(iface); err != nil {
-
+	cooked := us.Cooked || linkType == units.LinkTypeLinuxSLL2
+	program, err := compileFilter(us.Filter, cooked, linkType)
+	if err != nil {
+		return fmt.Errorf("%s: %v", iface, err)
+	}
+	sock, err := createPacketSocket(cooked)
+	if err != nil {
+		return err
+	}
+	us.sock = sock
+	if us.waker, err = newWaker(); err != nil {
+		us.release()
 		return err
 	}
+	if err := attachFilter(us.sock, program); err != nil {
+		us.release()
+		return err
+	}
+	// The kernel stamps every frame on arrival, which is way more precise than looking at the clock once it's read
+	if err := syscall.SetsockoptInt(us.sock, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1); err != nil {
+		us.release()
+		return err
+	}
+	// The kernel takes the outermost VLAN tag off received frames, it's told along with them to be put back
+	if err := syscall.SetsockoptInt(us.sock, syscall.SOL_PACKET, unix.PACKET_AUXDATA, 1); err != nil {
+		us.release()
+		return err
+	}
+	ifindex, err := bindToInterface(us.sock, iface)
+	if err != nil {
+		us.release()
+		return err
+	}
+	if us.FanoutGroup, err = joinFanout(us.sock, us.FanoutGroup, us.FanoutMode); err != nil {
+		us.release()
+		return err
+	}
+	if us.memberships, err = addMemberships(us.sock, ifindex, us.Promiscuous, us.AllMulticast); err != nil {
+		us.release()
+		return err
+	}
+	us.iface, us.ifindex = iface, ifindex
+	us.linkType = linkType
+	if cooked {
+		us.linkType = units.LinkTypeLinuxSLL2
+	}
 	return nil
 }
+
+// LinkType returns the link type of the captured frames, it's known once Init succeeded
+func (us *UnixCapturer) LinkType() units.LinkType {
+	return us.linkType
+}
+
+func (us *UnixCapturer) Stats() (Stats, error) {
+	return us.stats.update(us.sock, false)
+}
+
+// Close makes a Capture running in another goroutine return ErrClosed and waits for it before closing the socket
+func (us *UnixCapturer) Close() error {
+	if us.closed.Swap(true) {
+		return nil
+	}
+	us.waker.wake()
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	us.stats.close(us.sock, false)
+	err := dropMemberships(us.sock, us.ifindex, us.memberships)
+	us.memberships = nil
+	if releaseErr := us.release(); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+func (us *UnixCapturer) release() error {
+	us.waker.close()
+	return syscall.Close(us.sock)
+}
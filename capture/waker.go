@@ -0,0 +1,62 @@
+package capture
+
+import (
+	"context"
+	"encoding/binary"
+
+	"golang.org/x/sys/unix"
+)
+
+// waker interrupts a poll on a socket from another goroutine, which is how Close and cancelled contexts get a blocked
+// Capture to return. Closing a socket doesn't wake up the threads polling it
+type waker struct {
+	fd int // eventfd
+}
+
+func newWaker() (waker, error) {
+	fd, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		return waker{fd: -1}, err
+	}
+	return waker{fd: fd}, nil
+}
+
+func (w waker) wake() {
+	counter := make([]byte, 8)
+	binary.NativeEndian.PutUint64(counter, 1)
+	unix.Write(w.fd, counter)
+}
+
+// wait blocks until the socket is readable, wake is called or the context is done. Only the last one is reported,
+// the caller is expected to check why it was woken up
+func (w waker) wait(ctx context.Context, sock int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, w.wake)
+	defer stop()
+	fds := []unix.PollFd{
+		{Fd: int32(sock), Events: unix.POLLIN | unix.POLLERR},
+		{Fd: int32(w.fd), Events: unix.POLLIN},
+	}
+	for {
+		_, err := unix.Poll(fds, -1)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fds[1].Revents != 0 {
+			unix.Read(w.fd, make([]byte, 8))
+		}
+		return ctx.Err()
+	}
+}
+
+func (w waker) close() error {
+	if w.fd < 0 {
+		return nil
+	}
+	return unix.Close(w.fd)
+}
@@ -0,0 +1,715 @@
+package filter
+
+import (
+	"fmt"
+	"net"
+	units "packet_sniffer/model"
+	"strconv"
+	"strings"
+)
+
+// test is the smallest unit a filter is made of: load a value from the frame, optionally mask it, and compare it
+type test struct {
+	size     uint16 // bpfB, bpfH or bpfW
+	offset   uint32
+	network  bool // the offset is relative to the start of the network header
+	protocol bool // the offset is the one of the EtherType, which moves past VLAN tags along with the network header
+	indirect bool // the offset is relative to the end of the IPv4 header
+	mask     uint32
+	jump     uint16 // bpfJEQ, bpfJGT, bpfJGE or bpfJSET
+	value    uint32
+}
+
+// etherTypeNode matches the protocol of the network header, where it's found depends on the link type
+type etherTypeNode struct {
+	value uint32
+}
+
+// pastTag matches its operand in frames whose outermost VLAN tag is still there, the EtherType and the network header
+// come a tag later
+type pastTag struct {
+	operand node
+}
+
+// constant is what a node turns into when the link type decides it on its own, such as ip6 on raw IPv4 frames
+type constant bool
+
+// Offsets of the IPv4, IPv6 and ARP headers fields, relative to the start of the network header
+const (
+	ipv4ProtocolOffset = 9
+	ipv4FlagsOffset    = 6
+	ipv4SrcOffset      = 12
+	ipv4DstOffset      = 16
+	ipv6NextOffset     = 6
+	ipv6SrcOffset      = 8
+	ipv6DstOffset      = 24
+	ipv6PayloadOffset  = 40
+	arpSrcOffset       = 14
+	arpDstOffset       = 24
+
+	vlanTagLength = 4
+)
+
+var etherTypes = map[string]uint32{
+	"ip":   0x0800,
+	"arp":  0x0806,
+	"rarp": 0x8035,
+	"ip6":  0x86dd,
+}
+
+var ipProtocols = map[string]uint32{
+	"icmp":  1,
+	"tcp":   6,
+	"udp":   17,
+	"icmp6": 58,
+	"sctp":  132,
+}
+
+func etherType(value uint32) node {
+	return etherTypeNode{value}
+}
+
+func equals(size uint16, offset uint32, value uint32) node {
+	return test{size: size, offset: offset, jump: bpfJEQ, value: value}
+}
+
+// inNetworkHeader matches a field of the network header
+func inNetworkHeader(size uint16, offset uint32, value uint32) node {
+	return test{size: size, offset: offset, network: true, jump: bpfJEQ, value: value}
+}
+
+func anyOf(nodes ...node) node {
+	result := nodes[0]
+	for _, n := range nodes[1:] {
+		result = orNode{result, n}
+	}
+	return result
+}
+
+func allOf(nodes ...node) node {
+	result := nodes[0]
+	for _, n := range nodes[1:] {
+		result = andNode{result, n}
+	}
+	return result
+}
+
+// ipProtocol matches IPv4 and IPv6 packets carrying the given protocol, the family can be narrowed down to ip or ip6
+func ipProtocol(family string, protocol uint32) node {
+	v4 := allOf(etherType(etherTypes["ip"]), inNetworkHeader(bpfB, ipv4ProtocolOffset, protocol))
+	// Only a transport header right after the fixed IPv6 header is recognized, as is the case with libpcap
+	v6 := allOf(etherType(etherTypes["ip6"]), inNetworkHeader(bpfB, ipv6NextOffset, protocol))
+	switch family {
+	case "ip":
+		return v4
+	case "ip6":
+		return v6
+	}
+	return anyOf(v4, v6)
+}
+
+// withDirection builds the node for a source and a destination match out of the node for a single one
+func withDirection(direction string, build func(src bool) (node, error)) (node, error) {
+	switch direction {
+	case directionSrc, directionDst:
+		return build(direction == directionSrc)
+	}
+	src, err := build(true)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := build(false)
+	if err != nil {
+		return nil, err
+	}
+	if direction == directionBoth {
+		return andNode{src, dst}, nil
+	}
+	return orNode{src, dst}, nil
+}
+
+// expand turns primitives into tests, leaving the boolean structure of the expression as it is. Once a VLAN tag has
+// been matched, the next ones can only be found in the frame
+func expand(n node, tagsInFrame bool) (node, error) {
+	switch n := n.(type) {
+	case andNode:
+		return expandConjunction(conjunction(n), tagsInFrame)
+	case orNode:
+		left, err := expand(n.left, tagsInFrame)
+		if err != nil {
+			return nil, err
+		}
+		right, err := expand(n.right, tagsInFrame)
+		if err != nil {
+			return nil, err
+		}
+		return orNode{left, right}, nil
+	case notNode:
+		operand, err := expand(n.operand, tagsInFrame)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	case primitive:
+		if n.kind == "vlan" {
+			stripped, inFrame, err := vlan(n.value, tagsInFrame)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", n, err)
+			}
+			if stripped == nil {
+				return inFrame, nil
+			}
+			return orNode{stripped, inFrame}, nil
+		}
+		expanded, err := expandPrimitive(n)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", n, err)
+		}
+		return expanded, nil
+	}
+	return nil, fmt.Errorf("unexpected expression %v", n)
+}
+
+// conjunction flattens a chain of ands into its operands, in the order they were written
+func conjunction(n node) []node {
+	if and, ok := n.(andNode); ok {
+		return append(conjunction(and.left), conjunction(and.right)...)
+	}
+	return []node{n}
+}
+
+// expandConjunction expands the operands of a chain of ands. As with tcpdump, what follows a vlan primitive is matched
+// past its tag: a tag later when the tag is still in the frame, where it would be otherwise when the kernel took it out
+func expandConjunction(operands []node, tagsInFrame bool) (node, error) {
+	var expanded []node
+	for i, operand := range operands {
+		p, ok := operand.(primitive)
+		if !ok || p.kind != "vlan" || i == len(operands)-1 {
+			n, err := expand(operand, tagsInFrame)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, n)
+			continue
+		}
+		stripped, inFrame, err := vlan(p.value, tagsInFrame)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", p, err)
+		}
+		rest, err := expandConjunction(operands[i+1:], true)
+		if err != nil {
+			return nil, err
+		}
+		var tagged node = andNode{inFrame, pastTag{rest}}
+		if stripped != nil {
+			tagged = orNode{andNode{stripped, rest}, tagged}
+		}
+		return allOf(append(expanded, tagged)...), nil
+	}
+	return allOf(expanded...), nil
+}
+
+func (p primitive) String() string {
+	parts := make([]string, 0, 4)
+	for _, part := range []string{p.protocol, p.direction, p.kind, p.value} {
+		if part != "" && part != directionEither {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func expandPrimitive(p primitive) (node, error) {
+	switch p.kind {
+	case "":
+		return expandProtocol(p.protocol)
+	case "host":
+		if p.protocol == "ether" {
+			return withDirection(p.direction, func(src bool) (node, error) { return etherHost(p.value, src) })
+		}
+		return withDirection(p.direction, func(src bool) (node, error) { return ipHost(p.protocol, p.value, src) })
+	case "net":
+		return withDirection(p.direction, func(src bool) (node, error) { return ipNet(p.protocol, p.value, src) })
+	case "port", "portrange":
+		return withDirection(p.direction, func(src bool) (node, error) { return transportPort(p.protocol, p.kind, p.value, src) })
+	case "proto":
+		if p.direction != directionEither {
+			return nil, fmt.Errorf("proto can't have a direction")
+		}
+		return expandProto(p.protocol, p.value)
+	}
+	return nil, fmt.Errorf("unknown primitive")
+}
+
+func expandProtocol(protocol string) (node, error) {
+	if value, hit := etherTypes[protocol]; hit {
+		return etherType(value), nil
+	}
+	switch protocol {
+	case "icmp":
+		return ipProtocol("ip", ipProtocols[protocol]), nil
+	case "icmp6":
+		return ipProtocol("ip6", ipProtocols[protocol]), nil
+	case "tcp", "udp", "sctp":
+		return ipProtocol("", ipProtocols[protocol]), nil
+	}
+	return nil, fmt.Errorf("%q needs a qualifier such as host or proto", protocol)
+}
+
+func expandProto(protocol string, value string) (node, error) {
+	if protocol == "ether" {
+		number, hit := etherTypes[value]
+		if !hit {
+			parsed, err := strconv.ParseUint(value, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("unknown EtherType %q", value)
+			}
+			number = uint32(parsed)
+		}
+		return etherType(number), nil
+	}
+	number, hit := ipProtocols[value]
+	if !hit {
+		parsed, err := strconv.ParseUint(value, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("unknown IP protocol %q", value)
+		}
+		number = uint32(parsed)
+	}
+	switch protocol {
+	case "", "ip", "ip6":
+		return ipProtocol(protocol, number), nil
+	}
+	return nil, fmt.Errorf("%s has no protocol field", protocol)
+}
+
+func etherHost(value string, src bool) (node, error) {
+	mac, err := net.ParseMAC(value)
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Errorf("invalid MAC address %q", value)
+	}
+	var offset uint32 = 0
+	if src {
+		offset = 6
+	}
+	return allOf(
+		equals(bpfH, offset, uint32(mac[0])<<8|uint32(mac[1])),
+		equals(bpfW, offset+2, uint32(mac[2])<<24|uint32(mac[3])<<16|uint32(mac[4])<<8|uint32(mac[5])),
+	), nil
+}
+
+func lookupHost(value string) ([]net.IP, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ips, err := net.LookupIP(value)
+	if err != nil {
+		return nil, fmt.Errorf("unknown host %q", value)
+	}
+	return ips, nil
+}
+
+func ipHost(protocol string, value string, src bool) (node, error) {
+	ips, err := lookupHost(value)
+	if err != nil {
+		return nil, err
+	}
+	var matches []node
+	for _, ip := range ips {
+		bits := net.IPv6len * 8
+		if ip.To4() != nil {
+			bits = net.IPv4len * 8
+		}
+		if match := addressMatch(protocol, ip, net.CIDRMask(bits, bits), src); match != nil {
+			matches = append(matches, match)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%q has no address %s can carry", value, protocol)
+	}
+	return anyOf(matches...), nil
+}
+
+func ipNet(protocol string, value string, src bool) (node, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid network %q", value)
+		}
+		if ip.To4() != nil {
+			value += "/32"
+		} else {
+			value += "/128"
+		}
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q", value)
+	}
+	if match := addressMatch(protocol, network.IP, network.Mask, src); match != nil {
+		return match, nil
+	}
+	return nil, fmt.Errorf("%s can't carry %q", protocol, value)
+}
+
+// maskedWords compares an address at the given offset word by word, ignoring words that are masked out entirely
+func maskedWords(offset uint32, address []byte, mask []byte) node {
+	var words []node
+	for i := 0; i < len(address); i += 4 {
+		wordMask := uint32(mask[i])<<24 | uint32(mask[i+1])<<16 | uint32(mask[i+2])<<8 | uint32(mask[i+3])
+		if wordMask == 0 {
+			continue
+		}
+		word := uint32(address[i])<<24 | uint32(address[i+1])<<16 | uint32(address[i+2])<<8 | uint32(address[i+3])
+		t := test{size: bpfW, offset: offset + uint32(i), network: true, jump: bpfJEQ, value: word & wordMask}
+		if wordMask != 0xffffffff {
+			t.mask = wordMask
+		}
+		words = append(words, t)
+	}
+	if len(words) == 0 {
+		// A zero length prefix matches any address, comparing the first word against itself does just that
+		return test{size: bpfW, offset: offset, network: true, jump: bpfJGE, value: 0}
+	}
+	return allOf(words...)
+}
+
+// addressMatch returns nil if the protocol can't carry an address of the given family
+func addressMatch(protocol string, ip net.IP, mask net.IPMask, src bool) node {
+	if ip4 := ip.To4(); ip4 != nil {
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+		ipOffset, arpOffset := uint32(ipv4DstOffset), uint32(arpDstOffset)
+		if src {
+			ipOffset, arpOffset = ipv4SrcOffset, arpSrcOffset
+		}
+		matchIP := allOf(etherType(etherTypes["ip"]), maskedWords(ipOffset, ip4, mask))
+		matchARP := allOf(etherType(etherTypes["arp"]), maskedWords(arpOffset, ip4, mask))
+		matchRARP := allOf(etherType(etherTypes["rarp"]), maskedWords(arpOffset, ip4, mask))
+		switch protocol {
+		case "":
+			return anyOf(matchIP, matchARP, matchRARP)
+		case "ip":
+			return matchIP
+		case "arp":
+			return matchARP
+		case "rarp":
+			return matchRARP
+		}
+		return nil
+	}
+	if protocol != "" && protocol != "ip6" {
+		return nil
+	}
+	offset := uint32(ipv6DstOffset)
+	if src {
+		offset = ipv6SrcOffset
+	}
+	return allOf(etherType(etherTypes["ip6"]), maskedWords(offset, ip.To16(), mask))
+}
+
+func parsePort(value string) (uint32, error) {
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err == nil {
+		return uint32(port), nil
+	}
+	// An empty name looks up as port 0
+	named, err := net.LookupPort("tcp", value)
+	if err != nil || value == "" {
+		return 0, fmt.Errorf("unknown port %q", value)
+	}
+	return uint32(named), nil
+}
+
+func transportPort(protocol string, kind string, value string, src bool) (node, error) {
+	low, high := value, value
+	if kind == "portrange" {
+		bounds := strings.SplitN(value, "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid port range %q", value)
+		}
+		low, high = bounds[0], bounds[1]
+	}
+	lowPort, err := parsePort(low)
+	if err != nil {
+		return nil, err
+	}
+	highPort, err := parsePort(high)
+	if err != nil {
+		return nil, err
+	}
+	if lowPort > highPort {
+		lowPort, highPort = highPort, lowPort
+	}
+
+	var transports []uint32
+	family := ""
+	switch protocol {
+	case "tcp", "udp", "sctp":
+		transports = []uint32{ipProtocols[protocol]}
+	case "", "ip", "ip6":
+		transports = []uint32{ipProtocols["tcp"], ipProtocols["udp"], ipProtocols["sctp"]}
+		family = protocol
+	default:
+		return nil, fmt.Errorf("%s has no ports", protocol)
+	}
+
+	// Source ports come first in the headers of every transport protocol we know
+	var portOffset uint32 = 2
+	if src {
+		portOffset = 0
+	}
+	portIn := func(t test) node {
+		if lowPort == highPort {
+			t.jump, t.value = bpfJEQ, lowPort
+			return t
+		}
+		above := t
+		above.jump, above.value = bpfJGE, lowPort
+		below := t
+		below.jump, below.value = bpfJGT, highPort
+		return allOf(above, notNode{below})
+	}
+
+	var v4Transports, v6Transports []node
+	for _, transport := range transports {
+		v4Transports = append(v4Transports, inNetworkHeader(bpfB, ipv4ProtocolOffset, transport))
+		v6Transports = append(v6Transports, inNetworkHeader(bpfB, ipv6NextOffset, transport))
+	}
+	v4 := allOf(
+		etherType(etherTypes["ip"]),
+		anyOf(v4Transports...),
+		// Only the first fragment carries the transport header
+		notNode{test{size: bpfH, offset: ipv4FlagsOffset, network: true, jump: bpfJSET, value: 0x1fff}},
+		portIn(test{size: bpfH, offset: portOffset, indirect: true}),
+	)
+	v6 := allOf(
+		etherType(etherTypes["ip6"]),
+		anyOf(v6Transports...),
+		portIn(test{size: bpfH, offset: ipv6PayloadOffset + portOffset, network: true}),
+	)
+	switch family {
+	case "ip":
+		return v4, nil
+	case "ip6":
+		return v6, nil
+	}
+	return anyOf(v4, v6), nil
+}
+
+// vlan matches tagged frames by their outermost tag. The kernel strips it off received frames and reports it out of
+// band, so the frame itself is only looked at when it didn't. The tags that follow another one are always in the frame,
+// stripped is nil for them
+func vlan(value string, tagsInFrame bool) (stripped node, inFrame node, err error) {
+	inFrame = anyOf(etherType(0x8100), etherType(0x88a8))
+	var id uint64
+	if value != "" {
+		if id, err = strconv.ParseUint(value, 10, 12); err != nil {
+			return nil, nil, fmt.Errorf("invalid VLAN ID %q", value)
+		}
+		// The tag comes where the network header would
+		inFrame = allOf(inFrame, test{size: bpfH, offset: 0, network: true, mask: 0xfff, jump: bpfJEQ, value: uint32(id)})
+	}
+	if tagsInFrame {
+		return nil, inFrame, nil
+	}
+
+	tagPresent := notNode{equals(bpfW, ancillaryVLANTagPresent, 0)}
+	stripped = tagPresent
+	if value != "" {
+		stripped = allOf(tagPresent, test{size: bpfW, offset: ancillaryVLANTag, mask: 0xfff, jump: bpfJEQ, value: uint32(id)})
+	}
+	return stripped, allOf(notNode{tagPresent}, inFrame), nil
+}
+
+type label int
+
+type pendingInstruction struct {
+	Instruction
+	trueLabel, falseLabel label // targets of conditional jumps
+}
+
+type generator struct {
+	network      uint32 // offset of the network header
+	tags         uint32 // length of the VLAN tags in the frame that the network header and its EtherType come after
+	instructions []pendingInstruction
+	labels       []int // position of every label, -1 while it's not placed
+}
+
+func (g *generator) newLabel() label {
+	g.labels = append(g.labels, -1)
+	return label(len(g.labels) - 1)
+}
+
+func (g *generator) place(l label) {
+	g.labels[l] = len(g.instructions)
+}
+
+func (g *generator) emit(code uint16, k uint32) {
+	g.instructions = append(g.instructions, pendingInstruction{Instruction: Instruction{Code: code, K: k}})
+}
+
+func (g *generator) emitJump(code uint16, k uint32, whenTrue label, whenFalse label) {
+	g.instructions = append(g.instructions, pendingInstruction{
+		Instruction: Instruction{Code: code, K: k},
+		trueLabel:   whenTrue,
+		falseLabel:  whenFalse,
+	})
+}
+
+// generate emits the code for a node, jumping to whenTrue if it matches and to whenFalse otherwise
+func (g *generator) generate(n node, whenTrue label, whenFalse label) {
+	switch n := n.(type) {
+	case andNode:
+		next := g.newLabel()
+		g.generate(n.left, next, whenFalse)
+		g.place(next)
+		g.generate(n.right, whenTrue, whenFalse)
+	case orNode:
+		next := g.newLabel()
+		g.generate(n.left, whenTrue, next)
+		g.place(next)
+		g.generate(n.right, whenTrue, whenFalse)
+	case notNode:
+		g.generate(n.operand, whenFalse, whenTrue)
+	case pastTag:
+		g.tags += vlanTagLength
+		g.generate(n.operand, whenTrue, whenFalse)
+		g.tags -= vlanTagLength
+	case test:
+		network := g.network + g.tags
+		offset := n.offset
+		if n.network {
+			offset += network
+		}
+		if n.protocol {
+			offset += g.tags
+		}
+		if n.indirect {
+			// X = length of the IPv4 header
+			g.emit(bpfLDX|bpfB|bpfMSH, network)
+			g.emit(bpfLD|n.size|bpfIND, network+offset)
+		} else {
+			g.emit(bpfLD|n.size|bpfABS, offset)
+		}
+		if n.mask != 0 {
+			g.emit(bpfALU|bpfAND|bpfK, n.mask)
+		}
+		g.emitJump(bpfJMP|n.jump|bpfK, n.value, whenTrue, whenFalse)
+	}
+}
+
+// maxInstructions is the length of the longest program the kernel accepts
+const maxInstructions = 4096
+
+// farJumps makes every conditional jump reach its targets, which can't be more than 255 instructions away. Far
+// targets are jumped to through unconditional jumps inserted right after the conditional one, where nothing falls
+// through. The instructions inserted may take other jumps out of range in turn, so this goes on until none is
+func (g *generator) farJumps() {
+	for inserted := true; inserted; {
+		inserted = false
+		for i := 0; i < len(g.instructions); i++ {
+			pending := g.instructions[i]
+			if pending.Code&0x07 != bpfJMP || pending.Code&0xf0 == bpfJA {
+				continue
+			}
+			var far []label
+			for _, target := range []label{pending.trueLabel, pending.falseLabel} {
+				if g.labels[target]-i-1 > 255 && (len(far) == 0 || far[0] != target) {
+					far = append(far, target)
+				}
+			}
+			if len(far) == 0 {
+				continue
+			}
+			for l, position := range g.labels {
+				if position > i {
+					g.labels[l] += len(far)
+				}
+			}
+			trampolines := make([]pendingInstruction, len(far))
+			for t, target := range far {
+				trampoline := g.newLabel()
+				g.labels[trampoline] = i + 1 + t
+				trampolines[t] = pendingInstruction{Instruction: Instruction{Code: bpfJMP | bpfJA}, trueLabel: target}
+				if pending.trueLabel == target {
+					g.instructions[i].trueLabel = trampoline
+				}
+				if pending.falseLabel == target {
+					g.instructions[i].falseLabel = trampoline
+				}
+			}
+			g.instructions = append(g.instructions[:i+1], append(trampolines, g.instructions[i+1:]...)...)
+			inserted = true
+		}
+	}
+}
+
+func (g *generator) resolve() (Program, error) {
+	g.farJumps()
+	if len(g.instructions) > maxInstructions {
+		return nil, fmt.Errorf("filter expression is too complex: %d instructions, at most %d", len(g.instructions),
+			maxInstructions)
+	}
+	program := make(Program, len(g.instructions))
+	for i, pending := range g.instructions {
+		program[i] = pending.Instruction
+		if pending.Code&0x07 != bpfJMP {
+			continue
+		}
+		if pending.Code&0xf0 == bpfJA {
+			program[i].K = uint32(g.labels[pending.trueLabel] - i - 1)
+			continue
+		}
+		jumpTrue := g.labels[pending.trueLabel] - i - 1
+		jumpFalse := g.labels[pending.falseLabel] - i - 1
+		program[i].Jt, program[i].Jf = uint8(jumpTrue), uint8(jumpFalse)
+	}
+	return program, nil
+}
+
+// Compile turns a filter expression into a classic BPF program that accepts up to snapLen bytes of the matching frames
+// of a link type. A nil expression yields an empty program, meaning that there is nothing to filter
+func Compile(expression *Expression, snapLen int, linkType units.LinkType) (Program, error) {
+	layer, hit := linkLayers[linkType]
+	if !hit {
+		layer = linkLayer{linkType: linkType}
+	}
+	return compile(expression, snapLen, layer)
+}
+
+// CompileCooked compiles an expression for packet sockets that receive frames without their link-layer header. The
+// protocol of the frames is looked up in the metadata the kernel keeps about them instead
+func CompileCooked(expression *Expression, snapLen int) (Program, error) {
+	return compile(expression, snapLen, linkLayer{cooked: true})
+}
+
+func compile(expression *Expression, snapLen int, layer linkLayer) (Program, error) {
+	if expression == nil {
+		return nil, nil
+	}
+	expanded, err := expand(expression.root, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter expression: %v", err)
+	}
+	lowered, err := layer.lower(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter expression: %v", err)
+	}
+	if matches, ok := lowered.(constant); ok {
+		if matches {
+			return Program{{Code: bpfRET | bpfK, K: uint32(snapLen)}}, nil
+		}
+		return Program{{Code: bpfRET | bpfK, K: 0}}, nil
+	}
+
+	g := generator{network: layer.network}
+	accept, reject := g.newLabel(), g.newLabel()
+	g.generate(lowered, accept, reject)
+	g.place(accept)
+	g.emit(bpfRET|bpfK, uint32(snapLen))
+	g.place(reject)
+	g.emit(bpfRET|bpfK, 0)
+	return g.resolve()
+}
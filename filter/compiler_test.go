@@ -0,0 +1,340 @@
+package filter
+
+import (
+	"fmt"
+	units "packet_sniffer/model"
+	"strings"
+	"testing"
+)
+
+// disassemble prints a program the way tcpdump -d does, jumps with their absolute targets
+func disassemble(program Program) string {
+	sizes := map[uint16]string{bpfW: "ld", bpfH: "ldh", bpfB: "ldb"}
+	jumps := map[uint16]string{bpfJEQ: "jeq", bpfJGT: "jgt", bpfJGE: "jge", bpfJSET: "jset"}
+	alu := map[uint16]string{bpfADD: "add", bpfSUB: "sub", bpfMUL: "mul", bpfDIV: "div", bpfAND: "and", bpfOR: "or",
+		bpfLSH: "lsh", bpfRSH: "rsh", bpfMOD: "mod", bpfXOR: "xor"}
+	lines := make([]string, len(program))
+	for pc, instruction := range program {
+		code, k := instruction.Code, instruction.K
+		var text string
+		switch code & 0x07 {
+		case bpfLD:
+			switch code & 0xe0 {
+			case bpfABS:
+				text = fmt.Sprintf("%s [%d]", sizes[code&0x18], int32(k))
+			case bpfIND:
+				text = fmt.Sprintf("%s [x + %d]", sizes[code&0x18], int32(k))
+			case bpfIMM:
+				text = fmt.Sprintf("ld #0x%x", k)
+			case bpfLEN:
+				text = "ld #pktlen"
+			case bpfMEM:
+				text = fmt.Sprintf("ld M[%d]", k)
+			}
+		case bpfLDX:
+			switch code & 0xe0 {
+			case bpfMSH:
+				text = fmt.Sprintf("ldxb 4*([%d]&0xf)", k)
+			case bpfIMM:
+				text = fmt.Sprintf("ldx #0x%x", k)
+			case bpfMEM:
+				text = fmt.Sprintf("ldx M[%d]", k)
+			}
+		case bpfST:
+			text = fmt.Sprintf("st M[%d]", k)
+		case bpfSTX:
+			text = fmt.Sprintf("stx M[%d]", k)
+		case bpfALU:
+			if code&0xf0 == bpfNEG {
+				text = "neg"
+			} else if code&bpfX != 0 {
+				text = fmt.Sprintf("%s x", alu[code&0xf0])
+			} else {
+				text = fmt.Sprintf("%s #0x%x", alu[code&0xf0], k)
+			}
+		case bpfJMP:
+			if code&0xf0 == bpfJA {
+				text = fmt.Sprintf("ja %d", pc+1+int(k))
+			} else {
+				text = fmt.Sprintf("%s #0x%x jt %d jf %d", jumps[code&0xf0], k, pc+1+int(instruction.Jt),
+					pc+1+int(instruction.Jf))
+			}
+		case bpfRET:
+			text = fmt.Sprintf("ret #%d", k)
+		case bpfMISC:
+			if code&0xf8 == bpfTXA {
+				text = "txa"
+			} else {
+				text = "tax"
+			}
+		}
+		lines[pc] = fmt.Sprintf("(%03d) %s", pc, text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tcpdumpListings are what tcpdump -d prints for expressions short enough that the optimizer of libpcap leaves them
+// as they are
+var tcpdumpListings = []struct {
+	expression string
+	listing    string
+}{
+	{"arp", `(000) ldh [12]
+(001) jeq #0x806 jt 2 jf 3
+(002) ret #262144
+(003) ret #0`},
+	{"ip", `(000) ldh [12]
+(001) jeq #0x800 jt 2 jf 3
+(002) ret #262144
+(003) ret #0`},
+	{"ip6", `(000) ldh [12]
+(001) jeq #0x86dd jt 2 jf 3
+(002) ret #262144
+(003) ret #0`},
+	{"ether proto 0x88cc", `(000) ldh [12]
+(001) jeq #0x88cc jt 2 jf 3
+(002) ret #262144
+(003) ret #0`},
+	{"icmp", `(000) ldh [12]
+(001) jeq #0x800 jt 2 jf 5
+(002) ldb [23]
+(003) jeq #0x1 jt 4 jf 5
+(004) ret #262144
+(005) ret #0`},
+	{"ip proto 47", `(000) ldh [12]
+(001) jeq #0x800 jt 2 jf 5
+(002) ldb [23]
+(003) jeq #0x2f jt 4 jf 5
+(004) ret #262144
+(005) ret #0`},
+	{"ip src host 10.0.0.1", `(000) ldh [12]
+(001) jeq #0x800 jt 2 jf 5
+(002) ld [26]
+(003) jeq #0xa000001 jt 4 jf 5
+(004) ret #262144
+(005) ret #0`},
+	{"ip dst host 10.0.0.2", `(000) ldh [12]
+(001) jeq #0x800 jt 2 jf 5
+(002) ld [30]
+(003) jeq #0xa000002 jt 4 jf 5
+(004) ret #262144
+(005) ret #0`},
+	{"ip dst net 10.0.0.0/8", `(000) ldh [12]
+(001) jeq #0x800 jt 2 jf 6
+(002) ld [30]
+(003) and #0xff000000
+(004) jeq #0xa000000 jt 5 jf 6
+(005) ret #262144
+(006) ret #0`},
+}
+
+func TestCompileMatchesTcpdump(t *testing.T) {
+	for _, test := range tcpdumpListings {
+		program, err := Compile(mustParse(t, test.expression), 262144, units.LinkTypeEthernet)
+		if err != nil {
+			t.Errorf("%q: %v", test.expression, err)
+			continue
+		}
+		if listing := disassemble(program); listing != test.listing {
+			t.Errorf("%q: got\n%s\nwant\n%s", test.expression, listing, test.listing)
+		}
+	}
+}
+
+func TestCompileRejectsInvalidExpressions(t *testing.T) {
+	for _, expression := range []string{"port 70000", "portrange 10-", "vlan 4096", "icmp port 7", "ip host 10.0.0"} {
+		parsed, err := Parse(expression)
+		if err != nil {
+			continue
+		}
+		if _, err := Compile(parsed, 262144, units.LinkTypeEthernet); err == nil {
+			t.Errorf("%q: got no error", expression)
+		}
+	}
+}
+
+// Sample frames, next to the udpFrame DNS query
+var (
+	// tcpFrame is an HTTP response from 10.0.0.2:80 to 192.168.1.5:51000, its IPv4 header has options
+	tcpFrame = []byte{
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x02, 0x08, 0x00, 0x46, 0x00,
+		0x00, 0x2c, 0x00, 0x01, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x02, 0xc0, 0xa8,
+		0x01, 0x05, 0x01, 0x01, 0x01, 0x00, 0x00, 0x50, 0xc7, 0x38, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00,
+		0x00, 0x01, 0x50, 0x12, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00,
+	}
+	// fragmentFrame is a later fragment of a datagram from 10.0.0.1 to 10.0.0.2, whose bytes only look like ports
+	fragmentFrame = []byte{
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00, 0x45, 0x00,
+		0x00, 0x1c, 0x00, 0x02, 0x00, 0xb9, 0x40, 0x11, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00,
+		0x00, 0x02, 0x9c, 0x40, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+	}
+	// arpFrame asks who has 10.0.0.2 on behalf of 10.0.0.1
+	arpFrame = []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x06, 0x00, 0x01,
+		0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x02,
+	}
+	// ipv6Frame is a DNS query from fe80::1 to fe80::2
+	ipv6Frame = []byte{
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x02, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x86, 0xdd, 0x60, 0x00,
+		0x00, 0x00, 0x00, 0x08, 0x11, 0x40, 0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x9c, 0x40, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+	}
+	// vlanFrame is the DNS query of udpFrame tagged for VLAN 100
+	vlanFrame = tagged(udpFrame, 0x8100, 100)
+	// qinqFrame carries it in VLAN 200 within the service VLAN 10
+	qinqFrame = tagged(tagged(udpFrame, 0x8100, 200), 0x88a8, 10)
+)
+
+// tagged inserts a VLAN tag after the MAC addresses of an Ethernet frame
+func tagged(frame []byte, tpid uint16, id uint16) []byte {
+	tag := []byte{byte(tpid >> 8), byte(tpid), byte(id >> 8), byte(id)}
+	result := append([]byte{}, frame[:12]...)
+	result = append(result, tag...)
+	return append(result, frame[12:]...)
+}
+
+func TestMatch(t *testing.T) {
+	frames := map[string][]byte{
+		"udp": udpFrame, "tcp": tcpFrame, "fragment": fragmentFrame, "arp": arpFrame, "ipv6": ipv6Frame,
+		"vlan": vlanFrame, "qinq": qinqFrame,
+	}
+	tests := []struct {
+		expression string
+		matches    []string
+	}{
+		{"", []string{"udp", "tcp", "fragment", "arp", "ipv6", "vlan", "qinq"}},
+		{"arp", []string{"arp"}},
+		{"ip", []string{"udp", "tcp", "fragment"}},
+		{"ip6", []string{"ipv6"}},
+		{"udp", []string{"udp", "fragment", "ipv6"}},
+		{"tcp", []string{"tcp"}},
+		{"not ip", []string{"arp", "ipv6", "vlan", "qinq"}},
+		{"port 53", []string{"udp", "ipv6"}},
+		{"udp dst port 53", []string{"udp", "ipv6"}},
+		{"src port 53", nil},
+		{"tcp port 80", []string{"tcp"}},
+		{"tcp dst port 51000", []string{"tcp"}},
+		{"portrange 50-60", []string{"udp", "ipv6"}},
+		{"portrange 50000-52000", []string{"tcp"}},
+		// Hosts are looked for in ARP messages too
+		{"host 10.0.0.2", []string{"udp", "tcp", "fragment", "arp"}},
+		{"src host 10.0.0.2", []string{"tcp"}},
+		{"net 192.168.0.0/16", []string{"tcp"}},
+		{"host fe80::2", []string{"ipv6"}},
+		{"ip6 src host fe80::1 and udp", []string{"ipv6"}},
+		{"ether src 02:00:00:00:00:01", []string{"udp", "arp", "fragment", "ipv6", "vlan", "qinq"}},
+		{"ether dst 02:00:00:00:00:01", []string{"tcp"}},
+		{"ether proto 0x806", []string{"arp"}},
+		{"tcp or arp", []string{"tcp", "arp"}},
+		{"ip and not (udp or tcp)", nil},
+		// Tags are read from the frame when the kernel didn't take them out, and only the outermost one counts
+		{"vlan", []string{"vlan", "qinq"}},
+		{"vlan 100", []string{"vlan"}},
+		{"vlan 10", []string{"qinq"}},
+		{"vlan 200", nil},
+		{"not vlan", []string{"udp", "tcp", "fragment", "arp", "ipv6"}},
+		// What follows a tag in a conjunction is matched past it
+		{"vlan 100 and ip", []string{"vlan"}},
+		{"vlan and ip", []string{"vlan"}},
+		{"vlan 100 and udp port 53 and host 10.0.0.2", []string{"vlan"}},
+		{"vlan and not ip", []string{"qinq"}},
+		{"vlan 10 and ip", nil},
+		{"vlan 10 and vlan 200 and udp", []string{"qinq"}},
+		{"vlan 10 and vlan 100", nil},
+		{"ip and vlan", nil},
+	}
+	for _, test := range tests {
+		program, err := Compile(mustParse(t, test.expression), 262144, units.LinkTypeEthernet)
+		if err != nil {
+			t.Errorf("%q: %v", test.expression, err)
+			continue
+		}
+		want := make(map[string]bool)
+		for _, name := range test.matches {
+			want[name] = true
+		}
+		for name, frame := range frames {
+			if matches := program.Match(frame); matches != want[name] {
+				t.Errorf("%q on the %s frame: got %t, want %t", test.expression, name, matches, want[name])
+			}
+		}
+	}
+}
+
+func TestMatchRawIP(t *testing.T) {
+	tests := []struct {
+		expression string
+		matches    bool
+	}{
+		{"ip", true},
+		{"ip6", false},
+		{"udp port 53", true},
+		{"tcp", false},
+		{"src host 10.0.0.1", true},
+	}
+	for _, test := range tests {
+		program, err := Compile(mustParse(t, test.expression), 262144, units.LinkTypeRaw)
+		if err != nil {
+			t.Errorf("%q: %v", test.expression, err)
+			continue
+		}
+		if matches := program.Match(udpFrame[14:]); matches != test.matches {
+			t.Errorf("%q: got %t, want %t", test.expression, matches, test.matches)
+		}
+	}
+}
+
+func TestMatchTruncatedFrame(t *testing.T) {
+	// A load past the end of the frame rejects it, as it does in the kernel
+	program, err := Compile(mustParse(t, "port 53"), 262144, units.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for length := 0; length < 38; length++ {
+		if program.Match(udpFrame[:length]) {
+			t.Errorf("matched the first %d bytes", length)
+		}
+	}
+}
+
+func TestMatchLongExpression(t *testing.T) {
+	// The chain of alternatives takes jumps further than their 8-bit offsets reach
+	var hosts []string
+	for i := 0; i < 100; i++ {
+		hosts = append(hosts, fmt.Sprintf("host 172.16.0.%d", i))
+	}
+	chain := strings.Join(hosts, " or ")
+	tests := []struct {
+		expression string
+		matches    bool
+	}{
+		{chain, false},
+		{"host 10.0.0.2 or " + chain, true},
+		{chain + " or host 10.0.0.2", true},
+		{"not (" + chain + ")", true},
+		{"(" + chain + ") and udp", false},
+		{"(" + chain + " or port 53) and udp", true},
+	}
+	for _, test := range tests {
+		program, err := Compile(mustParse(t, test.expression), 262144, units.LinkTypeEthernet)
+		if err != nil {
+			t.Errorf("%d alternatives: %v", len(hosts), err)
+			continue
+		}
+		if len(program) <= 255 {
+			t.Fatalf("got a program of %d instructions, too short to test far jumps", len(program))
+		}
+		if matches := program.Match(udpFrame); matches != test.matches {
+			t.Errorf("%.40q: got %t, want %t", test.expression, matches, test.matches)
+		}
+	}
+
+	for i := len(hosts); i < 1000; i++ {
+		hosts = append(hosts, fmt.Sprintf("host 172.16.%d.%d", i/256, i%256))
+	}
+	if _, err := Compile(mustParse(t, strings.Join(hosts, " or ")), 262144, units.LinkTypeEthernet); err == nil {
+		t.Errorf("%d alternatives: got no error", len(hosts))
+	}
+}
@@ -0,0 +1,57 @@
+package filter
+
+import (
+	units "packet_sniffer/model"
+	"testing"
+)
+
+// udpFrame is an Ethernet frame of a DNS query from 10.0.0.1 to 10.0.0.2
+var udpFrame = []byte{
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00, 0x45, 0x00,
+	0x00, 0x1c, 0x00, 0x01, 0x00, 0x00, 0x40, 0x11, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00,
+	0x00, 0x02, 0x9c, 0x40, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+}
+
+func mustParse(t *testing.T, expression string) *Expression {
+	t.Helper()
+	parsed, err := Parse(expression)
+	if err != nil {
+		t.Fatalf("%q: %v", expression, err)
+	}
+	return parsed
+}
+
+func TestParseRejectsUnbalancedExpressions(t *testing.T) {
+	for _, expression := range []string{"tcp) or (udp", "(tcp", "tcp)", "udp or"} {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("%q: got no error", expression)
+		}
+	}
+}
+
+func TestAnd(t *testing.T) {
+	tests := []struct {
+		server, client string
+		matches        bool
+	}{
+		{server: "", client: "", matches: true},
+		{server: "udp", client: "", matches: true},
+		{server: "", client: "port 53", matches: true},
+		{server: "udp", client: "port 53", matches: true},
+		{server: "tcp", client: "udp", matches: false},
+		// The client's "or" stays within its own subtree
+		{server: "tcp", client: "tcp or udp", matches: false},
+		{server: "not host 10.0.0.1", client: "udp or tcp", matches: false},
+	}
+	for _, test := range tests {
+		joined := And(mustParse(t, test.server), mustParse(t, test.client))
+		program, err := Compile(joined, 65535, units.LinkTypeEthernet)
+		if err != nil {
+			t.Errorf("%q and %q: %v", test.server, test.client, err)
+			continue
+		}
+		if matches := program.Match(udpFrame); matches != test.matches {
+			t.Errorf("%q and %q: got %t, want %t", test.server, test.client, matches, test.matches)
+		}
+	}
+}
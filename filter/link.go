@@ -0,0 +1,153 @@
+package filter
+
+import (
+	"fmt"
+	units "packet_sniffer/model"
+)
+
+// ancillaryProtocol loads the EtherType of a frame from the metadata the kernel keeps about it
+const ancillaryProtocol = ancillaryOffset + 0
+
+// linkLayer tells where the protocol and the network header of frames are to be found
+type linkLayer struct {
+	linkType units.LinkType
+	network  uint32 // offset of the network header
+	cooked   bool   // frames start with their network header and their protocol is only known to the kernel
+}
+
+var linkLayers = map[units.LinkType]linkLayer{
+	units.LinkTypeNull:      {linkType: units.LinkTypeNull, network: 4},
+	units.LinkTypeEthernet:  {linkType: units.LinkTypeEthernet, network: 14},
+	units.LinkTypeRaw:       {linkType: units.LinkTypeRaw},
+	units.LinkTypeLinuxSLL:  {linkType: units.LinkTypeLinuxSLL, network: 16},
+	units.LinkTypeIPv4:      {linkType: units.LinkTypeIPv4},
+	units.LinkTypeIPv6:      {linkType: units.LinkTypeIPv6},
+	units.LinkTypeLinuxSLL2: {linkType: units.LinkTypeLinuxSLL2, network: 20},
+}
+
+// BSD loopback headers hold the address family in the byte order of the host that wrote them, and AF_INET6 isn't the
+// same on every system
+var nullFamilies = map[uint32][]uint32{
+	0x0800: {2},
+	0x86dd: {10, 24, 28, 30},
+}
+
+func (l linkLayer) String() string {
+	if l.cooked {
+		return "frames without a link-layer header"
+	}
+	if name, hit := units.LinkTypeStringMap[l.linkType]; hit {
+		return fmt.Sprintf("%s frames", name)
+	}
+	return fmt.Sprintf("frames of link type %d", l.linkType)
+}
+
+// etherType matches the protocol of the network header
+func (l linkLayer) etherType(value uint32) (node, error) {
+	if l.cooked {
+		return test{size: bpfW, offset: ancillaryProtocol, jump: bpfJEQ, value: value}, nil
+	}
+	switch l.linkType {
+	case units.LinkTypeEthernet:
+		return test{size: bpfH, offset: 12, protocol: true, jump: bpfJEQ, value: value}, nil
+	case units.LinkTypeLinuxSLL:
+		return test{size: bpfH, offset: 14, protocol: true, jump: bpfJEQ, value: value}, nil
+	case units.LinkTypeLinuxSLL2:
+		return equals(bpfH, 0, value), nil
+	case units.LinkTypeNull:
+		families, hit := nullFamilies[value]
+		if !hit {
+			return constant(false), nil
+		}
+		var matches []node
+		for _, family := range families {
+			matches = append(matches, equals(bpfW, 0, family), equals(bpfW, 0, family<<24))
+		}
+		return anyOf(matches...), nil
+	case units.LinkTypeRaw:
+		// The version is all there is to tell IPv4 and IPv6 apart
+		switch value {
+		case etherTypes["ip"]:
+			return test{size: bpfB, network: true, mask: 0xf0, jump: bpfJEQ, value: 0x40}, nil
+		case etherTypes["ip6"]:
+			return test{size: bpfB, network: true, mask: 0xf0, jump: bpfJEQ, value: 0x60}, nil
+		}
+		return constant(false), nil
+	case units.LinkTypeIPv4:
+		return constant(value == etherTypes["ip"]), nil
+	case units.LinkTypeIPv6:
+		return constant(value == etherTypes["ip6"]), nil
+	}
+	return nil, fmt.Errorf("protocols can't be matched on %s", l)
+}
+
+// lower fits the tests to the link-layer header, which is assumed to be Ethernet until then. Nodes that the link type
+// decides on its own are folded away
+func (l linkLayer) lower(n node) (node, error) {
+	switch n := n.(type) {
+	case andNode:
+		left, err := l.lower(n.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := l.lower(n.right)
+		if err != nil {
+			return nil, err
+		}
+		return combine(left, right, true), nil
+	case orNode:
+		left, err := l.lower(n.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := l.lower(n.right)
+		if err != nil {
+			return nil, err
+		}
+		return combine(left, right, false), nil
+	case notNode:
+		operand, err := l.lower(n.operand)
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := operand.(constant); ok {
+			return !c, nil
+		}
+		return notNode{operand}, nil
+	case pastTag:
+		operand, err := l.lower(n.operand)
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := operand.(constant); ok {
+			return c, nil
+		}
+		return pastTag{operand}, nil
+	case etherTypeNode:
+		return l.etherType(n.value)
+	case test:
+		linkHeader := !n.network && !n.indirect && n.offset < ancillaryOffset
+		if linkHeader && (l.cooked || l.linkType != units.LinkTypeEthernet) {
+			return nil, fmt.Errorf("link-layer addresses can't be matched on %s", l)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("unexpected expression %v", n)
+}
+
+// combine joins two lowered nodes with an and or an or, folding the constants away
+func combine(left node, right node, and bool) node {
+	for _, sides := range [][2]node{{left, right}, {right, left}} {
+		if c, ok := sides[0].(constant); ok {
+			// false decides an and on its own and true an or, otherwise the other side decides
+			if bool(c) != and {
+				return c
+			}
+			return sides[1]
+		}
+	}
+	if and {
+		return andNode{left, right}
+	}
+	return orNode{left, right}
+}
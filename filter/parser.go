@@ -0,0 +1,277 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// node is a parsed filter expression: and, or, not or a primitive such as "src port 53"
+type node interface{}
+
+type andNode struct {
+	left, right node
+}
+
+type orNode struct {
+	left, right node
+}
+
+type notNode struct {
+	operand node
+}
+
+const (
+	directionEither = "src or dst"
+	directionBoth   = "src and dst"
+	directionSrc    = "src"
+	directionDst    = "dst"
+)
+
+type primitive struct {
+	protocol  string // ether, ip, ip6, arp, tcp, udp... or empty when not qualified
+	direction string
+	kind      string // host, net, port, portrange, proto, vlan or empty for bare protocols such as "tcp"
+	value     string
+}
+
+func tokenize(expression string) []string {
+	var tokens []string
+	current := strings.Builder{}
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	runes := []rune(expression)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == '(' || r == ')' || r == '!':
+			flush()
+			tokens = append(tokens, string(r))
+		case (r == '&' || r == '|') && i+1 < len(runes) && runes[i+1] == r:
+			flush()
+			tokens = append(tokens, string([]rune{r, r}))
+			i++
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+	// tcpdump lets "host a or b" stand for "host a or host b", the qualifiers of the last primitive are kept for that
+	last *primitive
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	token := p.peek()
+	if token != "" {
+		p.pos++
+	}
+	return token
+}
+
+func (p *parser) expect(token string) error {
+	if got := p.next(); got != token {
+		if got == "" {
+			return fmt.Errorf("expected %q at the end of the expression", token)
+		}
+		return fmt.Errorf("expected %q, got %q", token, got)
+	}
+	return nil
+}
+
+// Expression is a parsed filter expression, a nil one matches every frame
+type Expression struct {
+	root node
+	text string
+}
+
+// Parse parses a tcpdump style filter expression, an empty one yields nil
+func Parse(expression string) (*Expression, error) {
+	root, err := parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter expression: %v", err)
+	}
+	if root == nil {
+		return nil, nil
+	}
+	return &Expression{root: root, text: expression}, nil
+}
+
+// And matches the frames both expressions match. Each one stays a subtree of its own, so that neither can reach into
+// the other however it's written
+func And(left *Expression, right *Expression) *Expression {
+	if left == nil {
+		return right
+	}
+	if right == nil {
+		return left
+	}
+	return &Expression{root: andNode{left.root, right.root}, text: fmt.Sprintf("(%s) and (%s)", left.text, right.text)}
+}
+
+// String returns the expression the way it was written
+func (e *Expression) String() string {
+	if e == nil {
+		return ""
+	}
+	return e.text
+}
+
+func parse(expression string) (node, error) {
+	p := parser{tokens: tokenize(expression)}
+	if len(p.tokens) == 0 {
+		return nil, nil
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := p.peek(); token != "" {
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	return n, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" || p.peek() == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" || p.peek() == "&&" {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	switch p.peek() {
+	case "not", "!":
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	case "(":
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case "":
+		return nil, fmt.Errorf("unexpected end of the expression")
+	}
+	return p.parsePrimitive()
+}
+
+var protocolKeywords = map[string]bool{
+	"ether": true, "ip": true, "ip6": true, "arp": true, "rarp": true,
+	"tcp": true, "udp": true, "sctp": true, "icmp": true, "icmp6": true,
+}
+
+var kindKeywords = map[string]bool{
+	"host": true, "net": true, "port": true, "portrange": true, "proto": true,
+}
+
+func (p *parser) parsePrimitive() (node, error) {
+	token := p.peek()
+	// A bare value continues the previous primitive, e.g. the second address in "host a or b"
+	if !protocolKeywords[token] && !kindKeywords[token] && token != "src" && token != "dst" && token != "vlan" {
+		if p.last == nil || p.last.kind == "" || p.last.kind == "vlan" {
+			return nil, fmt.Errorf("unexpected %q", token)
+		}
+		prim := *p.last
+		prim.value = p.next()
+		return prim, nil
+	}
+
+	prim := primitive{direction: directionEither}
+	if token == "vlan" {
+		p.next()
+		prim.kind = "vlan"
+		// The VLAN ID is optional
+		if value := p.peek(); value != "" && unicode.IsDigit(rune(value[0])) {
+			prim.value = p.next()
+		}
+		p.last = &prim
+		return prim, nil
+	}
+
+	if protocolKeywords[token] {
+		prim.protocol = p.next()
+	}
+	if token := p.peek(); token == "src" || token == "dst" {
+		prim.direction = p.next()
+		// "src or dst" and "src and dst" are qualifiers on their own, not boolean operators
+		if operator := p.peek(); (operator == "or" || operator == "and") && p.pos+1 < len(p.tokens) {
+			other := p.tokens[p.pos+1]
+			if other == "src" || other == "dst" {
+				if other == prim.direction {
+					return nil, fmt.Errorf("%q can't be combined with itself", other)
+				}
+				p.pos += 2
+				prim.direction = directionEither
+				if operator == "and" {
+					prim.direction = directionBoth
+				}
+			}
+		}
+	}
+	if kindKeywords[p.peek()] {
+		prim.kind = p.next()
+	} else if prim.direction != directionEither {
+		// "src 10.0.0.1" and "ether dst 00:11:22:33:44:55" are short for host primitives
+		prim.kind = "host"
+	}
+	if prim.kind != "" {
+		value := p.next()
+		if value == "" || value == "(" || value == ")" || value == "!" {
+			return nil, fmt.Errorf("%q needs a value", prim.kind)
+		}
+		prim.value = value
+	} else if prim.protocol == "" {
+		return nil, fmt.Errorf("expected one of host, net, port, portrange or proto, got %q", p.peek())
+	}
+	p.last = &prim
+	return prim, nil
+}
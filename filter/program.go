@@ -0,0 +1,228 @@
+package filter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Classic BPF opcodes, see linux/filter.h
+const (
+	bpfLD   uint16 = 0x00
+	bpfLDX  uint16 = 0x01
+	bpfST   uint16 = 0x02
+	bpfSTX  uint16 = 0x03
+	bpfALU  uint16 = 0x04
+	bpfJMP  uint16 = 0x05
+	bpfRET  uint16 = 0x06
+	bpfMISC uint16 = 0x07
+
+	bpfW uint16 = 0x00
+	bpfH uint16 = 0x08
+	bpfB uint16 = 0x10
+
+	bpfIMM uint16 = 0x00
+	bpfABS uint16 = 0x20
+	bpfIND uint16 = 0x40
+	bpfMEM uint16 = 0x60
+	bpfLEN uint16 = 0x80
+	bpfMSH uint16 = 0xa0
+
+	bpfADD uint16 = 0x00
+	bpfSUB uint16 = 0x10
+	bpfMUL uint16 = 0x20
+	bpfDIV uint16 = 0x30
+	bpfOR  uint16 = 0x40
+	bpfAND uint16 = 0x50
+	bpfLSH uint16 = 0x60
+	bpfRSH uint16 = 0x70
+	bpfNEG uint16 = 0x80
+	bpfMOD uint16 = 0x90
+	bpfXOR uint16 = 0xa0
+
+	bpfJA   uint16 = 0x00
+	bpfJEQ  uint16 = 0x10
+	bpfJGT  uint16 = 0x20
+	bpfJGE  uint16 = 0x30
+	bpfJSET uint16 = 0x40
+
+	bpfK uint16 = 0x00
+	bpfX uint16 = 0x08
+	bpfA uint16 = 0x10
+
+	bpfTAX uint16 = 0x00
+	bpfTXA uint16 = 0x80
+
+	// Loads past this offset read metadata the kernel keeps about the frame instead of the frame itself
+	ancillaryOffset         uint32 = 0xfffff000
+	ancillaryVLANTag               = ancillaryOffset + 44
+	ancillaryVLANTagPresent        = ancillaryOffset + 48
+
+	scratchMemorySize = 16
+)
+
+// Instruction has the layout of struct sock_filter, so that a Program can be handed over to the kernel as it is
+type Instruction struct {
+	Code uint16
+	Jt   uint8
+	Jf   uint8
+	K    uint32
+}
+
+type Program []Instruction
+
+func (p Program) String() string {
+	lines := make([]string, len(p))
+	for i, instruction := range p {
+		lines[i] = fmt.Sprintf("(%03d) { 0x%02x, %d, %d, 0x%08x }", i, instruction.Code, instruction.Jt, instruction.Jf, instruction.K)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func load(frame []byte, size uint16, offset uint32) (uint32, bool) {
+	if offset >= ancillaryOffset {
+		// Frames read from files carry their VLAN tags inline, so there is no metadata to look at
+		return 0, true
+	}
+	end := uint64(offset)
+	switch size {
+	case bpfW:
+		end += 4
+	case bpfH:
+		end += 2
+	case bpfB:
+		end += 1
+	}
+	if end > uint64(len(frame)) {
+		return 0, false
+	}
+	switch size {
+	case bpfW:
+		return binary.BigEndian.Uint32(frame[offset:]), true
+	case bpfH:
+		return uint32(binary.BigEndian.Uint16(frame[offset:])), true
+	}
+	return uint32(frame[offset]), true
+}
+
+// Match runs the program against a frame the way the kernel would, for frames that don't come from a socket. An empty
+// program matches every frame
+func (p Program) Match(frame []byte) bool {
+	if len(p) == 0 {
+		return true
+	}
+	var a, x uint32
+	var memory [scratchMemorySize]uint32
+	for pc := 0; pc < len(p); pc++ {
+		instruction := p[pc]
+		k := instruction.K
+		switch class := instruction.Code & 0x07; class {
+		case bpfLD, bpfLDX:
+			mode, size := instruction.Code&0xe0, instruction.Code&0x18
+			var value uint32
+			switch mode {
+			case bpfIMM:
+				value = k
+			case bpfLEN:
+				value = uint32(len(frame))
+			case bpfMEM:
+				value = memory[k%scratchMemorySize]
+			case bpfABS, bpfIND:
+				offset := k
+				if mode == bpfIND {
+					offset += x
+				}
+				loaded, ok := load(frame, size, offset)
+				if !ok {
+					return false
+				}
+				value = loaded
+			case bpfMSH:
+				if int(k) >= len(frame) {
+					return false
+				}
+				value = uint32(frame[k]&0x0f) * 4
+			}
+			if class == bpfLD {
+				a = value
+			} else {
+				x = value
+			}
+		case bpfST:
+			memory[k%scratchMemorySize] = a
+		case bpfSTX:
+			memory[k%scratchMemorySize] = x
+		case bpfALU:
+			operand := k
+			if instruction.Code&bpfX != 0 {
+				operand = x
+			}
+			switch instruction.Code & 0xf0 {
+			case bpfADD:
+				a += operand
+			case bpfSUB:
+				a -= operand
+			case bpfMUL:
+				a *= operand
+			case bpfDIV:
+				if operand == 0 {
+					return false
+				}
+				a /= operand
+			case bpfMOD:
+				if operand == 0 {
+					return false
+				}
+				a %= operand
+			case bpfOR:
+				a |= operand
+			case bpfAND:
+				a &= operand
+			case bpfXOR:
+				a ^= operand
+			case bpfLSH:
+				a <<= operand
+			case bpfRSH:
+				a >>= operand
+			case bpfNEG:
+				a = -a
+			}
+		case bpfJMP:
+			operand := k
+			if instruction.Code&bpfX != 0 {
+				operand = x
+			}
+			var taken bool
+			switch instruction.Code & 0xf0 {
+			case bpfJA:
+				pc += int(k)
+				continue
+			case bpfJEQ:
+				taken = a == operand
+			case bpfJGT:
+				taken = a > operand
+			case bpfJGE:
+				taken = a >= operand
+			case bpfJSET:
+				taken = a&operand != 0
+			}
+			if taken {
+				pc += int(instruction.Jt)
+			} else {
+				pc += int(instruction.Jf)
+			}
+		case bpfRET:
+			if instruction.Code&0x18 == bpfA {
+				return a != 0
+			}
+			return k != 0
+		case bpfMISC:
+			if instruction.Code&0xf8 == bpfTXA {
+				a = x
+			} else {
+				x = a
+			}
+		}
+	}
+	return false
+}
@@ -2,19 +2,67 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"net"
 	"os"
+	"os/signal"
 	"packet_sniffer/capture"
+	"packet_sniffer/filter"
 	units "packet_sniffer/model"
-	"packet_sniffer/parsing"
+	"packet_sniffer/pipeline"
+	"packet_sniffer/remote"
 	"packet_sniffer/render"
+	"packet_sniffer/replay"
 	"packet_sniffer/utils"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"unicode"
 )
 
+type options struct {
+	protocols    []units.Protocol
+	readFile     string
+	writeFile    string
+	backend      string
+	blockSize    int
+	blockCount   int
+	filter       *filter.Expression
+	promiscuous  bool
+	allMulticast bool
+	interfaces   []string
+	namespace    string // path of the network namespace the interfaces are in, the one of the process when empty
+	// Limits of the capture files, the files are only rotated if one of them is set
+	rotateSize     int64
+	rotateDuration time.Duration
+	rotatePackets  int
+	rotateKeep     int
+	limits         pipeline.Limits
+	stream         bool
+	fanout         int // sockets per interface
+	fanoutMode     capture.FanoutMode
+	workers        int
+	// Traffic of the synthetic backend
+	syntheticRate float64
+	syntheticSeed int64
+	syntheticMix  map[string]int
+	// Remote captures, the server listens on listen and the client connects to remote
+	listen      string
+	remote      string
+	tls         bool
+	tlsCert     string
+	tlsKey      string
+	tlsCA       string
+	tlsClientCA string // what serve mode verifies the certificates of its clients against
+}
+
 func isMakingThroughFilter(pdu *units.PDU, protocols []units.Protocol) bool {
 	currentPDU := pdu
 	for currentPDU != nil {
@@ -28,82 +76,721 @@ func isMakingThroughFilter(pdu *units.PDU, protocols []units.Protocol) bool {
 	return false
 }
 
-func terminal(protocols []units.Protocol) {
-	parser := parsing.CompositeParser{}
-	capturer := capture.UnixCapturer{}
+// openNetworkInterface starts capturing on a network interface with the selected backend, the socket joins the fanout
+// group unless the mode is capture.FanoutNone. A group of ID 0 is created and its ID set for the other sockets to join
+func openNetworkInterface(opts options, iface string, fanoutMode capture.FanoutMode, fanoutGroup *uint16, cooked bool) (capture.Capturer, error) {
+	switch opts.backend {
+	case "socket":
+		capturer := capture.UnixCapturer{
+			Filter:       opts.filter,
+			Promiscuous:  opts.promiscuous,
+			AllMulticast: opts.allMulticast,
+			FanoutMode:   fanoutMode,
+			FanoutGroup:  *fanoutGroup,
+			Cooked:       cooked,
+			Namespace:    opts.namespace,
+		}
+		if err := capturer.Init(iface); err != nil {
+			return nil, err
+		}
+		*fanoutGroup = capturer.FanoutGroup
+		return &capturer, nil
+	case "mmap":
+		capturer := capture.MmapCapturer{
+			BlockSize:    opts.blockSize,
+			BlockCount:   opts.blockCount,
+			Filter:       opts.filter,
+			Promiscuous:  opts.promiscuous,
+			AllMulticast: opts.allMulticast,
+			FanoutMode:   fanoutMode,
+			FanoutGroup:  *fanoutGroup,
+			Cooked:       cooked,
+			Namespace:    opts.namespace,
+		}
+		if err := capturer.Init(iface); err != nil {
+			return nil, err
+		}
+		*fanoutGroup = capturer.FanoutGroup
+		return &capturer, nil
+	}
+	return nil, fmt.Errorf("unknown capture backend %q", opts.backend)
+}
+
+// openNetworkInterfaces starts capturing on every one of the network interfaces, their frames are merged into a single
+// stream ordered by time. With fanout every interface gets a fanout group of sockets of its own. Interfaces of different
+// link types are all captured cooked, so that their frames fit in the same file. The captures follow the interfaces
+// through their changes, see capture.HotplugCapturer
+func openNetworkInterfaces(opts options, ifaces []string) (capture.Capturer, units.LinkType, error) {
+	linkType, cooked := units.LinkType(0), false
+	for i, iface := range ifaces {
+		ifaceLinkType, err := capture.InterfaceLinkType(iface, opts.namespace)
+		if err != nil {
+			return nil, 0, err
+		}
+		if i > 0 && ifaceLinkType != linkType {
+			cooked = true
+		}
+		linkType = ifaceLinkType
+	}
+	if cooked {
+		linkType = units.LinkTypeLinuxSLL2
+	}
+
+	multi := capture.MultiCapturer{}
+	for _, iface := range ifaces {
+		hotplug := capture.HotplugCapturer{
+			Interface: iface,
+			Namespace: opts.namespace,
+			Open: func() (capture.Capturer, error) {
+				// An interface that comes back under the same name may not be of the same kind
+				if ifaceLinkType, err := capture.InterfaceLinkType(iface, opts.namespace); err != nil {
+					return nil, err
+				} else if !cooked && ifaceLinkType != linkType {
+					return nil, fmt.Errorf("its frames are now of link type %s", units.LinkTypeStringMap[ifaceLinkType])
+				}
+				return openFanoutGroup(opts, iface, cooked)
+			},
+		}
+		if err := hotplug.Init(); err != nil {
+			multi.Close()
+			return nil, 0, err
+		}
+		if len(ifaces) == 1 {
+			return &hotplug, linkType, nil
+		}
+		multi.Capturers = append(multi.Capturers, &hotplug)
+	}
+	multi.Init()
+	return &multi, linkType, nil
+}
+
+// openFanoutGroup starts capturing on an interface with as many sockets as there are to be in its fanout group. The
+// first socket creates a new group, so that captures running at the same time each get every frame
+func openFanoutGroup(opts options, iface string, cooked bool) (capture.Capturer, error) {
+	var group uint16
+	if opts.fanout <= 1 {
+		return openNetworkInterface(opts, iface, capture.FanoutNone, &group, cooked)
+	}
+	multi := capture.MultiCapturer{}
+	for j := 0; j < opts.fanout; j++ {
+		capturer, err := openNetworkInterface(opts, iface, opts.fanoutMode, &group, cooked)
+		if err != nil {
+			multi.Close()
+			return nil, err
+		}
+		multi.Capturers = append(multi.Capturers, capturer)
+	}
+	multi.Init()
+	return &multi, nil
+}
+
+// networkInterfaces lists the interfaces of the namespace to pick from, starting with the one that stands for all of
+// them
+func networkInterfaces(namespace string) []utils.NetworkInterface {
+	ifaces := utils.GetNetworkInterfaces(namespace)
+	// The traffic of all of them is what any sees
+	any := utils.NetworkInterface{Name: capture.AnyInterface, Flags: net.FlagUp | net.FlagRunning}
+	for _, iface := range ifaces {
+		any.Counters = any.Counters.Add(iface.Counters)
+	}
+	return append([]utils.NetworkInterface{any}, ifaces...)
+}
 
-	networkInterface := make(chan string)
+// captureStats returns the statistics of capturers that keep track of them
+func captureStats(capturer capture.Capturer) (capture.Stats, bool) {
+	reporter, ok := capturer.(capture.StatsReporter)
+	if !ok {
+		return capture.Stats{}, false
+	}
+	stats, err := reporter.Stats()
+	if err != nil {
+		return capture.Stats{}, false
+	}
+	return stats, true
+}
+
+// showStats keeps the capture statistics in the terminal's title bar up to date until the context is done
+func showStats(ctx context.Context, capturer capture.Capturer, renderer *render.Terminal) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if stats, ok := captureStats(capturer); ok {
+				renderer.SetStatus(stats.String())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func openCaptureFile(path string, expression *filter.Expression) (capture.FileReader, error) {
+	reader, err := capture.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Frames are dissected starting from the header their link type stands for
+	linkType := reader.LinkType()
+	if _, hit := units.LinkTypeStringMap[linkType]; !hit {
+		reader.Close()
+		return nil, fmt.Errorf("%s: unsupported link type %d", path, linkType)
+	}
+	program, err := filter.Compile(expression, reader.SnapLen(), linkType)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	// Without a filter every frame matches, the reader is still useful for counting them
+	return &capture.FilteredReader{FileReader: reader, Filter: program}, nil
+}
+
+// createWriter creates the file that captured frames are written to, a series of them if rotation is enabled
+func createWriter(opts options, linkType units.LinkType, snapLen int) (capture.PacketWriter, error) {
+	if opts.rotateSize == 0 && opts.rotateDuration == 0 && opts.rotatePackets == 0 {
+		return capture.CreateFile(opts.writeFile, linkType, snapLen)
+	}
+	writer := capture.RotatingWriter{
+		Path:        opts.writeFile,
+		LinkType:    linkType,
+		SnapLen:     snapLen,
+		MaxSize:     opts.rotateSize,
+		MaxDuration: opts.rotateDuration,
+		MaxPackets:  opts.rotatePackets,
+		Keep:        opts.rotateKeep,
+	}
+	if err := writer.Init(); err != nil {
+		return nil, err
+	}
+	return &writer, nil
+}
+
+// runTerminal shows the frames of the capturer until the terminal is closed or the context is done. The capture is
+// stopped and every frame captured by then is written before it returns
+func runTerminal(ctx context.Context, capturer capture.Capturer, writer capture.PacketWriter, limits pipeline.Limits, workers int, renderer *render.Terminal) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go showStats(ctx, capturer, renderer)
+
+	p := pipeline.Pipeline{Capturer: capturer, Writer: writer, Handler: renderer.AddPDU, Limits: limits, Workers: workers}
+	err := p.Run(ctx)
+	if err != nil {
+		// The terminal would hide the error otherwise
+		renderer.Stop()
+	}
+	return err
+}
+
+// terminalWithoutSelection shows the sources there are no interfaces to select for: capture files, remote captures and
+// synthetic traffic
+func terminalWithoutSelection(ctx context.Context, opts options) error {
+	reader, linkType, snapLen, err := openSource(opts, nil)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	var writer capture.PacketWriter
+	if opts.writeFile != "" {
+		if writer, err = createWriter(opts, linkType, snapLen); err != nil {
+			return err
+		}
+	}
+
+	title := fmt.Sprintf("Capture file: %s", opts.readFile)
+	if opts.remote != "" {
+		title = fmt.Sprintf("Remote capture: %s", opts.remote)
+	} else if opts.readFile == "" {
+		title = fmt.Sprintf("Synthetic traffic, seed %d", opts.syntheticSeed)
+	}
+	renderer := render.Terminal{}
+	renderer.Open(title)
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- runTerminal(ctx, reader, writer, opts.limits, opts.workers, &renderer)
+	}()
+	go func() {
+		<-ctx.Done()
+		renderer.Stop()
+	}()
+	renderer.Run()
+	cancel()
+	return <-done
+}
+
+func terminal(ctx context.Context, opts options) error {
+	if hasNoInterfaces(opts) {
+		return terminalWithoutSelection(ctx, opts)
+	}
+	// Buffered so that the selection doesn't block the terminal if the capture is being stopped at the same time
+	networkInterface := make(chan []string, 1)
 
 	renderer := render.Terminal{NetworkInterface: networkInterface}
+	renderer.Init()
 
-	ifaces := utils.GetNetworkInterfaces()
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
 	go func() {
-		iface := <-networkInterface
+		var selected []string
+		select {
+		case selected = <-networkInterface:
+		case <-ctx.Done():
+			done <- nil
+			return
+		}
 
-		if err := capturer.Init(iface); err != nil {
-			log.Fatal(err)
+		capturer, linkType, err := openNetworkInterfaces(opts, selected)
+		if err != nil {
+			renderer.Stop()
+			done <- err
+			return
 		}
-		for {
-			raw, err := capturer.Capture()
-			if err != nil {
-				log.Fatal(err)
-				return
-			}
-			pdu, err := parser.Parse(raw)
-			err = renderer.AddPDU(pdu)
-			if err != nil {
-				log.Fatal(err)
+		defer capturer.Close()
+		// The link type of the file is only known once the interfaces are
+		var writer capture.PacketWriter
+		if opts.writeFile != "" {
+			if writer, err = createWriter(opts, linkType, capture.DefaultSnapLen); err != nil {
+				renderer.Stop()
+				done <- err
 				return
 			}
 		}
-
+		done <- runTerminal(ctx, capturer, writer, opts.limits, opts.workers, &renderer)
+	}()
+	go func() {
+		<-ctx.Done()
+		renderer.Stop()
 	}()
-	renderer.Start(ifaces)
+	renderer.Start(func() []utils.NetworkInterface {
+		return networkInterfaces(opts.namespace)
+	})
+	cancel()
+	return <-done
 }
 
-func stdout(protocols []units.Protocol) {
-	reader := bufio.NewReader(os.Stdout)
-
-	parser := parsing.CompositeParser{}
-	capturer := capture.UnixCapturer{}
-	fmt.Println("Select a network interface:")
-	ifaces := utils.GetNetworkInterfaces()
+// selectNetworkInterfaces asks for one or more of the network interfaces by their numbers
+func selectNetworkInterfaces(reader *bufio.Reader, namespace string) ([]string, error) {
+	fmt.Println("Select network interfaces, separate several numbers with commas:")
+	ifaces := networkInterfaces(namespace)
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "#\tInterface\tState\tMTU\tMAC\tRx packets\tTx packets\tAddresses")
 	for i, iface := range ifaces {
-		fmt.Printf("%d: %s\n", i+1, iface)
+		mtu := ""
+		if iface.MTU > 0 {
+			mtu = strconv.Itoa(iface.MTU)
+		}
+		fmt.Fprintf(table, "%d:\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n", i+1, iface.Name, iface.State(), mtu, iface.HardwareAddr,
+			iface.Counters.RxPackets, iface.Counters.TxPackets, strings.Join(iface.AddressStrings(), " "))
 	}
+	table.Flush()
 	fmt.Println()
 	choice, err := reader.ReadString('\n')
 	if err != nil {
-		fmt.Println(err)
-		return
+		return nil, err
+	}
+	var selected []string
+	for _, field := range strings.FieldsFunc(choice, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		option, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		if option > len(ifaces) || option < 1 {
+			return nil, fmt.Errorf("there is no network interface number %d", option)
+		}
+		selected = append(selected, ifaces[option-1].Name)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no network interface selected")
+	}
+	return selected, nil
+}
+
+// openSynthetic starts generating traffic, the filter is applied to it the way it would be to a capture file
+func openSynthetic(opts options) (capture.FileReader, error) {
+	capturer := capture.SyntheticCapturer{Seed: opts.syntheticSeed, Rate: opts.syntheticRate, Mix: opts.syntheticMix}
+	if err := capturer.Init(); err != nil {
+		return nil, err
 	}
-	choice = strings.TrimSpace(choice)
-	option, err := strconv.Atoi(choice)
+	program, err := filter.Compile(opts.filter, capturer.SnapLen(), capturer.LinkType())
 	if err != nil {
-		fmt.Println(err)
-		return
+		return nil, err
 	}
-	if option > len(ifaces) && option < 1 {
-		return
+	return &capture.FilteredReader{FileReader: &capturer, Filter: program}, nil
+}
+
+// parseSyntheticMix parses weights of synthetic traffic such as "tcp=10,dns=2", the kinds left out aren't generated
+func parseSyntheticMix(value string) (map[string]int, error) {
+	mix := make(map[string]int)
+	for _, field := range strings.Split(value, ",") {
+		kind, weight, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			return nil, fmt.Errorf("invalid weight %q, expected kind=weight", field)
+		}
+		parsed, err := strconv.Atoi(weight)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid weight %q for %s", weight, kind)
+		}
+		mix[kind] = parsed
 	}
-	capturer.Init(ifaces[option-1])
+	return mix, nil
+}
 
-	for {
-		buf, _ := capturer.Capture()
-		pdu, _ := parser.Parse(buf)
-		if len(protocols) > 0 && isMakingThroughFilter(pdu, protocols) {
+// openRemote connects to a capture server, which applies the filter
+func openRemote(opts options) (capture.FileReader, error) {
+	client := remote.Client{Address: opts.remote, Filter: opts.filter.String()}
+	if opts.tls || opts.tlsCA != "" || opts.tlsCert != "" {
+		config, err := remote.ClientTLS(opts.tlsCA, opts.tlsCert, opts.tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		client.TLS = config
+	}
+	if err := client.Init(); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// hasNoInterfaces tells whether the frames come from a source other than the network interfaces of this host
+func hasNoInterfaces(opts options) bool {
+	return opts.readFile != "" || opts.backend == "synthetic" || opts.remote != ""
+}
+
+// openSource opens the capture file, the synthetic traffic, the remote capture or the network interfaces to capture
+// on. The interfaces are asked for on the reader if they weren't given, an error is returned instead without a reader
+func openSource(opts options, reader *bufio.Reader) (capture.Capturer, units.LinkType, int, error) {
+	if hasNoInterfaces(opts) {
+		var fileReader capture.FileReader
+		var err error
+		switch {
+		case opts.readFile != "":
+			fileReader, err = openCaptureFile(opts.readFile, opts.filter)
+		case opts.remote != "":
+			fileReader, err = openRemote(opts)
+		default:
+			fileReader, err = openSynthetic(opts)
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return fileReader, fileReader.LinkType(), fileReader.SnapLen(), nil
+	}
+	ifaces := opts.interfaces
+	if len(ifaces) == 0 {
+		if reader == nil {
+			return nil, 0, 0, errors.New("no network interface to capture on, see -interface")
+		}
+		var err error
+		if ifaces, err = selectNetworkInterfaces(reader, opts.namespace); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	capturer, linkType, err := openNetworkInterfaces(opts, ifaces)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return capturer, linkType, capture.DefaultSnapLen, nil
+}
+
+// stdout prints the frames one at a time, waiting for enter in between unless streaming
+func stdout(ctx context.Context, opts options) error {
+	reader := bufio.NewReader(os.Stdin)
+
+	capturer, linkType, snapLen, err := openSource(opts, reader)
+	if err != nil {
+		return err
+	}
+	defer capturer.Close()
+	var writer capture.PacketWriter
+	if opts.writeFile != "" {
+		if writer, err = createWriter(opts, linkType, snapLen); err != nil {
+			return err
+		}
+	}
+
+	// Reading stdin can't be interrupted, so it's done on the side to be able to stop while waiting for enter
+	enter := make(chan struct{})
+	go func() {
+		if opts.stream {
+			return
+		}
+		for {
+			if _, err := reader.ReadString('\n'); err != nil {
+				close(enter)
+				return
+			}
+			enter <- struct{}{}
+		}
+	}()
+
+	var displayFiltered atomic.Uint64
+	p := pipeline.Pipeline{
+		Capturer: capturer,
+		Writer:   writer,
+		Limits:   opts.limits,
+		Workers:  opts.workers,
+		Handler: func(pdu *units.PDU) error {
+			if len(opts.protocols) > 0 && !isMakingThroughFilter(pdu, opts.protocols) {
+				displayFiltered.Add(1)
+				return nil
+			}
+			if opts.stream {
+				utils.PDUSummaryPrint(pdu)
+				return nil
+			}
+			// Once stopping, the frames left in the pipeline only go to the file
+			if ctx.Err() != nil {
+				return nil
+			}
 			utils.PDUPrettyPrint(pdu)
 			fmt.Println("Press enter to get the next PDU...")
-			reader.ReadString('\n')
+			select {
+			case <-enter:
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	}
+	err = p.Run(ctx)
+	if err == nil && ctx.Err() == nil && opts.readFile != "" && opts.limits == (pipeline.Limits{}) {
+		fmt.Println("End of the capture file")
+	}
+	if stats, ok := captureStats(capturer); ok {
+		fmt.Printf("Capture statistics: %s\n", stats)
+	}
+	// The capturer saw them through, the display filter is what hid them
+	if len(opts.protocols) > 0 {
+		fmt.Printf("Hidden by the display filter: %d\n", displayFiltered.Load())
+	}
+	return err
+}
+
+// headless writes the captured frames to the capture file without showing them until the capture is stopped, which
+// suits long running captures
+func headless(ctx context.Context, opts options) error {
+	if opts.writeFile == "" {
+		return errors.New("headless mode needs a file to write to, see -write")
+	}
+	capturer, linkType, snapLen, err := openSource(opts, nil)
+	if err != nil {
+		return err
+	}
+	defer capturer.Close()
+	writer, err := createWriter(opts, linkType, snapLen)
+	if err != nil {
+		return err
+	}
+
+	p := pipeline.Pipeline{Capturer: capturer, Writer: writer, Limits: opts.limits}
+	err = p.Run(ctx)
+	if stats, ok := captureStats(capturer); ok {
+		fmt.Printf("Capture statistics: %s\n", stats)
+	}
+	return err
+}
+
+// serve captures for the clients that connect, each one gets a capture of its own with the filter it asks for
+// and'ed with the one given here
+func serve(ctx context.Context, opts options) error {
+	if opts.remote != "" {
+		return errors.New("serve mode can't relay a remote capture")
+	}
+	if !hasNoInterfaces(opts) && len(opts.interfaces) == 0 {
+		return errors.New("serve mode needs network interfaces to capture on, see -interface")
+	}
+	// Anyone who can connect gets to watch the traffic, only clients that prove who they are may come from elsewhere
+	if opts.tlsClientCA == "" && !remote.IsLoopback(opts.listen) {
+		return fmt.Errorf("serve mode only listens on %s with -tls-client-ca, for clients to be authenticated", opts.listen)
+	}
+	if opts.tlsClientCA != "" && opts.tlsCert == "" {
+		return errors.New("-tls-client-ca needs -tls-cert and -tls-key")
+	}
+	var config *tls.Config
+	if opts.tlsCert != "" || opts.tlsKey != "" {
+		var err error
+		if config, err = remote.ServerTLS(opts.tlsCert, opts.tlsKey, opts.tlsClientCA); err != nil {
+			return err
 		}
 	}
+	listener, err := remote.Listen(opts.listen, config)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving captures on %s\n", listener.Addr())
+
+	server := remote.Server{
+		Listener: listener,
+		Open: func(expression string) (capture.Capturer, units.LinkType, int, error) {
+			// The client's expression is parsed on its own, it can only narrow down what the server's one lets through
+			clientFilter, err := filter.Parse(expression)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			clientOpts := opts
+			clientOpts.filter = filter.And(opts.filter, clientFilter)
+			return openSource(clientOpts, nil)
+		},
+		Logf: func(format string, args ...any) {
+			fmt.Printf(format+"\n", args...)
+		},
+	}
+	return server.Serve(ctx)
+}
+
+// signalContext returns a context that is done once the process is asked to stop
+func signalContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	go func() {
+		// Stopping may take a while if there is a lot left to write, a second signal kills the process right away
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx
+}
+
+// replayCommand sends the frames of a capture file out of a network interface, see "replay -h"
+func replayCommand(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s replay -interface <name> [flags] <capture file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	iface := flags.String("interface", "", "Network interface to send the frames out of")
+	speed := flags.Float64("speed", 1, "Multiplier of the captured timing, 2 replays twice as fast")
+	pps := flags.Float64("pps", 0, "Send this many frames per second instead of following the captured timing")
+	topSpeed := flags.Bool("topspeed", false, "Send the frames as fast as possible")
+	loops := flags.Int("loop", 1, "Number of times the file is replayed, forever when 0")
+	flags.Parse(args)
+	if *iface == "" || flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	injector := capture.UnixInjector{}
+	if err := injector.Init(*iface); err != nil {
+		return err
+	}
+	defer injector.Close()
+	replayer := replay.Replayer{
+		Path:     flags.Arg(0),
+		Injector: &injector,
+		Speed:    *speed,
+		PPS:      *pps,
+		TopSpeed: *topSpeed,
+		Loops:    *loops,
+	}
+	if *loops == 0 {
+		replayer.Loops = -1
+	}
+	stats, err := replayer.Run(signalContext())
+	fmt.Printf("Replay statistics: %s\n", stats)
+	return err
 }
 
 func main() {
-	mode := flag.String("mode", "stdout", "Select the packet sniffer's mode")
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replayCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	mode := flag.String("mode", "stdout", "Select the packet sniffer's mode: stdout, terminal, headless (write to -write only) or serve (stream to -remote clients)")
 	protocols := flag.String("protocols", "", "Comma separated list of protocol names")
+	readFile := flag.String("read", "", "Read frames from a pcap or pcapng file instead of a network interface")
+	backend := flag.String("backend", "socket", "Capture backend for network interfaces: socket (one syscall per frame) or mmap (TPACKET_V3 ring buffer). synthetic generates made-up traffic instead, without privileges")
+	blockSize := flag.Int("block-size", capture.DefaultBlockSize, "Size of a ring buffer block in bytes for the mmap backend, a multiple of the page size")
+	blockCount := flag.Int("block-count", capture.DefaultBlockCount, "Number of ring buffer blocks for the mmap backend")
+	writeFile := flag.String("write", "", "Write every captured frame to a capture file, pcapng if it has the .pcapng extension and pcap otherwise")
+	promiscuous := flag.Bool("promisc", false, "Put the network interface into promiscuous mode to see frames addressed to other hosts, e.g. on a mirror port. The previous mode is restored on exit")
+	allMulticast := flag.Bool("allmulti", false, "Receive frames sent to every multicast group, not only the joined ones")
+	interfaces := flag.String("interface", "", "Comma separated list of network interfaces to capture on, \"any\" for all of them. Asked for when not set")
+	rotateSize := flag.String("rotate-size", "", "Start a new capture file once the current one reaches this size, e.g. 100M. Files are named after -write with a sequence number and a timestamp")
+	rotateDuration := flag.Duration("rotate-duration", 0, "Start a new capture file once the current one has been written to for this long, e.g. 1h")
+	rotatePackets := flag.Int("rotate-packets", 0, "Start a new capture file once the current one holds this many frames")
+	rotateKeep := flag.Int("rotate-keep", 0, "Keep only this many of the newest capture files when rotating, all of them when 0")
+	count := flag.Int("c", 0, "Stop after this many frames")
+	duration := flag.Duration("duration", 0, "Stop after capturing for this long, e.g. 30s")
+	maxBytes := flag.String("bytes", "", "Stop before the captured frames add up to more than this size, e.g. 10M")
+	maxFileSize := flag.String("filesize", "", "Stop once this much has been written to -write, e.g. 1G")
+	stream := flag.Bool("stream", false, "Print a line per frame as they arrive instead of waiting for enter in stdout mode")
+	fanout := flag.Int("fanout", 1, "Number of sockets per network interface, the kernel spreads the frames over them with PACKET_FANOUT")
+	fanoutMode := flag.String("fanout-mode", "hash", "How frames are spread over the -fanout sockets: hash (by flow), lb (round robin) or cpu")
+	workers := flag.Int("workers", 1, "Number of goroutines parsing frames, every frame of a flow is parsed by the same one")
+	syntheticRate := flag.Float64("synthetic-rate", capture.DefaultSyntheticRate, "Frames generated per second by the synthetic backend, as many as can be shown when 0")
+	syntheticSeed := flag.Int64("synthetic-seed", 1, "Seed of the synthetic backend, the same seed generates the same frames")
+	syntheticMix := flag.String("synthetic-mix", "", "Comma separated weights of the traffic generated by the synthetic backend, e.g. \"tcp=10,dns=4,arp=1\". Kinds: "+strings.Join(capture.SyntheticKinds, ", "))
+	netns := flag.String("netns", "", "Network namespace of the interfaces to capture on, e.g. a container's: the PID of a process in it, a name given by ip netns or the path of its file")
+	listen := flag.String("listen", "127.0.0.1:"+remote.DefaultPort, "Address serve mode listens for remote clients on, one that isn't a loopback address needs -tls-client-ca")
+	remoteAddress := flag.String("remote", "", "Show the frames captured by a packet sniffer in serve mode at this address, port "+remote.DefaultPort+" unless given. The filter is applied by the server")
+	useTLS := flag.Bool("tls", false, "Connect to -remote over TLS, the server is verified against the system's certificate authorities unless -tls-ca is set")
+	tlsCert := flag.String("tls-cert", "", "PEM encoded certificate serve mode presents to its clients, which then have to use TLS, or -remote presents to the server. Implies -tls with -remote")
+	tlsKey := flag.String("tls-key", "", "PEM encoded private key of -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "PEM encoded certificates serve mode verifies the certificates of its clients against, clients without one are turned away. Needs -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "PEM encoded certificates -remote is verified against, e.g. the server's self-signed one. Implies -tls")
+	filterExpression := flag.String("filter", "", "Capture filter expression in the tcpdump syntax, e.g. \"tcp port 443 and not host 10.0.0.1\". Arguments left after the flags are used when not set")
 	flag.Parse()
 
+	modes := map[string]func(context.Context, options) error{
+		"stdout":   stdout,
+		"terminal": terminal,
+		"headless": headless,
+		"serve":    serve,
+	}
+	run, ok := modes[*mode]
+	if !ok {
+		fmt.Printf("unknown mode %q\n", *mode)
+		os.Exit(2)
+	}
+	if *filterExpression == "" {
+		*filterExpression = strings.Join(flag.Args(), " ")
+	}
+	expression, err := filter.Parse(*filterExpression)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	// The expression is compiled for every source once its link type is known, this only catches mistakes early
+	if _, err = filter.Compile(expression, capture.DefaultSnapLen, units.LinkTypeEthernet); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	sizes := make(map[string]int64)
+	for name, size := range map[string]string{"rotate-size": *rotateSize, "bytes": *maxBytes, "filesize": *maxFileSize} {
+		if size == "" {
+			continue
+		}
+		if sizes[name], err = utils.ParseSize(size); err != nil {
+			fmt.Printf("-%s: %v\n", name, err)
+			os.Exit(2)
+		}
+	}
+	fanoutModeValue, ok := capture.FanoutModes[*fanoutMode]
+	if !ok {
+		fmt.Printf("unknown fanout mode %q\n", *fanoutMode)
+		os.Exit(2)
+	}
+	var mix map[string]int
+	if *syntheticMix != "" {
+		if mix, err = parseSyntheticMix(*syntheticMix); err != nil {
+			fmt.Printf("-synthetic-mix: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	var namespace string
+	if *netns != "" {
+		if namespace, err = utils.NetworkNamespacePath(*netns); err != nil {
+			fmt.Printf("-netns: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	var ifaces []string
+	if *interfaces != "" {
+		ifaces = strings.Split(*interfaces, ",")
+	}
+
 	protocolsToFilter := make([]units.Protocol, 0)
 	if protocols != nil {
 		for _, protocol := range strings.Split(*protocols, ",") {
@@ -114,9 +801,45 @@ func main() {
 			}
 		}
 	}
-	if *mode == "stdout" {
-		stdout(protocolsToFilter)
-	} else if *mode == "terminal" {
-		terminal(protocolsToFilter)
+	opts := options{
+		protocols:      protocolsToFilter,
+		readFile:       *readFile,
+		writeFile:      *writeFile,
+		backend:        *backend,
+		blockSize:      *blockSize,
+		blockCount:     *blockCount,
+		filter:         expression,
+		promiscuous:    *promiscuous,
+		allMulticast:   *allMulticast,
+		interfaces:     ifaces,
+		namespace:      namespace,
+		rotateSize:     sizes["rotate-size"],
+		rotateDuration: *rotateDuration,
+		rotatePackets:  *rotatePackets,
+		rotateKeep:     *rotateKeep,
+		limits: pipeline.Limits{
+			Packets:  *count,
+			Bytes:    sizes["bytes"],
+			Duration: *duration,
+			FileSize: sizes["filesize"],
+		},
+		stream:        *stream,
+		fanout:        *fanout,
+		fanoutMode:    fanoutModeValue,
+		workers:       *workers,
+		syntheticRate: *syntheticRate,
+		syntheticSeed: *syntheticSeed,
+		syntheticMix:  mix,
+		listen:        *listen,
+		remote:        *remoteAddress,
+		tls:           *useTLS,
+		tlsCert:       *tlsCert,
+		tlsKey:        *tlsKey,
+		tlsCA:         *tlsCA,
+		tlsClientCA:   *tlsClientCA,
+	}
+	if err = run(signalContext(), opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
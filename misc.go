@@ -1,20 +0,0 @@
-package main
-
-import "fmt"
-
-var ip4frame = []byte{
-	0x4C, 0x5E, 0x0C, 0x1E, 0x27, 0x68, 0xF4, 0x3B, 0xD8, 0x8A, 0xBA, 0x06, 0x08, 0x00, 0x45, 0x00,
-	0x00, 0x77, 0x88, 0xC1, 0x40, 0x00, 0x40, 0x06, 0x1C, 0xB3, 0x0A, 0x01, 0x02, 0x87, 0x68, 0x12,
-	0x20, 0x73, 0xE4, 0xA2, 0x01, 0xBB, 0x77, 0x4F, 0x70, 0xC2, 0x1D, 0x60, 0x32, 0x67, 0x80, 0x18,
-	0x01, 0xC9, 0x95, 0x76, 0x00, 0x00, 0x01, 0x01, 0x08, 0x0A, 0x4D, 0xAF, 0xAA, 0xE9, 0x0A, 0x71,
-	0xE0, 0x66, 0x17, 0x03, 0x03, 0x00, 0x3E, 0x7F, 0x38, 0x35, 0x01, 0xE6, 0xA1, 0x79, 0x70, 0x4B,
-	0x95, 0x6C, 0x60, 0x19, 0xFD, 0x94, 0x2A, 0x0A, 0xF8, 0x7E, 0x64, 0x31, 0x2B, 0xB8, 0x6B, 0x51,
-	0x1D, 0xAF, 0x03, 0x43, 0xAA, 0x87, 0x40, 0x69, 0x08, 0x2A, 0x6B, 0x3A, 0x24, 0x11, 0xE4, 0x67,
-	0xB9, 0x4B, 0x71, 0xC0, 0x2B, 0x6D, 0x6C, 0x7B, 0x6F, 0x7A, 0x3C, 0xB5, 0x05, 0x43, 0x4D, 0x94,
-	0x13, 0xE7, 0x2E, 0x0C, 0x5B,
-}
-
-func main() {
-	bit := 0b10110101
-	fmt.Printf("%08b\n", bit>>2)
-}
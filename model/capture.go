@@ -0,0 +1,62 @@
+package units
+
+import "time"
+
+// LinkType identifies the link-layer header a captured frame starts with, using the LINKTYPE_* values
+// registered by tcpdump.org for capture files
+type LinkType uint16
+
+const (
+	LinkTypeNull      LinkType = 0
+	LinkTypeEthernet  LinkType = 1
+	LinkTypeRaw       LinkType = 101
+	LinkTypeLinuxSLL  LinkType = 113
+	LinkTypeIPv4      LinkType = 228
+	LinkTypeIPv6      LinkType = 229
+	LinkTypeLinuxSLL2 LinkType = 276
+)
+
+var LinkTypeStringMap = map[LinkType]string{
+	LinkTypeNull:      "BSD loopback",
+	LinkTypeEthernet:  "Ethernet",
+	LinkTypeRaw:       "Raw IP",
+	LinkTypeLinuxSLL:  "Linux cooked capture",
+	LinkTypeIPv4:      "Raw IPv4",
+	LinkTypeIPv6:      "Raw IPv6",
+	LinkTypeLinuxSLL2: "Linux cooked capture v2",
+}
+
+// PacketType tells who a frame was meant for, following the PACKET_* values of sockaddr_ll shifted by one so that the
+// zero value stands for frames whose type isn't known, such as those read from pcap files
+type PacketType uint8
+
+const (
+	PacketTypeUnknown PacketType = iota
+	PacketTypeHost
+	PacketTypeBroadcast
+	PacketTypeMulticast
+	PacketTypeOtherHost
+	PacketTypeOutgoing
+)
+
+var PacketTypeStringMap = map[PacketType]string{
+	PacketTypeUnknown:   "Unknown",
+	PacketTypeHost:      "Inbound",
+	PacketTypeBroadcast: "Broadcast",
+	PacketTypeMulticast: "Multicast",
+	PacketTypeOtherHost: "Other host",
+	PacketTypeOutgoing:  "Outbound",
+}
+
+type CaptureInfo struct {
+	Timestamp     time.Time
+	CaptureLength int // number of bytes actually captured
+	Length        int // length of the frame on the wire
+	LinkType      LinkType
+	PacketType    PacketType
+
+	// InterfaceIndex is the kernel's interface index for live captures and the interface ID for pcapng files
+	InterfaceIndex int
+	InterfaceName  string
+	Comments       []string
+}
@@ -13,6 +13,11 @@ const (
 	UDP
 	VLAN_TAGGED
 	LINK_LAYER_DISCOVERY
+	LINUX_SLL
+	LINUX_SLL2
+	NULL_LOOPBACK
+	DNS
+	ICMPv6
 )
 
 type ProtocolName struct {
@@ -30,6 +35,11 @@ var ProtocolStringMap = map[Protocol]ProtocolName{
 	UDP:                  {"UDP", "User Datagram Protocol"},
 	VLAN_TAGGED:          {"VLAN", "Virtual Local Area Network"},
 	LINK_LAYER_DISCOVERY: {"LLDP", "Link Layer Discovery Protocol"},
+	LINUX_SLL:            {"SLL", "Linux cooked capture"},
+	LINUX_SLL2:           {"SLL2", "Linux cooked capture v2"},
+	NULL_LOOPBACK:        {"Null", "BSD loopback"},
+	DNS:                  {"DNS", "Domain Name System"},
+	ICMPv6:               {"ICMPv6", "Internet Control Message Protocol for IPv6"},
 }
 
 type PDUHeaderKey uint8
@@ -42,4 +52,9 @@ type PDU struct {
 	NextPDU *PDU
 	PrevPDU *PDU
 	Payload []byte
+	// Message is the whole of what the PDU was dissected from, for protocols whose headers point back into it
+	Message []byte
+
+	// CaptureInfo describes the frame the PDU was dissected from, only the outermost PDU carries it
+	CaptureInfo *CaptureInfo
 }
@@ -89,6 +89,9 @@ var arpHTypes = map[uint16]string{
 type ArpParser struct{}
 
 func (p ArpParser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < 8 {
+		return nil, fmt.Errorf("truncated ARP header: %d bytes", len(buf))
+	}
 	h := make(map[units.PDUHeaderKey]units.Header, 9)
 	h[hardwareTypeArp] = buf[0:2]
 	h[protocolTypeArp] = buf[2:4]
@@ -96,8 +99,11 @@ func (p ArpParser) Parse(buf []byte) (*units.PDU, error) {
 	h[protocolLengthArp] = buf[5:6]
 	h[operationArp] = buf[6:8]
 
-	hardwareAddressLength := int8(h[hardwareLengthArp][0])
-	protocolLength := int8(h[protocolLengthArp][0])
+	hardwareAddressLength := int(h[hardwareLengthArp][0])
+	protocolLength := int(h[protocolLengthArp][0])
+	if length := 8 + 2*hardwareAddressLength + 2*protocolLength; length > len(buf) {
+		return nil, fmt.Errorf("truncated ARP packet: %d of %d bytes", len(buf), length)
+	}
 	lastIndex := 8 + hardwareAddressLength
 
 	h[senderHardwareAddressArp] = buf[8:lastIndex]
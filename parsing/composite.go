@@ -1,23 +1,62 @@
 package parsing
 
 import (
+	"fmt"
 	units "packet_sniffer/model"
 )
 
 type CompositeParser struct{}
 
-func (p *CompositeParser) Parse(initialFrame []byte) (*units.PDU, error) {
+var linkTypeProtocols = map[units.LinkType]units.Protocol{
+	units.LinkTypeNull:      units.NULL_LOOPBACK,
+	units.LinkTypeEthernet:  units.ETHERNET,
+	units.LinkTypeLinuxSLL:  units.LINUX_SLL,
+	units.LinkTypeLinuxSLL2: units.LINUX_SLL2,
+	units.LinkTypeIPv4:      units.IPv4,
+	units.LinkTypeIPv6:      units.IPv6,
+}
+
+// RootProtocol returns the protocol a frame of the given link type starts with
+func RootProtocol(linkType units.LinkType, frame []byte) units.Protocol {
+	if linkType == units.LinkTypeRaw {
+		// Raw IP frames are told apart by their version
+		if len(frame) == 0 {
+			return units.UNKNOWN
+		}
+		switch frame[0] >> 4 {
+		case 4:
+			return units.IPv4
+		case 6:
+			return units.IPv6
+		}
+		return units.UNKNOWN
+	}
+	if protocol, hit := linkTypeProtocols[linkType]; hit {
+		return protocol
+	}
+	return units.UNKNOWN
+}
+
+func (p *CompositeParser) Parse(initialFrame []byte, info units.CaptureInfo) (*units.PDU, error) {
 
-	protocol := units.ETHERNET
+	protocol := RootProtocol(info.LinkType, initialFrame)
+	if ParserFromProtocol(protocol) == nil {
+		return nil, fmt.Errorf("no dissector for frames of link type %d", info.LinkType)
+	}
 	currentBuf := initialFrame
 	var PDUS []units.PDU
 
-	for protocol != units.UNKNOWN {
-		parser := ParserFromProtocol(protocol)
+	// Dissection stops at the first protocol there is no parser for
+	for parser := ParserFromProtocol(protocol); parser != nil; parser = ParserFromProtocol(protocol) {
 		pdu, err := parser.Parse(currentBuf)
-		if err != nil {
+		if err != nil && len(PDUS) == 0 {
 			return nil, err
 		}
+		if err != nil {
+			// A payload that doesn't make sense to the next parser, e.g. of another protocol on a well-known port,
+			// stays the payload of the layer that carried it
+			break
+		}
 		currentBuf = pdu.Payload
 		PDUS = append(PDUS, *pdu)
 		protocol = parser.GetNextProtocol(pdu)
@@ -27,5 +66,6 @@ func (p *CompositeParser) Parse(initialFrame []byte) (*units.PDU, error) {
 		PDUS[i].NextPDU, PDUS[i+1].PrevPDU = &PDUS[i+1], &PDUS[i]
 	}
 
+	PDUS[0].CaptureInfo = &info
 	return &PDUS[0], nil
 }
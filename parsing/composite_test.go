@@ -0,0 +1,67 @@
+package parsing
+
+import (
+	units "packet_sniffer/model"
+	"testing"
+)
+
+var truncationFrames = map[string][]byte{
+	"TCP": {
+		0x4c, 0x5e, 0x0c, 0x1e, 0x27, 0x68, 0xf4, 0x3b, 0xd8, 0x8a, 0xba, 0x06, 0x08, 0x00, 0x45, 0x00,
+		0x00, 0x3c, 0x88, 0xc1, 0x40, 0x00, 0x40, 0x06, 0x1c, 0xee, 0x0a, 0x01, 0x02, 0x87, 0x68, 0x12,
+		0x20, 0x73, 0xe4, 0xa2, 0x01, 0xbb, 0x77, 0x4f, 0x70, 0xc2, 0x1d, 0x60, 0x32, 0x67, 0xa0, 0x12,
+		0x01, 0xc9, 0x95, 0x76, 0x00, 0x00, 0x02, 0x04, 0x05, 0xb4, 0x04, 0x02, 0x08, 0x0a, 0x4d, 0xaf,
+		0xaa, 0xe9, 0x0a, 0x71, 0xe0, 0x66, 0x01, 0x03, 0x03, 0x07,
+	},
+	"ARP": {
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x06, 0x00, 0x01,
+		0x08, 0x00, 0x06, 0x04, 0x00, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x02,
+	},
+	"ICMP timestamp": {
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00, 0x45, 0x00,
+		0x00, 0x28, 0x00, 0x01, 0x00, 0x00, 0x40, 0x01, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00,
+		0x00, 0x02, 0x0d, 0x00, 0x00, 0x00, 0x12, 0x34, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	},
+	"VLAN DNS": {
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x81, 0x00, 0xa0, 0x64,
+		0x08, 0x00, 0x45, 0x00, 0x00, 0x39, 0x00, 0x01, 0x00, 0x00, 0x40, 0x11, 0x00, 0x00, 0x0a, 0x00,
+		0x00, 0x01, 0x0a, 0x00, 0x00, 0x02, 0x9c, 0x40, 0x00, 0x35, 0x00, 0x25, 0x00, 0x00, 0x12, 0x34,
+		0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 'e', 'x', 'a', 'm', 'p',
+		'l', 'e', 0x03, 'c', 'o', 'm', 0x00, 0x00, 0x01, 0x00, 0x01,
+	},
+	"IPv6 ICMPv6": {
+		0x33, 0x33, 0x00, 0x00, 0x00, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x86, 0xdd, 0x60, 0x00,
+		0x00, 0x00, 0x00, 0x10, 0x3a, 0xff, 0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x85, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01,
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x01,
+	},
+}
+
+// Every prefix of a frame either parses or fails, none makes a dissector run past the end of it
+func TestCompositeParserTruncatedFrames(t *testing.T) {
+	for name, frame := range truncationFrames {
+		for length := 0; length <= len(frame); length++ {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s cut at %d bytes: %v", name, length, r)
+					}
+				}()
+				parser := CompositeParser{}
+				pdu, err := parser.Parse(frame[:length], units.CaptureInfo{LinkType: units.LinkTypeEthernet})
+				if err != nil {
+					if length == len(frame) {
+						t.Errorf("%s: %v", name, err)
+					}
+					return
+				}
+				for layer := pdu; layer != nil; layer = layer.NextPDU {
+					ParserFromProtocol(layer.Protocol).PDUBreakdown(layer)
+				}
+			}()
+		}
+	}
+}
@@ -0,0 +1,482 @@
+package parsing
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	units "packet_sniffer/model"
+	"strconv"
+	"strings"
+)
+
+type DNSParser struct{}
+
+const dnsHeaderLength = 12
+
+const (
+	idDNS units.PDUHeaderKey = iota + 2
+	flagsDNS
+	questionCountDNS
+	answerCountDNS
+	authorityCountDNS
+	additionalCountDNS
+	queriesDNS
+	answersDNS
+	authoritiesDNS
+	additionalsDNS
+)
+
+var dnsHeaderNames = map[units.PDUHeaderKey]string{
+	idDNS:              "ID",
+	flagsDNS:           "Flags",
+	questionCountDNS:   "Questions",
+	answerCountDNS:     "Answer RRs",
+	authorityCountDNS:  "Authority RRs",
+	additionalCountDNS: "Additional RRs",
+	queriesDNS:         "Queries",
+	answersDNS:         "Answers",
+	authoritiesDNS:     "Authorities",
+	additionalsDNS:     "Additionals",
+}
+
+// dnsSectionCounts maps the sections to the header that counts their entries
+var dnsSectionCounts = map[units.PDUHeaderKey]units.PDUHeaderKey{
+	queriesDNS:     questionCountDNS,
+	answersDNS:     answerCountDNS,
+	authoritiesDNS: authorityCountDNS,
+	additionalsDNS: additionalCountDNS,
+}
+
+var dnsTypeNames = map[uint16]string{
+	1:   "A",
+	2:   "NS",
+	5:   "CNAME",
+	6:   "SOA",
+	12:  "PTR",
+	13:  "HINFO",
+	15:  "MX",
+	16:  "TXT",
+	28:  "AAAA",
+	33:  "SRV",
+	35:  "NAPTR",
+	41:  "OPT",
+	43:  "DS",
+	46:  "RRSIG",
+	47:  "NSEC",
+	48:  "DNSKEY",
+	50:  "NSEC3",
+	64:  "SVCB",
+	65:  "HTTPS",
+	255: "ANY",
+	257: "CAA",
+}
+
+var dnsOpcodeNames = map[byte]string{
+	0: "Standard query",
+	1: "Inverse query",
+	2: "Server status request",
+	4: "Notify",
+	5: "Update",
+}
+
+var dnsRcodeNames = map[uint16]string{
+	0: "No error",
+	1: "Format error",
+	2: "Server failure",
+	3: "No such name",
+	4: "Not implemented",
+	5: "Refused",
+}
+
+const (
+	dnsTypeA     = 1
+	dnsTypeNS    = 2
+	dnsTypeCNAME = 5
+	dnsTypeSOA   = 6
+	dnsTypePTR   = 12
+	dnsTypeMX    = 15
+	dnsTypeTXT   = 16
+	dnsTypeAAAA  = 28
+	dnsTypeSRV   = 33
+	dnsTypeOPT   = 41
+)
+
+const (
+	// dnsMaxNameLength is the limit of RFC 1035 on the length of names, labels and their length octets included
+	dnsMaxNameLength = 255
+	// dnsMaxPointers is more than enough for a name of a single character labels to be spread over the message
+	dnsMaxPointers = 126
+)
+
+var errDNSNameLoop = errors.New("compression pointer that doesn't point back in the message")
+
+// dnsRecord is an entry of any section, a question has no TTL nor data
+type dnsRecord struct {
+	name       string
+	recordType uint16
+	class      uint16
+	ttl        uint32
+	data       []byte
+	dataOffset int // of the data in the message, names in it may point back into the message
+}
+
+// Parse splits the message into its sections, the records are only decoded when shown. Names in them may point
+// anywhere back into the message, so the PDU keeps all of it
+func (p DNSParser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < dnsHeaderLength {
+		return nil, fmt.Errorf("truncated DNS header: %d bytes", len(buf))
+	}
+	h := make(map[units.PDUHeaderKey]units.Header, 10)
+	h[idDNS] = buf[0:2]
+	h[flagsDNS] = buf[2:4]
+	h[questionCountDNS] = buf[4:6]
+	h[answerCountDNS] = buf[6:8]
+	h[authorityCountDNS] = buf[8:10]
+	h[additionalCountDNS] = buf[10:12]
+
+	offset := dnsHeaderLength
+	for _, section := range []units.PDUHeaderKey{queriesDNS, answersDNS, authoritiesDNS, additionalsDNS} {
+		count := int(binary.BigEndian.Uint16(h[dnsSectionCounts[section]]))
+		if count == 0 {
+			continue
+		}
+		start := offset
+		for i := 0; i < count; i++ {
+			var err error
+			if _, offset, err = readDNSRecord(buf, offset, section == queriesDNS); err != nil {
+				return nil, fmt.Errorf("malformed DNS message: %v", err)
+			}
+		}
+		h[section] = buf[start:offset]
+	}
+
+	return &units.PDU{
+		Headers:  h,
+		Payload:  nil,
+		Message:  buf,
+		Protocol: units.DNS,
+	}, nil
+}
+
+// readDNSName decodes the name at the offset of the message and returns the offset following it, compressed names
+// end with a pointer to the rest of the name elsewhere in the message
+func readDNSName(message []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	// Pointers have to go back in the message, to where the name they're part of didn't start yet
+	limit := offset
+	nameLength := 1 // the root label
+	for jumps := 0; ; {
+		if offset >= len(message) {
+			return "", 0, fmt.Errorf("name past the end of the message")
+		}
+		length := int(message[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			if len(labels) == 0 {
+				return ".", next, nil
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(message) {
+				return "", 0, fmt.Errorf("truncated compression pointer")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			target := int(binary.BigEndian.Uint16(message[offset:offset+2]) & 0x3fff)
+			if jumps++; jumps > dnsMaxPointers || target >= limit {
+				return "", 0, errDNSNameLoop
+			}
+			offset, limit = target, target
+		case length&0xc0 != 0:
+			return "", 0, fmt.Errorf("unknown label type 0x%02x", length&0xc0)
+		default:
+			if offset+1+length > len(message) {
+				return "", 0, fmt.Errorf("label past the end of the message")
+			}
+			if nameLength += 1 + length; nameLength > dnsMaxNameLength {
+				return "", 0, fmt.Errorf("name longer than %d octets", dnsMaxNameLength)
+			}
+			labels = append(labels, string(message[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// readDNSRecord decodes the record at the offset of the message and returns the offset following it
+func readDNSRecord(message []byte, offset int, question bool) (dnsRecord, int, error) {
+	var record dnsRecord
+	var err error
+	if record.name, offset, err = readDNSName(message, offset); err != nil {
+		return record, 0, err
+	}
+	if offset+4 > len(message) {
+		return record, 0, fmt.Errorf("truncated record")
+	}
+	record.recordType = binary.BigEndian.Uint16(message[offset : offset+2])
+	record.class = binary.BigEndian.Uint16(message[offset+2 : offset+4])
+	offset += 4
+	if question {
+		return record, offset, nil
+	}
+	if offset+6 > len(message) {
+		return record, 0, fmt.Errorf("truncated record")
+	}
+	record.ttl = binary.BigEndian.Uint32(message[offset : offset+4])
+	length := int(binary.BigEndian.Uint16(message[offset+4 : offset+6]))
+	offset += 6
+	if offset+length > len(message) {
+		return record, 0, fmt.Errorf("record data past the end of the message")
+	}
+	record.data, record.dataOffset = message[offset:offset+length], offset
+	return record, offset + length, nil
+}
+
+// sectionRecords decodes the records of a section of the message, which starts where the sections before it end
+func (p DNSParser) sectionRecords(section units.PDUHeaderKey, pdu *units.PDU) []dnsRecord {
+	offset := dnsHeaderLength
+	for _, current := range []units.PDUHeaderKey{queriesDNS, answersDNS, authoritiesDNS, additionalsDNS} {
+		count := int(binary.BigEndian.Uint16(pdu.Headers[dnsSectionCounts[current]]))
+		records := make([]dnsRecord, 0, count)
+		for i := 0; i < count; i++ {
+			record, next, err := readDNSRecord(pdu.Message, offset, current == queriesDNS)
+			if err != nil {
+				break
+			}
+			records = append(records, record)
+			offset = next
+		}
+		if current == section {
+			return records
+		}
+	}
+	return nil
+}
+
+func dnsTypeName(recordType uint16) string {
+	if name, hit := dnsTypeNames[recordType]; hit {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", recordType)
+}
+
+func dnsClassName(class uint16) string {
+	// Multicast DNS uses the top bit to ask for a unicast response or to flush caches
+	switch class & 0x7fff {
+	case 1:
+		return "IN"
+	case 3:
+		return "CH"
+	case 4:
+		return "HS"
+	case 255:
+		return "ANY"
+	}
+	return fmt.Sprintf("CLASS%d", class)
+}
+
+// recordData formats the data of a record the way it appears in a zone file
+func recordData(message []byte, record dnsRecord) string {
+	data := record.data
+	end := record.dataOffset + len(data)
+	// Names may point anywhere back in the message, but have to end within the data of their record
+	readName := func(offset int) (string, int, error) {
+		name, next, err := readDNSName(message, offset)
+		if err == nil && next > end {
+			err = fmt.Errorf("name past the end of the record data")
+		}
+		return name, next, err
+	}
+	name := func(offset int) string {
+		name, _, err := readName(record.dataOffset + offset)
+		if err != nil {
+			return fmt.Sprintf("<%v>", err)
+		}
+		return name
+	}
+	switch record.recordType {
+	case dnsTypeA:
+		if len(data) == net.IPv4len {
+			return net.IP(data).String()
+		}
+	case dnsTypeAAAA:
+		if len(data) == net.IPv6len {
+			return net.IP(data).String()
+		}
+	case dnsTypeNS, dnsTypeCNAME, dnsTypePTR:
+		return name(0)
+	case dnsTypeMX:
+		if len(data) > 2 {
+			return fmt.Sprintf("%d %s", binary.BigEndian.Uint16(data), name(2))
+		}
+	case dnsTypeSRV:
+		if len(data) > 6 {
+			return fmt.Sprintf("%d %d %d %s", binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:]),
+				binary.BigEndian.Uint16(data[4:]), name(6))
+		}
+	case dnsTypeSOA:
+		primary, next, err := readName(record.dataOffset)
+		if err != nil {
+			break
+		}
+		mailbox, next, err := readName(next)
+		if err != nil || next+20 > end {
+			break
+		}
+		return fmt.Sprintf("%s %s %d", primary, mailbox, binary.BigEndian.Uint32(message[next:next+4]))
+	case dnsTypeTXT:
+		var texts []string
+		for len(data) > 0 && int(data[0]) < len(data) {
+			texts = append(texts, strconv.Quote(string(data[1:1+data[0]])))
+			data = data[1+data[0]:]
+		}
+		return strings.Join(texts, " ")
+	}
+	return fmt.Sprintf("%x", record.data)
+}
+
+// describe shows the record the way dig does, a question without TTL nor data
+func (r dnsRecord) describe(message []byte, question bool) string {
+	if question {
+		return fmt.Sprintf("%s %s %s", r.name, dnsClassName(r.class), dnsTypeName(r.recordType))
+	}
+	if r.recordType == dnsTypeOPT {
+		// EDNS puts the UDP payload size the sender can take in the class
+		return fmt.Sprintf("OPT UDP payload size %d", r.class)
+	}
+	return fmt.Sprintf("%s %d %s %s %s", r.name, r.ttl, dnsClassName(r.class), dnsTypeName(r.recordType),
+		recordData(message, r))
+}
+
+func (p DNSParser) flagsDescription(header units.Header) string {
+	flags := binary.BigEndian.Uint16(header)
+	opcode := byte(flags>>11) & 0x0f
+	kind, hit := dnsOpcodeNames[opcode]
+	if !hit {
+		kind = fmt.Sprintf("Opcode %d", opcode)
+	}
+	if flags&0x8000 == 0 {
+		return kind
+	}
+	rcode := flags & 0x000f
+	result, hit := dnsRcodeNames[rcode]
+	if !hit {
+		result = fmt.Sprintf("Rcode %d", rcode)
+	}
+	return fmt.Sprintf("%s response, %s", kind, result)
+}
+
+func (p DNSParser) HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu *units.PDU) string {
+	header := pdu.Headers[headerKey]
+	switch headerKey {
+	case idDNS:
+		return fmt.Sprintf("0x%04x", binary.BigEndian.Uint16(header))
+	case flagsDNS:
+		return p.flagsDescription(header)
+	case questionCountDNS, answerCountDNS, authorityCountDNS, additionalCountDNS:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint16(header)), 10)
+	case queriesDNS, answersDNS, authoritiesDNS, additionalsDNS:
+		message := pdu.Message
+		var values []string
+		for _, record := range p.sectionRecords(headerKey, pdu) {
+			values = append(values, record.describe(message, headerKey == queriesDNS))
+		}
+		return strings.Join(values, ", ")
+	}
+	return ""
+}
+
+func (p DNSParser) GetNextProtocol(pdu *units.PDU) units.Protocol {
+	return units.UNKNOWN
+}
+
+func (p DNSParser) MostSignificantHeaders(pdu *units.PDU) []units.PDUHeaderKey {
+	headers := []units.PDUHeaderKey{idDNS, flagsDNS, queriesDNS}
+	if _, hit := pdu.Headers[answersDNS]; hit {
+		headers = append(headers, answersDNS)
+	}
+	return headers
+}
+
+func (p DNSParser) HeaderName(header units.PDUHeaderKey) string {
+	return dnsHeaderNames[header]
+}
+
+func (p DNSParser) flagsBreakdown(pdu *units.PDU) PDUBreakdownOutput {
+	h := pdu.Headers[flagsDNS]
+	flags := binary.BigEndian.Uint16(h)
+	desc := p.flagsDescription(h)
+	bit := func(mask uint16) string {
+		if flags&mask != 0 {
+			return isFlagSet[1]
+		}
+		return isFlagSet[0]
+	}
+	return PDUBreakdownOutput{
+		KeyName:     dnsHeaderNames[flagsDNS],
+		Value:       fmt.Sprintf("0x%04x", flags),
+		Header:      &h,
+		Description: &desc,
+		InnerBreakdowns: []PDUBreakdownOutput{
+			{KeyName: "Response", Value: bit(0x8000)},
+			{KeyName: "Opcode", Value: strconv.Itoa(int(flags>>11) & 0x0f)},
+			{KeyName: "Authoritative", Value: bit(0x0400)},
+			{KeyName: "Truncated", Value: bit(0x0200)},
+			{KeyName: "Recursion desired", Value: bit(0x0100)},
+			{KeyName: "Recursion available", Value: bit(0x0080)},
+			{KeyName: "Authentic data", Value: bit(0x0020)},
+			{KeyName: "Checking disabled", Value: bit(0x0010)},
+			{KeyName: "Rcode", Value: strconv.Itoa(int(flags & 0x000f))},
+		},
+	}
+}
+
+func (p DNSParser) countBreakdown(headerKey units.PDUHeaderKey, pdu *units.PDU) PDUBreakdownOutput {
+	h := pdu.Headers[headerKey]
+	return PDUBreakdownOutput{
+		KeyName: dnsHeaderNames[headerKey],
+		Value:   p.HeaderToHumanReadable(headerKey, pdu),
+		Header:  &h,
+	}
+}
+
+func (p DNSParser) sectionBreakdown(section units.PDUHeaderKey, pdu *units.PDU) PDUBreakdownOutput {
+	h := pdu.Headers[section]
+	records := p.sectionRecords(section, pdu)
+	output := PDUBreakdownOutput{
+		KeyName: dnsHeaderNames[section],
+		Value:   fmt.Sprintf("%d", len(records)),
+		Header:  &h,
+	}
+	message := pdu.Message
+	for _, record := range records {
+		output.InnerBreakdowns = append(output.InnerBreakdowns, PDUBreakdownOutput{
+			KeyName: dnsTypeName(record.recordType),
+			Value:   record.describe(message, section == queriesDNS),
+		})
+	}
+	return output
+}
+
+func (p DNSParser) PDUBreakdown(pdu *units.PDU) []PDUBreakdownOutput {
+	id := pdu.Headers[idDNS]
+	bdo := []PDUBreakdownOutput{
+		{KeyName: dnsHeaderNames[idDNS], Value: p.HeaderToHumanReadable(idDNS, pdu), Header: &id},
+		p.flagsBreakdown(pdu),
+		p.countBreakdown(questionCountDNS, pdu),
+		p.countBreakdown(answerCountDNS, pdu),
+		p.countBreakdown(authorityCountDNS, pdu),
+		p.countBreakdown(additionalCountDNS, pdu),
+	}
+	for _, section := range []units.PDUHeaderKey{queriesDNS, answersDNS, authoritiesDNS, additionalsDNS} {
+		if _, hit := pdu.Headers[section]; hit {
+			bdo = append(bdo, p.sectionBreakdown(section, pdu))
+		}
+	}
+	return bdo
+}
@@ -0,0 +1,80 @@
+package parsing
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadDNSName(t *testing.T) {
+	// example.com at 0, www pointing to it at 13
+	compressed := []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 3, 'w', 'w', 'w', 0xc0, 0}
+	long := bytes.Repeat(append([]byte{63}, bytes.Repeat([]byte{'a'}, 63)...), 4)
+
+	tests := []struct {
+		name    string
+		message []byte
+		offset  int
+		want    string
+		next    int
+		wantErr bool
+	}{
+		{name: "root", message: []byte{0}, want: ".", next: 1},
+		{name: "plain", message: compressed, want: "example.com", next: 13},
+		{name: "compressed", message: compressed, offset: 13, want: "www.example.com", next: 19},
+		{name: "pointer to itself", message: []byte{0xc0, 0}, wantErr: true},
+		{name: "forward pointer", message: []byte{0xc0, 2, 1, 'a', 0}, wantErr: true},
+		{name: "pointer loop", message: []byte{1, 'a', 0xc0, 0}, wantErr: true},
+		{name: "too long", message: append(long, 0), wantErr: true},
+		{name: "label past the end", message: []byte{3, 'a'}, wantErr: true},
+		{name: "truncated pointer", message: []byte{0xc0}, wantErr: true},
+	}
+	for _, test := range tests {
+		name, next, err := readDNSName(test.message, test.offset)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %q, want an error", test.name, name)
+			}
+			continue
+		}
+		if err != nil || name != test.want || next != test.next {
+			t.Errorf("%s: got %q, %d, %v, want %q, %d", test.name, name, next, err, test.want, test.next)
+		}
+	}
+}
+
+// A chain of pointers each going back by two bytes used to be followed for as long as the message allowed
+func TestReadDNSNamePointerChain(t *testing.T) {
+	message := []byte{1, 'a'}
+	for i := 0; i < 8000; i++ {
+		message = append(message, 1, 'a')
+	}
+	for offset := 2; offset < len(message); offset += 2 {
+		message[offset], message[offset+1] = 0xc0|byte((offset-2)>>8), byte(offset-2)
+	}
+	if _, _, err := readDNSName(message, len(message)-2); err == nil {
+		t.Error("got a name, want an error")
+	}
+}
+
+func TestDNSRecordNamesStayInTheirData(t *testing.T) {
+	message := []byte{
+		0x12, 0x34, 0x81, 0x80, 0x00, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00,
+		// example.com MX
+		7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0x00, 0x0f, 0x00, 0x01,
+		// 10 mail.example.com
+		0xc0, 0x0c, 0x00, 0x0f, 0x00, 0x01, 0x00, 0x00, 0x0e, 0x10, 0x00, 0x09,
+		0x00, 0x0a, 4, 'm', 'a', 'i', 'l', 0xc0, 0x0c,
+		// 20 and a name that goes on past the 4 bytes of data into what follows the record
+		0xc0, 0x0c, 0x00, 0x0f, 0x00, 0x01, 0x00, 0x00, 0x0e, 0x10, 0x00, 0x04,
+		0x00, 0x14, 4, 'm', 'a', 'i', 'l', 0,
+	}
+	pdu, err := DNSParser{}.Parse(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "example.com 3600 IN MX 10 mail.example.com, " +
+		"example.com 3600 IN MX 20 <name past the end of the record data>"
+	if answers := (DNSParser{}).HeaderToHumanReadable(answersDNS, pdu); answers != want {
+		t.Errorf("got %q, want %q", answers, want)
+	}
+}
@@ -24,9 +24,17 @@ var etherHeaderNames = map[units.PDUHeaderKey]string{
 var etherTypeMap = map[int]units.Protocol{
 	0x0800: units.IPv4,
 	0x0806: units.ARP,
+	0x86dd: units.IPv6,
+	0x8100: units.VLAN_TAGGED,
+	0x88a8: units.VLAN_TAGGED,
 }
 
+const ethernetHeaderLength = 14
+
 func (p EthernetParser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < ethernetHeaderLength {
+		return nil, fmt.Errorf("truncated Ethernet header: %d bytes", len(buf))
+	}
 	h := make(map[units.PDUHeaderKey]units.Header, 3)
 	h[dstMac] = buf[:6]
 	h[srcMac] = buf[6:12]
@@ -34,7 +42,7 @@ func (p EthernetParser) Parse(buf []byte) (*units.PDU, error) {
 
 	return &units.PDU{
 		Headers:  h,
-		Payload:  buf[14:],
+		Payload:  buf[ethernetHeaderLength:],
 		Protocol: units.ETHERNET,
 	}, nil
 }
@@ -45,20 +53,29 @@ func (p EthernetParser) HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu
 	case dstMac, srcMac:
 		return formatMac(header)
 	case etherType:
-		var protocolShortenedName string
-		protocol := p.ProtocolFromEtherType(header)
-		if protocol == units.UNKNOWN {
-			return fmt.Sprintf("Unknown Ethernet Protocol %04x", binary.BigEndian.Uint16(header))
-		}
-		protocolName, hit := units.ProtocolStringMap[p.ProtocolFromEtherType(header)]
-		if hit == true {
-			protocolShortenedName = protocolName.Shortened
-		}
-		return protocolShortenedName
+		return p.etherTypeName(header)
 	}
 	return ""
 }
 
+// etherTypeName names the protocol of an EtherType, the one of VLAN tags included
+func (p EthernetParser) etherTypeName(header []byte) string {
+	var protocolShortenedName string
+	protocol := p.ProtocolFromEtherType(header)
+	if protocol == units.UNKNOWN {
+		return fmt.Sprintf("Unknown Ethernet Protocol %04x", binary.BigEndian.Uint16(header))
+	}
+	protocolName, hit := units.ProtocolStringMap[protocol]
+	if hit == true {
+		protocolShortenedName = protocolName.Shortened
+	}
+	if binary.BigEndian.Uint16(header) == 0x88a8 {
+		// Service tags of 802.1ad come first in stacked tags
+		protocolShortenedName += " (802.1ad)"
+	}
+	return protocolShortenedName
+}
+
 func (p EthernetParser) ProtocolFromEtherType(ethTypeHeader []byte) units.Protocol {
 	v, hit := etherTypeMap[int(binary.BigEndian.Uint16(ethTypeHeader))]
 	if hit == true {
@@ -54,7 +54,27 @@ var icmpMessageTypes = map[byte]string{
 	14: "Timestamp Reply",
 }
 
+// icmpMinimumLengths are the lengths of the messages whose fields are looked at, the others need the type, code and
+// checksum only
+var icmpMinimumLengths = map[byte]int{
+	0:  8,
+	3:  8,
+	4:  8,
+	5:  8,
+	8:  8,
+	11: 8,
+	12: 8,
+	13: 20,
+	14: 20,
+}
+
 func (p ICMPParser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("truncated ICMP header: %d bytes", len(buf))
+	}
+	if length, hit := icmpMinimumLengths[buf[0]]; hit && len(buf) < length {
+		return nil, fmt.Errorf("truncated ICMP %s: %d of %d bytes", icmpMessageTypes[buf[0]], len(buf), length)
+	}
 	h := make(map[units.PDUHeaderKey]units.Header, 10) //TODO allocate precise amount of memory
 	h[typeICMP] = buf[0:1]
 	h[codeICMP] = buf[1:2]
@@ -114,9 +134,18 @@ func (p ICMPParser) HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu *uni
 	case sequenceNumberICMP:
 		return fmt.Sprintf("0x%02x", h)
 	case originalTimestampICMP, receiveTimestampICMP, transmitTimestampICMP:
-		timestamp := binary.BigEndian.Uint16(h)
-		t := time.Unix(int64(timestamp)*1000, 0)
-		return t.String()
+		// Milliseconds since midnight UTC, a host that can't tell sets the top bit and puts whatever time it has
+		timestamp := binary.BigEndian.Uint32(h)
+		if timestamp&0x80000000 != 0 || timestamp >= 24*60*60*1000 {
+			return fmt.Sprintf("%d (non-standard)", timestamp)
+		}
+		since := time.Duration(timestamp) * time.Millisecond
+		return time.Time{}.Add(since).Format("15:04:05.000") + " UTC"
+	case pointerICMP:
+		// Offset of the octet of the invoking datagram where the problem was found
+		return strconv.FormatUint(uint64(h[0]), 10)
+	case datagramPart:
+		return fmt.Sprintf("%d bytes", len(h))
 	}
 	return ""
 }
@@ -157,7 +186,7 @@ func (p ICMPParser) PDUBreakdownAsEchoMessage(pdu *units.PDU) []PDUBreakdownOutp
 }
 
 func (p ICMPParser) PDUBreakdownAsEchoTimestampMessage(pdu *units.PDU) []PDUBreakdownOutput {
-	bdo := make([]PDUBreakdownOutput, 6)
+	bdo := make([]PDUBreakdownOutput, 8)
 	bdo[0] = p.breakdownMessage(typeICMP, pdu)
 
 	bdo[1] = p.breakdownMessage(codeICMP, pdu)
@@ -171,7 +200,14 @@ func (p ICMPParser) PDUBreakdownAsEchoTimestampMessage(pdu *units.PDU) []PDUBrea
 }
 
 func (p ICMPParser) PDUBreakdownAsParametersError(pdu *units.PDU) []PDUBreakdownOutput {
+	bdo := make([]PDUBreakdownOutput, 5)
+	bdo[0] = p.breakdownMessage(typeICMP, pdu)
 
+	bdo[1] = p.breakdownMessage(codeICMP, pdu)
+	bdo[2] = p.breakdownMessage(checksumICMP, pdu)
+	bdo[3] = p.breakdownMessage(pointerICMP, pdu)
+	bdo[4] = p.breakdownMessage(datagramPart, pdu)
+	return bdo
 }
 
 func (p ICMPParser) PDUBreakdown(pdu *units.PDU) []PDUBreakdownOutput {
@@ -182,7 +218,7 @@ func (p ICMPParser) PDUBreakdown(pdu *units.PDU) []PDUBreakdownOutput {
 	case 13, 14:
 		return p.PDUBreakdownAsEchoTimestampMessage(pdu)
 	case 12:
-
+		return p.PDUBreakdownAsParametersError(pdu)
 	}
 	return []PDUBreakdownOutput{}
 }
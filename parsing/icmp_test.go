@@ -0,0 +1,28 @@
+package parsing
+
+import (
+	units "packet_sniffer/model"
+	"testing"
+)
+
+func TestICMPTimestamps(t *testing.T) {
+	reply := []byte{
+		14, 0, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01,
+		0x03, 0x37, 0xf9, 0x8d, // 15:00:00.013
+		0x80, 0x00, 0x00, 0x01, // not since midnight
+		0x00, 0x00, 0x00, 0x00,
+	}
+	pdu, err := ICMPParser{}.Parse(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[units.PDUHeaderKey]string{
+		originalTimestampICMP: "15:00:00.013 UTC",
+		receiveTimestampICMP:  "2147483649 (non-standard)",
+		transmitTimestampICMP: "00:00:00.000 UTC",
+	} {
+		if got := (ICMPParser{}).HeaderToHumanReadable(key, pdu); got != want {
+			t.Errorf("%s: got %q, want %q", icmpHeaderNames[key], got, want)
+		}
+	}
+}
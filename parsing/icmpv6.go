@@ -0,0 +1,640 @@
+package parsing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	units "packet_sniffer/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ICMPv6Parser struct{}
+
+const icmpv6HeaderLength = 8
+
+const (
+	typeICMPv6 units.PDUHeaderKey = iota + 2
+	codeICMPv6
+	checksumICMPv6
+	identifierICMPv6
+	sequenceNumberICMPv6
+	dataICMPv6
+	unusedICMPv6
+	mtuICMPv6
+	pointerICMPv6
+	invokingPacketICMPv6
+	curHopLimitICMPv6
+	routerFlagsICMPv6
+	routerLifetimeICMPv6
+	reachableTimeICMPv6
+	retransTimerICMPv6
+	neighborFlagsICMPv6
+	targetAddressICMPv6
+	destinationAddressICMPv6
+	ndpOptionsICMPv6
+	maxResponseDelayICMPv6
+	multicastAddressICMPv6
+	queryFlagsICMPv6
+	queryIntervalICMPv6
+	sourcesICMPv6
+	recordCountICMPv6
+	recordsICMPv6
+)
+
+var icmpv6HeaderNames = map[units.PDUHeaderKey]string{
+	typeICMPv6:               "Type",
+	codeICMPv6:               "Code",
+	checksumICMPv6:           "Checksum",
+	identifierICMPv6:         "Identifier",
+	sequenceNumberICMPv6:     "Sequence Number",
+	dataICMPv6:               "Data",
+	unusedICMPv6:             "Unused",
+	mtuICMPv6:                "MTU",
+	pointerICMPv6:            "Pointer",
+	invokingPacketICMPv6:     "Invoking Packet",
+	curHopLimitICMPv6:        "Cur Hop Limit",
+	routerFlagsICMPv6:        "Flags",
+	routerLifetimeICMPv6:     "Router Lifetime",
+	reachableTimeICMPv6:      "Reachable Time",
+	retransTimerICMPv6:       "Retrans Timer",
+	neighborFlagsICMPv6:      "Flags",
+	targetAddressICMPv6:      "Target Address",
+	destinationAddressICMPv6: "Destination Address",
+	ndpOptionsICMPv6:         "Options",
+	maxResponseDelayICMPv6:   "Maximum Response Delay",
+	multicastAddressICMPv6:   "Multicast Address",
+	queryFlagsICMPv6:         "Flags",
+	queryIntervalICMPv6:      "Querier's Query Interval",
+	sourcesICMPv6:            "Sources",
+	recordCountICMPv6:        "Number of Records",
+	recordsICMPv6:            "Records",
+}
+
+const (
+	icmpv6DestinationUnreachable = 1
+	icmpv6PacketTooBig           = 2
+	icmpv6TimeExceeded           = 3
+	icmpv6ParameterProblem       = 4
+	icmpv6EchoRequest            = 128
+	icmpv6EchoReply              = 129
+	icmpv6MLDQuery               = 130
+	icmpv6MLDReport              = 131
+	icmpv6MLDDone                = 132
+	icmpv6RouterSolicitation     = 133
+	icmpv6RouterAdvertisement    = 134
+	icmpv6NeighborSolicitation   = 135
+	icmpv6NeighborAdvertisement  = 136
+	icmpv6Redirect               = 137
+	icmpv6MLDv2Report            = 143
+)
+
+var icmpv6MessageTypes = map[byte]string{
+	icmpv6DestinationUnreachable: "Destination Unreachable",
+	icmpv6PacketTooBig:           "Packet Too Big",
+	icmpv6TimeExceeded:           "Time Exceeded",
+	icmpv6ParameterProblem:       "Parameter Problem",
+	icmpv6EchoRequest:            "Echo Request",
+	icmpv6EchoReply:              "Echo Reply",
+	icmpv6MLDQuery:               "Multicast Listener Query",
+	icmpv6MLDReport:              "Multicast Listener Report",
+	icmpv6MLDDone:                "Multicast Listener Done",
+	icmpv6RouterSolicitation:     "Router Solicitation",
+	icmpv6RouterAdvertisement:    "Router Advertisement",
+	icmpv6NeighborSolicitation:   "Neighbor Solicitation",
+	icmpv6NeighborAdvertisement:  "Neighbor Advertisement",
+	icmpv6Redirect:               "Redirect",
+	138:                          "Router Renumbering",
+	139:                          "Node Information Query",
+	140:                          "Node Information Response",
+	141:                          "Inverse Neighbor Discovery Solicitation",
+	142:                          "Inverse Neighbor Discovery Advertisement",
+	icmpv6MLDv2Report:            "Multicast Listener Report v2",
+	151:                          "Multicast Router Advertisement",
+	152:                          "Multicast Router Solicitation",
+	153:                          "Multicast Router Termination",
+}
+
+var icmpv6CodeNames = map[byte]map[byte]string{
+	icmpv6DestinationUnreachable: {
+		0: "No route to destination",
+		1: "Administratively prohibited",
+		2: "Beyond scope of source address",
+		3: "Address unreachable",
+		4: "Port unreachable",
+		5: "Source address failed ingress/egress policy",
+		6: "Reject route to destination",
+		7: "Error in Source Routing Header",
+	},
+	icmpv6TimeExceeded: {
+		0: "Hop limit exceeded in transit",
+		1: "Fragment reassembly time exceeded",
+	},
+	icmpv6ParameterProblem: {
+		0: "Erroneous header field",
+		1: "Unrecognized Next Header type",
+		2: "Unrecognized IPv6 option",
+	},
+}
+
+// icmpv6MinimumLengths are the lengths of the fixed parts of the messages, the ones not listed have the generic header
+// only
+var icmpv6MinimumLengths = map[byte]int{
+	icmpv6MLDQuery:              24,
+	icmpv6MLDReport:             24,
+	icmpv6MLDDone:               24,
+	icmpv6RouterAdvertisement:   16,
+	icmpv6NeighborSolicitation:  24,
+	icmpv6NeighborAdvertisement: 24,
+	icmpv6Redirect:              40,
+}
+
+const (
+	ndpOptionSourceLinkLayerAddress = 1
+	ndpOptionTargetLinkLayerAddress = 2
+	ndpOptionPrefixInformation      = 3
+	ndpOptionRedirectedHeader       = 4
+	ndpOptionMTU                    = 5
+	ndpOptionNonce                  = 14
+	ndpOptionRouteInformation       = 24
+	ndpOptionRDNSS                  = 25
+	ndpOptionDNSSL                  = 31
+)
+
+var ndpOptionNames = map[byte]string{
+	ndpOptionSourceLinkLayerAddress: "Source Link-Layer Address",
+	ndpOptionTargetLinkLayerAddress: "Target Link-Layer Address",
+	ndpOptionPrefixInformation:      "Prefix Information",
+	ndpOptionRedirectedHeader:       "Redirected Header",
+	ndpOptionMTU:                    "MTU",
+	ndpOptionNonce:                  "Nonce",
+	ndpOptionRouteInformation:       "Route Information",
+	ndpOptionRDNSS:                  "Recursive DNS Server",
+	ndpOptionDNSSL:                  "DNS Search List",
+}
+
+var mldRecordTypeNames = map[byte]string{
+	1: "MODE_IS_INCLUDE",
+	2: "MODE_IS_EXCLUDE",
+	3: "CHANGE_TO_INCLUDE_MODE",
+	4: "CHANGE_TO_EXCLUDE_MODE",
+	5: "ALLOW_NEW_SOURCES",
+	6: "BLOCK_OLD_SOURCES",
+}
+
+// routerPreferences are the values of the 2 bits of the default router preference and of route information
+var routerPreferences = map[byte]string{
+	0: "Medium",
+	1: "High",
+	2: "Reserved",
+	3: "Low",
+}
+
+func (p ICMPv6Parser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < icmpv6HeaderLength {
+		return nil, fmt.Errorf("truncated ICMPv6 header: %d bytes", len(buf))
+	}
+	if minimum, hit := icmpv6MinimumLengths[buf[0]]; hit && len(buf) < minimum {
+		return nil, fmt.Errorf("truncated ICMPv6 %s: %d bytes", icmpv6MessageTypes[buf[0]], len(buf))
+	}
+	h := make(map[units.PDUHeaderKey]units.Header, 10)
+	h[typeICMPv6] = buf[0:1]
+	h[codeICMPv6] = buf[1:2]
+	h[checksumICMPv6] = buf[2:4]
+	switch buf[0] {
+	case icmpv6EchoRequest, icmpv6EchoReply:
+		h[identifierICMPv6] = buf[4:6]
+		h[sequenceNumberICMPv6] = buf[6:8]
+		h[dataICMPv6] = buf[8:]
+	// errors carry as much of the packet that caused them as fits in the minimum MTU
+	case icmpv6DestinationUnreachable, icmpv6TimeExceeded:
+		h[unusedICMPv6] = buf[4:8]
+		h[invokingPacketICMPv6] = buf[8:]
+	case icmpv6PacketTooBig:
+		h[mtuICMPv6] = buf[4:8]
+		h[invokingPacketICMPv6] = buf[8:]
+	case icmpv6ParameterProblem:
+		h[pointerICMPv6] = buf[4:8]
+		h[invokingPacketICMPv6] = buf[8:]
+	case icmpv6MLDQuery, icmpv6MLDReport, icmpv6MLDDone:
+		h[maxResponseDelayICMPv6] = buf[4:6]
+		h[multicastAddressICMPv6] = buf[8:24]
+		// MLDv2 queries are longer, they may narrow the query down to sources
+		if buf[0] == icmpv6MLDQuery && len(buf) >= 28 {
+			h[queryFlagsICMPv6] = buf[24:25]
+			h[queryIntervalICMPv6] = buf[25:26]
+			sources := int(binary.BigEndian.Uint16(buf[26:28]))
+			h[sourcesICMPv6] = buf[28:min(28+sources*net.IPv6len, len(buf))]
+		}
+	case icmpv6MLDv2Report:
+		h[recordCountICMPv6] = buf[6:8]
+		h[recordsICMPv6] = buf[8:]
+	case icmpv6RouterSolicitation:
+		h[ndpOptionsICMPv6] = buf[8:]
+	case icmpv6RouterAdvertisement:
+		h[curHopLimitICMPv6] = buf[4:5]
+		h[routerFlagsICMPv6] = buf[5:6]
+		h[routerLifetimeICMPv6] = buf[6:8]
+		h[reachableTimeICMPv6] = buf[8:12]
+		h[retransTimerICMPv6] = buf[12:16]
+		h[ndpOptionsICMPv6] = buf[16:]
+	case icmpv6NeighborSolicitation:
+		h[targetAddressICMPv6] = buf[8:24]
+		h[ndpOptionsICMPv6] = buf[24:]
+	case icmpv6NeighborAdvertisement:
+		h[neighborFlagsICMPv6] = buf[4:5]
+		h[targetAddressICMPv6] = buf[8:24]
+		h[ndpOptionsICMPv6] = buf[24:]
+	case icmpv6Redirect:
+		h[targetAddressICMPv6] = buf[8:24]
+		h[destinationAddressICMPv6] = buf[24:40]
+		h[ndpOptionsICMPv6] = buf[40:]
+	}
+	if options, hit := h[ndpOptionsICMPv6]; hit && len(options) == 0 {
+		delete(h, ndpOptionsICMPv6)
+	}
+	return &units.PDU{
+		Headers:  h,
+		Protocol: units.ICMPv6,
+		Payload:  nil,
+	}, nil
+}
+
+func (p ICMPv6Parser) GetNextProtocol(pdu *units.PDU) units.Protocol {
+	return units.UNKNOWN
+}
+
+func (p ICMPv6Parser) HeaderName(header units.PDUHeaderKey) string {
+	return icmpv6HeaderNames[header]
+}
+
+func icmpv6TypeName(messageType byte) string {
+	if name, hit := icmpv6MessageTypes[messageType]; hit {
+		return name
+	}
+	return fmt.Sprintf("Unknown (%d)", messageType)
+}
+
+// ndpLifetime formats a lifetime in seconds, all ones mean forever
+func ndpLifetime(seconds uint32) string {
+	if seconds == 0xffffffff {
+		return "infinity"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// mldMaximumResponseDelay decodes the delay in milliseconds, MLDv2 queries use a floating point format for the
+// values from 32768 on
+func (p ICMPv6Parser) mldMaximumResponseDelay(pdu *units.PDU) time.Duration {
+	code := binary.BigEndian.Uint16(pdu.Headers[maxResponseDelayICMPv6])
+	if _, v2 := pdu.Headers[queryFlagsICMPv6]; v2 && code >= 0x8000 {
+		mantissa, exponent := code&0x0fff, (code>>12)&0x07
+		return time.Duration(uint32(mantissa|0x1000)<<(exponent+3)) * time.Millisecond
+	}
+	return time.Duration(code) * time.Millisecond
+}
+
+// invokingPacketSummary describes the packet an error is about by its IPv6 header, it's usually cut short
+func invokingPacketSummary(packet []byte) string {
+	if len(packet) < ipv6HeaderLength || packet[0]>>4 != 6 {
+		return fmt.Sprintf("%d bytes", len(packet))
+	}
+	_, protocol, err := walkIPv6Extensions(packet[6], packet[ipv6HeaderLength:])
+	if err != nil {
+		protocol = packet[6]
+	}
+	return fmt.Sprintf("%s > %s, %s, %d bytes", formatIPv6(packet[8:24]), formatIPv6(packet[24:40]),
+		ipProtocolName(protocol), len(packet))
+}
+
+func (p ICMPv6Parser) HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu *units.PDU) string {
+	h := pdu.Headers[headerKey]
+	switch headerKey {
+	case typeICMPv6:
+		return icmpv6TypeName(h[0])
+	case codeICMPv6:
+		if name, hit := icmpv6CodeNames[pdu.Headers[typeICMPv6][0]][h[0]]; hit {
+			return name
+		}
+		return strconv.FormatUint(uint64(h[0]), 10)
+	case checksumICMPv6, identifierICMPv6:
+		return fmt.Sprintf("0x%04x", binary.BigEndian.Uint16(h))
+	case sequenceNumberICMPv6, recordCountICMPv6:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint16(h)), 10)
+	case routerLifetimeICMPv6:
+		// 0 tells hosts not to use the router as a default one
+		return (time.Duration(binary.BigEndian.Uint16(h)) * time.Second).String()
+	case dataICMPv6, unusedICMPv6:
+		return fmt.Sprintf("%02x", h)
+	case mtuICMPv6, pointerICMPv6:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(h)), 10)
+	case invokingPacketICMPv6:
+		return invokingPacketSummary(h)
+	case curHopLimitICMPv6:
+		return strconv.FormatUint(uint64(h[0]), 10)
+	case reachableTimeICMPv6, retransTimerICMPv6:
+		return (time.Duration(binary.BigEndian.Uint32(h)) * time.Millisecond).String()
+	case routerFlagsICMPv6:
+		return fmt.Sprintf("[%s], preference %s", strings.Join(setFlags(h[0], "M", "O", "H"), ", "),
+			routerPreferences[(h[0]>>3)&0x03])
+	case neighborFlagsICMPv6:
+		return fmt.Sprintf("[%s]", strings.Join(setFlags(h[0], "Router", "Solicited", "Override"), ", "))
+	case targetAddressICMPv6, destinationAddressICMPv6, multicastAddressICMPv6:
+		return formatIPv6(h)
+	case ndpOptionsICMPv6:
+		options, malformed := parseNDPOptions(h)
+		values := make([]string, 0, len(options)+1)
+		for _, option := range options {
+			values = append(values, option.String())
+		}
+		if malformed {
+			values = append(values, "malformed")
+		}
+		return strings.Join(values, ", ")
+	case maxResponseDelayICMPv6:
+		return p.mldMaximumResponseDelay(pdu).String()
+	case queryFlagsICMPv6:
+		suppress := ""
+		if h[0]&0x08 != 0 {
+			suppress = "suppress router-side processing, "
+		}
+		return fmt.Sprintf("%srobustness %d", suppress, h[0]&0x07)
+	case queryIntervalICMPv6:
+		// Like the maximum response code, a floating point format from 128 on
+		interval := int(h[0])
+		if interval >= 128 {
+			interval = (interval&0x0f | 0x10) << ((interval>>4)&0x07 + 3)
+		}
+		return (time.Duration(interval) * time.Second).String()
+	case sourcesICMPv6:
+		return strings.Join(formatIPv6List(h), ", ")
+	case recordsICMPv6:
+		records := parseMLDRecords(h, int(binary.BigEndian.Uint16(pdu.Headers[recordCountICMPv6])))
+		values := make([]string, len(records))
+		for i, record := range records {
+			values[i] = record.String()
+		}
+		return strings.Join(values, ", ")
+	}
+	return ""
+}
+
+// setFlags names the flags set among the most significant bits of the byte, the names going from the top bit down
+func setFlags(b byte, names ...string) []string {
+	var set []string
+	for i, name := range names {
+		if b&(0x80>>i) != 0 {
+			set = append(set, name)
+		}
+	}
+	return set
+}
+
+func formatIPv6List(buf []byte) []string {
+	addresses := make([]string, 0, len(buf)/net.IPv6len)
+	for i := 0; i+net.IPv6len <= len(buf); i += net.IPv6len {
+		addresses = append(addresses, formatIPv6(buf[i:i+net.IPv6len]))
+	}
+	return addresses
+}
+
+// ndpOption is an option of the Neighbor Discovery messages, its data follows the type and the length
+type ndpOption struct {
+	kind byte
+	data []byte
+}
+
+// parseNDPOptions splits the options, their lengths are in units of 8 bytes and can't be 0
+func parseNDPOptions(buf []byte) (options []ndpOption, malformed bool) {
+	for len(buf) > 0 {
+		if len(buf) < 2 || buf[1] == 0 || int(buf[1])*8 > len(buf) {
+			return options, true
+		}
+		length := int(buf[1]) * 8
+		options = append(options, ndpOption{kind: buf[0], data: buf[2:length]})
+		buf = buf[length:]
+	}
+	return options, false
+}
+
+func (o ndpOption) name() string {
+	if name, hit := ndpOptionNames[o.kind]; hit {
+		return name
+	}
+	return fmt.Sprintf("Option %d", o.kind)
+}
+
+// value formats the data of the option, it's not ok when its length doesn't fit its kind
+func (o ndpOption) value() (string, bool) {
+	data := o.data
+	switch o.kind {
+	case ndpOptionSourceLinkLayerAddress, ndpOptionTargetLinkLayerAddress:
+		return formatLinkAddress(data), true
+	case ndpOptionPrefixInformation:
+		if len(data) != 30 {
+			return "", false
+		}
+		prefix := net.IPNet{IP: net.IP(data[14:30]), Mask: net.CIDRMask(int(data[0]), 128)}
+		flags := setFlags(data[1], "on-link", "autonomous")
+		return fmt.Sprintf("%s [%s], valid %s, preferred %s", prefix.String(), strings.Join(flags, ", "),
+			ndpLifetime(binary.BigEndian.Uint32(data[2:6])), ndpLifetime(binary.BigEndian.Uint32(data[6:10]))), true
+	case ndpOptionRedirectedHeader:
+		if len(data) < 6 {
+			return "", false
+		}
+		return invokingPacketSummary(data[6:]), true
+	case ndpOptionMTU:
+		if len(data) != 6 {
+			return "", false
+		}
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(data[2:6])), 10), true
+	case ndpOptionNonce:
+		return fmt.Sprintf("%x", data), true
+	case ndpOptionRouteInformation:
+		// The prefix is cut to the bytes its length needs
+		if len(data) < 6 || data[0] > 128 {
+			return "", false
+		}
+		prefix := make(net.IP, net.IPv6len)
+		copy(prefix, data[6:])
+		route := net.IPNet{IP: prefix, Mask: net.CIDRMask(int(data[0]), 128)}
+		return fmt.Sprintf("%s, preference %s, lifetime %s", route.String(), routerPreferences[(data[1]>>3)&0x03],
+			ndpLifetime(binary.BigEndian.Uint32(data[2:6]))), true
+	case ndpOptionRDNSS:
+		if len(data) < 6+net.IPv6len || (len(data)-6)%net.IPv6len != 0 {
+			return "", false
+		}
+		return fmt.Sprintf("%s, lifetime %s", strings.Join(formatIPv6List(data[6:]), " "),
+			ndpLifetime(binary.BigEndian.Uint32(data[2:6]))), true
+	case ndpOptionDNSSL:
+		if len(data) < 6 {
+			return "", false
+		}
+		// Domain names encoded as in DNS, without compression, padded with zeros
+		var domains []string
+		for offset := 6; offset < len(data) && data[offset] != 0; {
+			domain, next, err := readDNSName(data, offset)
+			if err != nil || next <= offset {
+				return "", false
+			}
+			domains = append(domains, domain)
+			offset = next
+		}
+		return fmt.Sprintf("%s, lifetime %s", strings.Join(domains, " "),
+			ndpLifetime(binary.BigEndian.Uint32(data[2:6]))), true
+	}
+	return fmt.Sprintf("%x", data), true
+}
+
+func (o ndpOption) String() string {
+	value, ok := o.value()
+	if !ok {
+		return fmt.Sprintf("%s (malformed: %x)", o.name(), o.data)
+	}
+	return fmt.Sprintf("%s: %s", o.name(), value)
+}
+
+// mldRecord is a multicast address record of an MLDv2 report
+type mldRecord struct {
+	recordType byte
+	address    []byte
+	sources    []byte
+}
+
+// parseMLDRecords splits the records, each one a type, the length of its auxiliary data in units of 4 bytes, a number
+// of sources, the multicast address and the sources
+func parseMLDRecords(buf []byte, count int) []mldRecord {
+	var records []mldRecord
+	for i := 0; i < count && len(buf) >= 20; i++ {
+		sources := int(binary.BigEndian.Uint16(buf[2:4]))
+		length := 20 + sources*net.IPv6len + int(buf[1])*4
+		if length > len(buf) {
+			break
+		}
+		records = append(records, mldRecord{
+			recordType: buf[0],
+			address:    buf[4:20],
+			sources:    buf[20 : 20+sources*net.IPv6len],
+		})
+		buf = buf[length:]
+	}
+	return records
+}
+
+func (r mldRecord) String() string {
+	recordType, hit := mldRecordTypeNames[r.recordType]
+	if !hit {
+		recordType = fmt.Sprintf("record type %d", r.recordType)
+	}
+	if len(r.sources) == 0 {
+		return fmt.Sprintf("%s %s", formatIPv6(r.address), recordType)
+	}
+	return fmt.Sprintf("%s %s from %s", formatIPv6(r.address), recordType, strings.Join(formatIPv6List(r.sources), " "))
+}
+
+func (p ICMPv6Parser) MostSignificantHeaders(pdu *units.PDU) []units.PDUHeaderKey {
+	switch pdu.Headers[typeICMPv6][0] {
+	case icmpv6EchoRequest, icmpv6EchoReply:
+		return []units.PDUHeaderKey{typeICMPv6, identifierICMPv6, sequenceNumberICMPv6}
+	case icmpv6DestinationUnreachable, icmpv6TimeExceeded:
+		return []units.PDUHeaderKey{typeICMPv6, codeICMPv6, invokingPacketICMPv6}
+	case icmpv6PacketTooBig:
+		return []units.PDUHeaderKey{typeICMPv6, mtuICMPv6, invokingPacketICMPv6}
+	case icmpv6ParameterProblem:
+		return []units.PDUHeaderKey{typeICMPv6, codeICMPv6, pointerICMPv6}
+	case icmpv6MLDQuery, icmpv6MLDReport, icmpv6MLDDone:
+		return []units.PDUHeaderKey{typeICMPv6, multicastAddressICMPv6}
+	case icmpv6MLDv2Report:
+		return []units.PDUHeaderKey{typeICMPv6, recordsICMPv6}
+	case icmpv6RouterAdvertisement:
+		return p.withOptions(pdu, typeICMPv6, routerFlagsICMPv6, routerLifetimeICMPv6)
+	case icmpv6NeighborSolicitation, icmpv6NeighborAdvertisement:
+		return p.withOptions(pdu, typeICMPv6, targetAddressICMPv6)
+	case icmpv6Redirect:
+		return p.withOptions(pdu, typeICMPv6, targetAddressICMPv6, destinationAddressICMPv6)
+	default:
+		return p.withOptions(pdu, typeICMPv6, codeICMPv6)
+	}
+}
+
+// withOptions adds the Neighbor Discovery options to the headers when there are some
+func (p ICMPv6Parser) withOptions(pdu *units.PDU, headers ...units.PDUHeaderKey) []units.PDUHeaderKey {
+	if _, hit := pdu.Headers[ndpOptionsICMPv6]; hit {
+		return append(headers, ndpOptionsICMPv6)
+	}
+	return headers
+}
+
+func (p ICMPv6Parser) breakdownMessage(headerKey units.PDUHeaderKey, pdu *units.PDU) PDUBreakdownOutput {
+	header := pdu.Headers[headerKey]
+	return PDUBreakdownOutput{
+		KeyName: icmpv6HeaderNames[headerKey],
+		Value:   p.HeaderToHumanReadable(headerKey, pdu),
+		Header:  &header,
+	}
+}
+
+func (p ICMPv6Parser) optionsBreakdown(pdu *units.PDU) PDUBreakdownOutput {
+	header := pdu.Headers[ndpOptionsICMPv6]
+	options, malformed := parseNDPOptions(header)
+	output := PDUBreakdownOutput{
+		KeyName: icmpv6HeaderNames[ndpOptionsICMPv6],
+		Value:   fmt.Sprintf("%d bytes", len(header)),
+		Header:  &header,
+	}
+	for _, option := range options {
+		value, ok := option.value()
+		if !ok {
+			value = fmt.Sprintf("malformed: %x", option.data)
+		}
+		output.InnerBreakdowns = append(output.InnerBreakdowns, PDUBreakdownOutput{KeyName: option.name(), Value: value})
+	}
+	if malformed {
+		output.InnerBreakdowns = append(output.InnerBreakdowns, PDUBreakdownOutput{KeyName: "Malformed", Value: "the options that follow are left out"})
+	}
+	return output
+}
+
+func (p ICMPv6Parser) recordsBreakdown(pdu *units.PDU) PDUBreakdownOutput {
+	header := pdu.Headers[recordsICMPv6]
+	records := parseMLDRecords(header, int(binary.BigEndian.Uint16(pdu.Headers[recordCountICMPv6])))
+	output := PDUBreakdownOutput{
+		KeyName: icmpv6HeaderNames[recordsICMPv6],
+		Value:   strconv.Itoa(len(records)),
+		Header:  &header,
+	}
+	for _, record := range records {
+		output.InnerBreakdowns = append(output.InnerBreakdowns, PDUBreakdownOutput{KeyName: "Record", Value: record.String()})
+	}
+	return output
+}
+
+// icmpv6BreakdownOrder is the order the fields appear in the messages
+var icmpv6BreakdownOrder = []units.PDUHeaderKey{
+	typeICMPv6, codeICMPv6, checksumICMPv6,
+	identifierICMPv6, sequenceNumberICMPv6, unusedICMPv6, mtuICMPv6, pointerICMPv6,
+	curHopLimitICMPv6, routerFlagsICMPv6, neighborFlagsICMPv6, routerLifetimeICMPv6, reachableTimeICMPv6, retransTimerICMPv6,
+	maxResponseDelayICMPv6, multicastAddressICMPv6, queryFlagsICMPv6, queryIntervalICMPv6, sourcesICMPv6,
+	recordCountICMPv6, recordsICMPv6,
+	targetAddressICMPv6, destinationAddressICMPv6, ndpOptionsICMPv6,
+	dataICMPv6, invokingPacketICMPv6,
+}
+
+func (p ICMPv6Parser) PDUBreakdown(pdu *units.PDU) []PDUBreakdownOutput {
+	var bdo []PDUBreakdownOutput
+	for _, headerKey := range icmpv6BreakdownOrder {
+		if _, hit := pdu.Headers[headerKey]; !hit {
+			continue
+		}
+		switch headerKey {
+		case ndpOptionsICMPv6:
+			bdo = append(bdo, p.optionsBreakdown(pdu))
+		case recordsICMPv6:
+			bdo = append(bdo, p.recordsBreakdown(pdu))
+		default:
+			bdo = append(bdo, p.breakdownMessage(headerKey, pdu))
+		}
+	}
+	return bdo
+}
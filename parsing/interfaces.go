@@ -21,3 +21,9 @@ type Parser interface {
 	PDUBreakdown(pdu *units.PDU) []PDUBreakdownOutput
 	HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu *units.PDU) string
 }
+
+// PortParser is implemented by the parsers of transport protocols, their ports go along with the addresses of the
+// layer below
+type PortParser interface {
+	Ports(pdu *units.PDU) (source uint16, destination uint16)
+}
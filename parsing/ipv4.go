@@ -75,8 +75,10 @@ var ecnMap = map[byte]string{
 
 var IPv4ProtocolHeaderMap = map[byte]units.Protocol{
 	1:  units.ICMP,
+	4:  units.IPv4,
 	6:  units.TCP,
 	17: units.UDP,
+	41: units.IPv6,
 }
 
 func dscpName(b byte) string {
@@ -95,9 +97,18 @@ func convertToIP(b []byte) string {
 }
 
 func (p IPV4Parser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < 20 {
+		return nil, fmt.Errorf("truncated IPv4 header: %d bytes", len(buf))
+	}
+	headerLength := buf[0] & 0b00001111
+	if headerLength < 5 {
+		return nil, fmt.Errorf("invalid IPv4 header length %d", headerLength)
+	}
+	if int(headerLength)*4 > len(buf) {
+		return nil, fmt.Errorf("truncated IPv4 header: %d of %d bytes", len(buf), int(headerLength)*4)
+	}
 	h := make(map[units.PDUHeaderKey]units.Header, 13)
 	h[versionIP] = units.Header{buf[0] >> 4}
-	headerLength := buf[0] & 0b00001111
 	h[headerLengthIP] = units.Header{headerLength}
 	h[DSCPIP] = units.Header{buf[1] >> 2}
 	h[ECNIP] = units.Header{buf[1] >> 6}
@@ -118,9 +129,16 @@ func (p IPV4Parser) Parse(buf []byte) (*units.PDU, error) {
 	h[srcIP] = buf[12:16]
 	h[dstIP] = buf[16:20]
 
+	// Frames padded to the minimum Ethernet size carry more than the packet, segmentation offload leaves the length
+	// of outgoing packets at 0 though
+	payload := buf[headerLength*4:]
+	if packetLength := int(binary.BigEndian.Uint16(buf[2:4])); packetLength >= int(headerLength)*4 && packetLength < len(buf) {
+		payload = buf[headerLength*4 : packetLength]
+	}
+
 	return &units.PDU{
 		Headers:  h,
-		Payload:  buf[headerLength*4:],
+		Payload:  payload,
 		Protocol: units.IPv4,
 	}, nil
 }
@@ -164,9 +182,14 @@ func (p IPV4Parser) HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu *uni
 }
 
 func (p IPV4Parser) GetNextProtocol(pdu *units.PDU) units.Protocol {
+	// Only the first fragment starts with the header of the protocol it carries
+	if binary.BigEndian.Uint16(pdu.Headers[fragmentationOffsetIP]) != 0 {
+		return units.UNKNOWN
+	}
 	protocol, _ := IPv4ProtocolHeaderMap[pdu.Headers[protocolIP][0]]
-	if protocol == units.ICMP {
-		return units.ICMP
+	switch protocol {
+	case units.ICMP, units.TCP, units.UDP, units.IPv4, units.IPv6:
+		return protocol
 	}
 	return units.UNKNOWN
 }
@@ -0,0 +1,484 @@
+package parsing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	units "packet_sniffer/model"
+	"strconv"
+	"strings"
+)
+
+type IPv6Parser struct{}
+
+const ipv6HeaderLength = 40
+
+const (
+	srcIPv6 units.PDUHeaderKey = iota
+	dstIPv6
+	versionIPv6
+	trafficClassIPv6
+	flowLabelIPv6
+	payloadLengthIPv6
+	nextHeaderIPv6
+	hopLimitIPv6
+	extensionHeadersIPv6
+)
+
+var ipv6HeaderNames = map[units.PDUHeaderKey]string{
+	srcIPv6:              "Src",
+	dstIPv6:              "Dst",
+	versionIPv6:          "Version",
+	trafficClassIPv6:     "Traffic Class",
+	flowLabelIPv6:        "Flow Label",
+	payloadLengthIPv6:    "Payload Length",
+	nextHeaderIPv6:       "Next Header",
+	hopLimitIPv6:         "Hop Limit",
+	extensionHeadersIPv6: "Extension Headers",
+}
+
+// Next header values of the extension headers, they share the numbers of the protocols carried by IP
+const (
+	ipv6HopByHop            = 0
+	ipv6Routing             = 43
+	ipv6Fragment            = 44
+	ipv6ESP                 = 50
+	ipv6AH                  = 51
+	ipv6NoNextHeader        = 59
+	ipv6DestinationOptions  = 60
+	ipv6Mobility            = 135
+	ipv6HostIdentity        = 139
+	ipv6Shim6               = 140
+	ipv6RoutingSegmentRoute = 4
+)
+
+var IPv6NextHeaderMap = map[byte]units.Protocol{
+	1:  units.ICMP,
+	4:  units.IPv4,
+	6:  units.TCP,
+	17: units.UDP,
+	41: units.IPv6,
+	58: units.ICMPv6,
+}
+
+var ipProtocolNames = map[byte]string{
+	ipv6HopByHop:           "Hop-by-Hop Options",
+	1:                      "ICMP",
+	2:                      "IGMP",
+	4:                      "IPv4",
+	6:                      "TCP",
+	17:                     "UDP",
+	41:                     "IPv6",
+	ipv6Routing:            "Routing",
+	ipv6Fragment:           "Fragment",
+	47:                     "GRE",
+	ipv6ESP:                "Encapsulating Security Payload",
+	ipv6AH:                 "Authentication Header",
+	58:                     "ICMPv6",
+	ipv6NoNextHeader:       "No Next Header",
+	ipv6DestinationOptions: "Destination Options",
+	89:                     "OSPF",
+	103:                    "PIM",
+	112:                    "VRRP",
+	132:                    "SCTP",
+	ipv6Mobility:           "Mobility",
+	ipv6HostIdentity:       "Host Identity Protocol",
+	ipv6Shim6:              "Shim6",
+}
+
+var ipv6RoutingTypeNames = map[byte]string{
+	0:                       "Source Route (deprecated)",
+	2:                       "Type 2 Routing Header (Mobile IPv6)",
+	3:                       "RPL Source Route",
+	ipv6RoutingSegmentRoute: "Segment Routing (SRv6)",
+}
+
+var ipv6OptionNames = map[byte]string{
+	0x00: "Pad1",
+	0x01: "PadN",
+	0x04: "Tunnel Encapsulation Limit",
+	0x05: "Router Alert",
+	0x26: "Quick-Start",
+	0x63: "RPL Option",
+	0xc2: "Jumbo Payload",
+	0xc9: "Home Address",
+}
+
+var ipv6RouterAlertNames = map[uint16]string{
+	0: "MLD",
+	1: "RSVP",
+	2: "Active Networks",
+}
+
+// ipv6ExtensionHeader is a header of the chain between the fixed header and the upper layer protocol
+type ipv6ExtensionHeader struct {
+	protocol byte // the next header value that announced it
+	next     byte
+	data     []byte // the whole header
+}
+
+func ipProtocolName(protocol byte) string {
+	if name, hit := ipProtocolNames[protocol]; hit {
+		return name
+	}
+	return fmt.Sprintf("Unknown (%d)", protocol)
+}
+
+// isIPv6Extension tells whether the next header value announces an extension header rather than the upper layer
+// protocol. ESP is one although nothing can be told of what follows it
+func isIPv6Extension(protocol byte) bool {
+	switch protocol {
+	case ipv6HopByHop, ipv6Routing, ipv6Fragment, ipv6ESP, ipv6AH, ipv6DestinationOptions, ipv6Mobility,
+		ipv6HostIdentity, ipv6Shim6:
+		return true
+	}
+	return false
+}
+
+// walkIPv6Extensions splits the extension headers at the start of the buffer, the first one being announced by the
+// next header value. It returns the protocol of what follows them, ESP when it's encrypted. The headers that are whole
+// come back along with the error of a truncated one, which is announced by the protocol returned then
+func walkIPv6Extensions(protocol byte, buf []byte) ([]ipv6ExtensionHeader, byte, error) {
+	var headers []ipv6ExtensionHeader
+	for isIPv6Extension(protocol) {
+		if protocol == ipv6ESP {
+			// The SPI and the sequence number, the rest and the next header value are encrypted
+			if len(buf) < 8 {
+				return headers, protocol, fmt.Errorf("truncated ESP header: %d bytes", len(buf))
+			}
+			return append(headers, ipv6ExtensionHeader{protocol: protocol, data: buf[:8]}), ipv6ESP, nil
+		}
+		if len(buf) < 8 {
+			return headers, protocol, fmt.Errorf("truncated %s header: %d bytes", ipProtocolName(protocol), len(buf))
+		}
+		length := (int(buf[1]) + 1) * 8
+		switch protocol {
+		case ipv6Fragment:
+			length = 8
+		case ipv6AH:
+			length = (int(buf[1]) + 2) * 4
+		}
+		if protocol == ipv6AH && length < 12 {
+			return headers, protocol, fmt.Errorf("invalid Authentication Header length %d", length)
+		}
+		if length > len(buf) {
+			return headers, protocol, fmt.Errorf("truncated %s header: %d of %d bytes", ipProtocolName(protocol),
+				len(buf), length)
+		}
+		header := ipv6ExtensionHeader{protocol: protocol, next: buf[0], data: buf[:length]}
+		headers = append(headers, header)
+		protocol, buf = buf[0], buf[length:]
+		if header.protocol == ipv6Fragment && header.fragmentOffset() != 0 {
+			// What follows the header of a fragment other than the first is the rest of the packet, not more headers
+			break
+		}
+	}
+	return headers, protocol, nil
+}
+
+func formatIPv6(b []byte) string {
+	if len(b) != net.IPv6len {
+		return "Invalid input: byte slice length is not 16"
+	}
+	return net.IP(b).String()
+}
+
+func (p IPv6Parser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < ipv6HeaderLength {
+		return nil, fmt.Errorf("truncated IPv6 header: %d bytes", len(buf))
+	}
+	h := make(map[units.PDUHeaderKey]units.Header, 9)
+	h[versionIPv6] = units.Header{buf[0] >> 4}
+	h[trafficClassIPv6] = units.Header{buf[0]<<4 | buf[1]>>4}
+	h[flowLabelIPv6] = units.Header{buf[1] & 0x0f, buf[2], buf[3]}
+	h[payloadLengthIPv6] = buf[4:6]
+	h[nextHeaderIPv6] = buf[6:7]
+	h[hopLimitIPv6] = buf[7:8]
+	h[srcIPv6] = buf[8:24]
+	h[dstIPv6] = buf[24:40]
+
+	// Frames padded to the minimum Ethernet size carry more than the packet, jumbograms and segmentation offload
+	// leave the payload length at 0 though
+	payload := buf[ipv6HeaderLength:]
+	if payloadLength := int(binary.BigEndian.Uint16(buf[4:6])); payloadLength > 0 && payloadLength < len(payload) {
+		payload = payload[:payloadLength]
+	}
+	// A chain cut short, by the snap length most likely, leaves what follows the headers that are whole as payload
+	extensions, _, _ := walkIPv6Extensions(buf[6], payload)
+	chainLength := 0
+	for _, extension := range extensions {
+		chainLength += len(extension.data)
+	}
+	if chainLength > 0 {
+		h[extensionHeadersIPv6] = payload[:chainLength]
+	}
+
+	return &units.PDU{
+		Headers:  h,
+		Payload:  payload[chainLength:],
+		Protocol: units.IPv6,
+	}, nil
+}
+
+// extensions walks the extension headers Parse kept again. The protocol is the one of a truncated header if the chain
+// was cut short
+func (p IPv6Parser) extensions(pdu *units.PDU) ([]ipv6ExtensionHeader, byte) {
+	extensions, protocol, _ := walkIPv6Extensions(pdu.Headers[nextHeaderIPv6][0], pdu.Headers[extensionHeadersIPv6])
+	return extensions, protocol
+}
+
+// fragmentOffset is the offset in bytes of the fragment, 0 for a packet that isn't fragmented
+func (e ipv6ExtensionHeader) fragmentOffset() int {
+	// In units of 8 bytes in the upper 13 bits
+	return int(binary.BigEndian.Uint16(e.data[2:4]) & 0xfff8)
+}
+
+func (p IPv6Parser) GetNextProtocol(pdu *units.PDU) units.Protocol {
+	extensions, protocol := p.extensions(pdu)
+	for _, extension := range extensions {
+		// Only the first fragment starts with the header of the protocol it carries
+		if extension.protocol == ipv6Fragment && extension.fragmentOffset() != 0 {
+			return units.UNKNOWN
+		}
+	}
+	switch next := IPv6NextHeaderMap[protocol]; next {
+	case units.TCP, units.UDP, units.ICMPv6, units.IPv4, units.IPv6:
+		return next
+	}
+	return units.UNKNOWN
+}
+
+// summary describes the extension header in a few words
+func (e ipv6ExtensionHeader) summary() string {
+	name := ipProtocolName(e.protocol)
+	switch e.protocol {
+	case ipv6Fragment:
+		more := ""
+		if e.data[3]&0x01 != 0 {
+			more = ", more fragments"
+		}
+		return fmt.Sprintf("%s (offset %d%s, ID 0x%08x)", name, e.fragmentOffset(), more,
+			binary.BigEndian.Uint32(e.data[4:8]))
+	case ipv6Routing:
+		return fmt.Sprintf("%s (%s, %d segments left)", name, ipv6RoutingTypeName(e.data[2]), e.data[3])
+	case ipv6ESP, ipv6AH:
+		return fmt.Sprintf("%s (SPI 0x%08x)", name, binary.BigEndian.Uint32(e.spiAndSequence()[0:4]))
+	case ipv6HopByHop, ipv6DestinationOptions:
+		var options []string
+		for _, option := range e.options() {
+			// Padding says nothing
+			if option.kind != 0x00 && option.kind != 0x01 {
+				options = append(options, option.name())
+			}
+		}
+		if len(options) > 0 {
+			return fmt.Sprintf("%s (%s)", name, strings.Join(options, ", "))
+		}
+	}
+	return name
+}
+
+// spiAndSequence are the first bytes of ESP and those after the length and the reserved field of AH
+func (e ipv6ExtensionHeader) spiAndSequence() []byte {
+	if e.protocol == ipv6AH {
+		return e.data[4:12]
+	}
+	return e.data[0:8]
+}
+
+func ipv6RoutingTypeName(routingType byte) string {
+	if name, hit := ipv6RoutingTypeNames[routingType]; hit {
+		return name
+	}
+	return fmt.Sprintf("type %d", routingType)
+}
+
+// ipv6Option is an option of the Hop-by-Hop and Destination Options headers
+type ipv6Option struct {
+	kind byte
+	data []byte
+}
+
+// options splits the options following the next header and length bytes, a malformed one ends them
+func (e ipv6ExtensionHeader) options() []ipv6Option {
+	var options []ipv6Option
+	buf := e.data[2:]
+	for len(buf) > 0 {
+		if buf[0] == 0x00 {
+			options = append(options, ipv6Option{kind: buf[0]})
+			buf = buf[1:]
+			continue
+		}
+		if len(buf) < 2 || 2+int(buf[1]) > len(buf) {
+			break
+		}
+		options = append(options, ipv6Option{kind: buf[0], data: buf[2 : 2+buf[1]]})
+		buf = buf[2+buf[1]:]
+	}
+	return options
+}
+
+func (o ipv6Option) name() string {
+	if name, hit := ipv6OptionNames[o.kind]; hit {
+		return name
+	}
+	return fmt.Sprintf("Option 0x%02x", o.kind)
+}
+
+func (o ipv6Option) value() string {
+	switch {
+	case o.kind == 0x05 && len(o.data) == 2:
+		value := binary.BigEndian.Uint16(o.data)
+		if name, hit := ipv6RouterAlertNames[value]; hit {
+			return name
+		}
+		return strconv.Itoa(int(value))
+	case o.kind == 0xc2 && len(o.data) == 4:
+		return fmt.Sprintf("%d bytes", binary.BigEndian.Uint32(o.data))
+	case o.kind == 0x04 && len(o.data) == 1:
+		return strconv.Itoa(int(o.data[0]))
+	case o.kind == 0xc9 && len(o.data) == net.IPv6len:
+		return formatIPv6(o.data)
+	case o.kind == 0x00:
+		return "1 byte"
+	case o.kind == 0x01:
+		return fmt.Sprintf("%d bytes", len(o.data)+2)
+	}
+	return fmt.Sprintf("%x", o.data)
+}
+
+func (p IPv6Parser) HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu *units.PDU) string {
+	header := pdu.Headers[headerKey]
+	switch headerKey {
+	case srcIPv6, dstIPv6:
+		return formatIPv6(header)
+	case versionIPv6, hopLimitIPv6:
+		return strconv.FormatUint(uint64(header[0]), 10)
+	case trafficClassIPv6:
+		return fmt.Sprintf("DSCP: %s, ECN: %s", dscpName(header[0]>>2), ecnMap[header[0]&0x03])
+	case flowLabelIPv6:
+		return fmt.Sprintf("0x%05x", uint32(header[0])<<16|uint32(header[1])<<8|uint32(header[2]))
+	case payloadLengthIPv6:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint16(header)), 10)
+	case nextHeaderIPv6:
+		return ipProtocolName(header[0])
+	case extensionHeadersIPv6:
+		extensions, _ := p.extensions(pdu)
+		summaries := make([]string, len(extensions))
+		for i, extension := range extensions {
+			summaries[i] = extension.summary()
+		}
+		return strings.Join(summaries, ", ")
+	}
+	return "Unknown"
+}
+
+func (p IPv6Parser) MostSignificantHeaders(pdu *units.PDU) []units.PDUHeaderKey {
+	if _, hit := pdu.Headers[extensionHeadersIPv6]; hit {
+		return []units.PDUHeaderKey{srcIPv6, dstIPv6, extensionHeadersIPv6}
+	}
+	return []units.PDUHeaderKey{srcIPv6, dstIPv6}
+}
+
+func (p IPv6Parser) HeaderName(header units.PDUHeaderKey) string {
+	return ipv6HeaderNames[header]
+}
+
+func (p IPv6Parser) breakdown(headerKey units.PDUHeaderKey, pdu *units.PDU) PDUBreakdownOutput {
+	h := pdu.Headers[headerKey]
+	return PDUBreakdownOutput{
+		KeyName: ipv6HeaderNames[headerKey],
+		Value:   p.HeaderToHumanReadable(headerKey, pdu),
+		Header:  &h,
+	}
+}
+
+func (p IPv6Parser) trafficClassBreakdown(pdu *units.PDU) PDUBreakdownOutput {
+	h := pdu.Headers[trafficClassIPv6]
+	desc := p.HeaderToHumanReadable(trafficClassIPv6, pdu)
+	return PDUBreakdownOutput{
+		KeyName:     ipv6HeaderNames[trafficClassIPv6],
+		Value:       fmt.Sprintf("0x%02x", h[0]),
+		Header:      &h,
+		Description: &desc,
+	}
+}
+
+// extensionBreakdown lists the fields of an extension header, what's generic to all of them first
+func (p IPv6Parser) extensionBreakdown(extension ipv6ExtensionHeader) PDUBreakdownOutput {
+	h := units.Header(extension.data)
+	output := PDUBreakdownOutput{
+		KeyName: ipProtocolName(extension.protocol),
+		Value:   fmt.Sprintf("%d bytes", len(extension.data)),
+		Header:  &h,
+	}
+	field := func(name string, format string, args ...any) {
+		output.InnerBreakdowns = append(output.InnerBreakdowns, PDUBreakdownOutput{KeyName: name, Value: fmt.Sprintf(format, args...)})
+	}
+	if extension.protocol != ipv6ESP {
+		field("Next Header", "%s", ipProtocolName(extension.next))
+	}
+	data := extension.data
+	switch extension.protocol {
+	case ipv6HopByHop, ipv6DestinationOptions:
+		for _, option := range extension.options() {
+			field(option.name(), "%s", option.value())
+		}
+	case ipv6Routing:
+		field("Routing Type", "%s", ipv6RoutingTypeName(data[2]))
+		field("Segments Left", "%d", data[3])
+		switch data[2] {
+		case ipv6RoutingSegmentRoute:
+			// The segments are listed from the last one on, segments left indexes the active one
+			field("Last Entry", "%d", data[4])
+			field("Flags", "0x%02x", data[5])
+			field("Tag", "%d", binary.BigEndian.Uint16(data[6:8]))
+			for i := 0; i <= int(data[4]) && 8+(i+1)*net.IPv6len <= len(data); i++ {
+				segment := formatIPv6(data[8+i*net.IPv6len : 8+(i+1)*net.IPv6len])
+				if i == int(data[3]) {
+					segment += " (active)"
+				}
+				field(fmt.Sprintf("Segment[%d]", i), "%s", segment)
+			}
+		case 2:
+			if len(data) >= 8+net.IPv6len {
+				field("Home Address", "%s", formatIPv6(data[8:8+net.IPv6len]))
+			}
+		default:
+			field("Data", "%x", data[4:])
+		}
+	case ipv6Fragment:
+		field("Offset", "%d", extension.fragmentOffset())
+		field("More Fragments", "%s", isFlagSet[data[3]&0x01])
+		field("Identification", "0x%08x", binary.BigEndian.Uint32(data[4:8]))
+	case ipv6AH, ipv6ESP:
+		spiAndSequence := extension.spiAndSequence()
+		field("SPI", "0x%08x", binary.BigEndian.Uint32(spiAndSequence[0:4]))
+		field("Sequence Number", "%d", binary.BigEndian.Uint32(spiAndSequence[4:8]))
+		if extension.protocol == ipv6AH {
+			field("Integrity Check Value", "%x", data[12:])
+		}
+	}
+	return output
+}
+
+func (p IPv6Parser) PDUBreakdown(pdu *units.PDU) []PDUBreakdownOutput {
+	bdo := []PDUBreakdownOutput{
+		p.breakdown(versionIPv6, pdu),
+		p.trafficClassBreakdown(pdu),
+		p.breakdown(flowLabelIPv6, pdu),
+		p.breakdown(payloadLengthIPv6, pdu),
+		p.breakdown(nextHeaderIPv6, pdu),
+		p.breakdown(hopLimitIPv6, pdu),
+		p.breakdown(srcIPv6, pdu),
+		p.breakdown(dstIPv6, pdu),
+	}
+	extensions, protocol := p.extensions(pdu)
+	for _, extension := range extensions {
+		bdo = append(bdo, p.extensionBreakdown(extension))
+	}
+	if protocol == ipv6ESP {
+		bdo = append(bdo, PDUBreakdownOutput{KeyName: "Encrypted Payload", Value: fmt.Sprintf("%d bytes", len(pdu.Payload))})
+	}
+	return bdo
+}
@@ -0,0 +1,59 @@
+package parsing
+
+import (
+	units "packet_sniffer/model"
+	"testing"
+)
+
+// ipv6Packet builds a packet of the next header value and payload, with the payload length set
+func ipv6Packet(nextHeader byte, payload ...byte) []byte {
+	packet := make([]byte, ipv6HeaderLength, ipv6HeaderLength+len(payload))
+	packet[0] = 0x60
+	packet[4], packet[5] = byte(len(payload)>>8), byte(len(payload))
+	packet[6], packet[7] = nextHeader, 64
+	return append(packet, payload...)
+}
+
+func TestIPv6ParseExtensions(t *testing.T) {
+	udp := []byte{0x30, 0x39, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00}
+	hopByHop := []byte{ipv6Fragment, 0, 0x05, 0x02, 0x00, 0x00, 0x01, 0x00} // router alert
+	firstFragment := []byte{17, 0, 0x00, 0x01, 0, 0, 0, 1}
+	// Offset 8, what follows is data even though the next header value announces Destination Options
+	laterFragment := []byte{ipv6DestinationOptions, 0, 0x00, 0x09, 0, 0, 0, 1}
+	fragmentData := []byte{0xde, 0xad, 0xbe, 0xef, 0xde, 0xad, 0xbe, 0xef, 0xff, 0xff}
+
+	tests := []struct {
+		name       string
+		packet     []byte
+		extensions int
+		payload    int
+		next       units.Protocol
+	}{
+		{name: "no extensions", packet: ipv6Packet(17, udp...), payload: 8, next: units.UDP},
+		{name: "first fragment", packet: ipv6Packet(ipv6HopByHop, append(append(hopByHop, firstFragment...), udp...)...),
+			extensions: 2, payload: 8, next: units.UDP},
+		{name: "later fragment", packet: ipv6Packet(ipv6Fragment, append(laterFragment, fragmentData...)...),
+			extensions: 1, payload: len(fragmentData), next: units.UNKNOWN},
+		// The Hop-by-Hop header claims 16 bytes
+		{name: "truncated chain", packet: ipv6Packet(ipv6HopByHop, 17, 1, 0, 0, 0, 0, 0, 0, 0, 0),
+			extensions: 0, payload: 10, next: units.UNKNOWN},
+		{name: "truncated after a whole header", packet: ipv6Packet(ipv6HopByHop, append(hopByHop, 17, 0, 0)...),
+			extensions: 1, payload: 3, next: units.UNKNOWN},
+	}
+	for _, test := range tests {
+		p := IPv6Parser{}
+		pdu, err := p.Parse(test.packet)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		extensions, _ := p.extensions(pdu)
+		if len(extensions) != test.extensions || len(pdu.Payload) != test.payload {
+			t.Errorf("%s: got %d extension headers and %d bytes of payload, want %d and %d", test.name,
+				len(extensions), len(pdu.Payload), test.extensions, test.payload)
+		}
+		if next := p.GetNextProtocol(pdu); next != test.next {
+			t.Errorf("%s: got next protocol %d, want %d", test.name, next, test.next)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package parsing
+
+import (
+	"encoding/binary"
+	"fmt"
+	units "packet_sniffer/model"
+)
+
+// NullParser dissects the header of BSD loopback captures, a single word holding the address family of the packet in
+// the byte order of the host that wrote the capture
+type NullParser struct{}
+
+const nullHeaderLength = 4
+
+const (
+	familyNull units.PDUHeaderKey = iota + 2
+)
+
+var nullHeaderNames = map[units.PDUHeaderKey]string{
+	familyNull: "Family",
+}
+
+// The value of AF_INET6 differs between the BSDs and Linux
+var nullFamilies = map[uint32]units.Protocol{
+	2:  units.IPv4,
+	10: units.IPv6,
+	24: units.IPv6,
+	28: units.IPv6,
+	30: units.IPv6,
+}
+
+func (p NullParser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < nullHeaderLength {
+		return nil, fmt.Errorf("truncated BSD loopback header: %d bytes", len(buf))
+	}
+	h := make(map[units.PDUHeaderKey]units.Header, 1)
+	h[familyNull] = buf[0:4]
+
+	return &units.PDU{
+		Headers:  h,
+		Payload:  buf[nullHeaderLength:],
+		Protocol: units.NULL_LOOPBACK,
+	}, nil
+}
+
+// family reads the address family, families are small numbers so the upper half of the word is zero in big endian
+func (p NullParser) family(header []byte) uint32 {
+	if header[0] == 0 && header[1] == 0 {
+		return binary.BigEndian.Uint32(header)
+	}
+	return binary.LittleEndian.Uint32(header)
+}
+
+func (p NullParser) HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu *units.PDU) string {
+	if headerKey != familyNull {
+		return ""
+	}
+	family := p.family(pdu.Headers[familyNull])
+	if protocol, hit := nullFamilies[family]; hit {
+		return units.ProtocolStringMap[protocol].Shortened
+	}
+	return fmt.Sprintf("Unknown Family %d", family)
+}
+
+func (p NullParser) GetNextProtocol(pdu *units.PDU) units.Protocol {
+	if protocol, hit := nullFamilies[p.family(pdu.Headers[familyNull])]; hit {
+		return protocol
+	}
+	return units.UNKNOWN
+}
+
+func (p NullParser) MostSignificantHeaders(*units.PDU) []units.PDUHeaderKey {
+	return []units.PDUHeaderKey{familyNull}
+}
+
+func (p NullParser) HeaderName(header units.PDUHeaderKey) string {
+	return nullHeaderNames[header]
+}
+
+func (p NullParser) PDUBreakdown(pdu *units.PDU) []PDUBreakdownOutput {
+	return []PDUBreakdownOutput{{KeyName: "Family", Value: p.HeaderToHumanReadable(familyNull, pdu)}}
+}
@@ -0,0 +1,142 @@
+package parsing
+
+import (
+	"encoding/binary"
+	"fmt"
+	units "packet_sniffer/model"
+	"strconv"
+	"strings"
+)
+
+// SLLParser dissects the pseudo-header Linux puts in front of frames captured without their link-layer header, such
+// as the ones of the "any" device
+type SLLParser struct{}
+
+const sllHeaderLength = 16
+
+const (
+	// A cooked header only has the address of the sender, which takes the place of SRCHeader
+	addressSLL units.PDUHeaderKey = iota
+	_
+	packetTypeSLL
+	hardwareTypeSLL
+	addressLengthSLL
+	protocolSLL
+)
+
+var sllHeaderNames = map[units.PDUHeaderKey]string{
+	addressSLL:       "Src",
+	packetTypeSLL:    "Packet Type",
+	hardwareTypeSLL:  "Hardware Type",
+	addressLengthSLL: "Address Length",
+	protocolSLL:      "Protocol",
+}
+
+// arphrdNames names the ARPHRD_* device types of linux/if_arp.h that are commonly seen in cooked headers
+var arphrdNames = map[uint16]string{
+	1:      "Ethernet",
+	32:     "InfiniBand",
+	512:    "PPP",
+	519:    "Raw IP",
+	768:    "IPIP tunnel",
+	769:    "IP6IP6 tunnel",
+	772:    "Loopback",
+	776:    "IPv6-in-IPv4",
+	778:    "GRE tunnel",
+	823:    "IP6GRE tunnel",
+	824:    "Netlink",
+	0xfffe: "None",
+}
+
+func (p SLLParser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < sllHeaderLength {
+		return nil, fmt.Errorf("truncated Linux cooked header: %d bytes", len(buf))
+	}
+	h := make(map[units.PDUHeaderKey]units.Header, 5)
+	h[packetTypeSLL] = buf[0:2]
+	h[hardwareTypeSLL] = buf[2:4]
+	h[addressLengthSLL] = buf[4:6]
+	h[addressSLL] = buf[6 : 6+min(int(binary.BigEndian.Uint16(buf[4:6])), 8)]
+	h[protocolSLL] = buf[14:16]
+
+	return &units.PDU{
+		Headers:  h,
+		Payload:  buf[sllHeaderLength:],
+		Protocol: units.LINUX_SLL,
+	}, nil
+}
+
+// formatLinkAddress formats link-layer addresses that may not be MAC addresses
+func formatLinkAddress(address []byte) string {
+	if len(address) == 6 {
+		return formatMac(address)
+	}
+	parts := make([]string, len(address))
+	for i, b := range address {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func formatHardwareType(header []byte) string {
+	hardwareType := binary.BigEndian.Uint16(header)
+	if name, hit := arphrdNames[hardwareType]; hit {
+		return name
+	}
+	return fmt.Sprintf("Unknown (%d)", hardwareType)
+}
+
+// formatPacketType names the PACKET_* values of sockaddr_ll carried by cooked headers
+func formatPacketType(packetType uint16) string {
+	if name, hit := units.PacketTypeStringMap[units.PacketType(packetType+1)]; hit && packetType <= 4 {
+		return name
+	}
+	return fmt.Sprintf("Unknown (%d)", packetType)
+}
+
+func formatCookedProtocol(header []byte) string {
+	protocol := EthernetParser{}.ProtocolFromEtherType(header)
+	if protocol == units.UNKNOWN {
+		return fmt.Sprintf("Unknown Protocol %04x", binary.BigEndian.Uint16(header))
+	}
+	return units.ProtocolStringMap[protocol].Shortened
+}
+
+func (p SLLParser) HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu *units.PDU) string {
+	header := pdu.Headers[headerKey]
+	switch headerKey {
+	case addressSLL:
+		return formatLinkAddress(header)
+	case packetTypeSLL:
+		return formatPacketType(binary.BigEndian.Uint16(header))
+	case hardwareTypeSLL:
+		return formatHardwareType(header)
+	case addressLengthSLL:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint16(header)), 10)
+	case protocolSLL:
+		return formatCookedProtocol(header)
+	}
+	return ""
+}
+
+func (p SLLParser) GetNextProtocol(pdu *units.PDU) units.Protocol {
+	return EthernetParser{}.ProtocolFromEtherType(pdu.Headers[protocolSLL])
+}
+
+func (p SLLParser) MostSignificantHeaders(*units.PDU) []units.PDUHeaderKey {
+	return []units.PDUHeaderKey{packetTypeSLL, addressSLL, protocolSLL}
+}
+
+func (p SLLParser) HeaderName(header units.PDUHeaderKey) string {
+	return sllHeaderNames[header]
+}
+
+func (p SLLParser) PDUBreakdown(pdu *units.PDU) []PDUBreakdownOutput {
+	output := make([]PDUBreakdownOutput, 5)
+	output[0] = PDUBreakdownOutput{KeyName: "Packet Type", Value: p.HeaderToHumanReadable(packetTypeSLL, pdu)}
+	output[1] = PDUBreakdownOutput{KeyName: "Hardware Type", Value: p.HeaderToHumanReadable(hardwareTypeSLL, pdu)}
+	output[2] = PDUBreakdownOutput{KeyName: "Address Length", Value: p.HeaderToHumanReadable(addressLengthSLL, pdu)}
+	output[3] = PDUBreakdownOutput{KeyName: "Source", Value: p.HeaderToHumanReadable(addressSLL, pdu)}
+	output[4] = PDUBreakdownOutput{KeyName: "Protocol", Value: p.HeaderToHumanReadable(protocolSLL, pdu)}
+	return output
+}
@@ -0,0 +1,95 @@
+package parsing
+
+import (
+	"encoding/binary"
+	"fmt"
+	units "packet_sniffer/model"
+	"strconv"
+)
+
+// SLL2Parser dissects the second version of the Linux cooked header, which also tells the interface a frame was
+// captured on
+type SLL2Parser struct{}
+
+const sll2HeaderLength = 20
+
+const (
+	addressSLL2 units.PDUHeaderKey = iota
+	_
+	protocolSLL2
+	interfaceIndexSLL2
+	hardwareTypeSLL2
+	packetTypeSLL2
+	addressLengthSLL2
+)
+
+var sll2HeaderNames = map[units.PDUHeaderKey]string{
+	addressSLL2:        "Src",
+	protocolSLL2:       "Protocol",
+	interfaceIndexSLL2: "Interface Index",
+	hardwareTypeSLL2:   "Hardware Type",
+	packetTypeSLL2:     "Packet Type",
+	addressLengthSLL2:  "Address Length",
+}
+
+func (p SLL2Parser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < sll2HeaderLength {
+		return nil, fmt.Errorf("truncated Linux cooked v2 header: %d bytes", len(buf))
+	}
+	h := make(map[units.PDUHeaderKey]units.Header, 6)
+	h[protocolSLL2] = buf[0:2]
+	// buf[2:4] is reserved
+	h[interfaceIndexSLL2] = buf[4:8]
+	h[hardwareTypeSLL2] = buf[8:10]
+	h[packetTypeSLL2] = buf[10:11]
+	h[addressLengthSLL2] = buf[11:12]
+	h[addressSLL2] = buf[12 : 12+min(int(buf[11]), 8)]
+
+	return &units.PDU{
+		Headers:  h,
+		Payload:  buf[sll2HeaderLength:],
+		Protocol: units.LINUX_SLL2,
+	}, nil
+}
+
+func (p SLL2Parser) HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu *units.PDU) string {
+	header := pdu.Headers[headerKey]
+	switch headerKey {
+	case addressSLL2:
+		return formatLinkAddress(header)
+	case protocolSLL2:
+		return formatCookedProtocol(header)
+	case interfaceIndexSLL2:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(header)), 10)
+	case hardwareTypeSLL2:
+		return formatHardwareType(header)
+	case packetTypeSLL2:
+		return formatPacketType(uint16(header[0]))
+	case addressLengthSLL2:
+		return strconv.FormatUint(uint64(header[0]), 10)
+	}
+	return ""
+}
+
+func (p SLL2Parser) GetNextProtocol(pdu *units.PDU) units.Protocol {
+	return EthernetParser{}.ProtocolFromEtherType(pdu.Headers[protocolSLL2])
+}
+
+func (p SLL2Parser) MostSignificantHeaders(*units.PDU) []units.PDUHeaderKey {
+	return []units.PDUHeaderKey{interfaceIndexSLL2, packetTypeSLL2, addressSLL2, protocolSLL2}
+}
+
+func (p SLL2Parser) HeaderName(header units.PDUHeaderKey) string {
+	return sll2HeaderNames[header]
+}
+
+func (p SLL2Parser) PDUBreakdown(pdu *units.PDU) []PDUBreakdownOutput {
+	output := make([]PDUBreakdownOutput, 6)
+	output[0] = PDUBreakdownOutput{KeyName: "Protocol", Value: p.HeaderToHumanReadable(protocolSLL2, pdu)}
+	output[1] = PDUBreakdownOutput{KeyName: "Interface Index", Value: p.HeaderToHumanReadable(interfaceIndexSLL2, pdu)}
+	output[2] = PDUBreakdownOutput{KeyName: "Hardware Type", Value: p.HeaderToHumanReadable(hardwareTypeSLL2, pdu)}
+	output[3] = PDUBreakdownOutput{KeyName: "Packet Type", Value: p.HeaderToHumanReadable(packetTypeSLL2, pdu)}
+	output[4] = PDUBreakdownOutput{KeyName: "Address Length", Value: p.HeaderToHumanReadable(addressLengthSLL2, pdu)}
+	output[5] = PDUBreakdownOutput{KeyName: "Source", Value: p.HeaderToHumanReadable(addressSLL2, pdu)}
+	return output
+}
@@ -0,0 +1,381 @@
+package parsing
+
+import (
+	"encoding/binary"
+	"fmt"
+	units "packet_sniffer/model"
+	"strconv"
+	"strings"
+)
+
+type TCPParser struct{}
+
+const tcpMinimumHeaderLength = 20
+
+const (
+	srcPortTCP units.PDUHeaderKey = iota + 2
+	dstPortTCP
+	sequenceNumberTCP
+	acknowledgmentNumberTCP
+	dataOffsetTCP
+	flagsTCP
+	windowTCP
+	checksumTCP
+	urgentPointerTCP
+	optionsTCP
+)
+
+var tcpHeaderNames = map[units.PDUHeaderKey]string{
+	srcPortTCP:              "Src Port",
+	dstPortTCP:              "Dst Port",
+	sequenceNumberTCP:       "Seq",
+	acknowledgmentNumberTCP: "Ack",
+	dataOffsetTCP:           "Header Length",
+	flagsTCP:                "Flags",
+	windowTCP:               "Window",
+	checksumTCP:             "Checksum",
+	urgentPointerTCP:        "Urgent Pointer",
+	optionsTCP:              "Options",
+}
+
+// tcpFlagNames are ordered from the most significant bit of the 9 flag bits down, AE used to be named NS
+var tcpFlagNames = []string{"AE", "CWR", "ECE", "URG", "ACK", "PSH", "RST", "SYN", "FIN"}
+
+const (
+	tcpOptionEnd            = 0
+	tcpOptionNOP            = 1
+	tcpOptionMSS            = 2
+	tcpOptionWindowScale    = 3
+	tcpOptionSACKPermitted  = 4
+	tcpOptionSACK           = 5
+	tcpOptionTimestamps     = 8
+	tcpOptionMPTCP          = 30
+	tcpOptionFastOpen       = 34
+	tcpOptionExperimental   = 254
+	tcpFastOpenExperimentID = 0xf989
+)
+
+var tcpOptionNames = map[byte]string{
+	tcpOptionEnd:           "End of Option List",
+	tcpOptionNOP:           "No-Operation",
+	tcpOptionMSS:           "Maximum Segment Size",
+	tcpOptionWindowScale:   "Window Scale",
+	tcpOptionSACKPermitted: "SACK Permitted",
+	tcpOptionSACK:          "SACK",
+	tcpOptionTimestamps:    "Timestamps",
+	tcpOptionMPTCP:         "Multipath TCP",
+	tcpOptionFastOpen:      "TCP Fast Open",
+	tcpOptionExperimental:  "Experimental",
+}
+
+var mptcpSubtypeNames = map[byte]string{
+	0: "MP_CAPABLE",
+	1: "MP_JOIN",
+	2: "DSS",
+	3: "ADD_ADDR",
+	4: "REMOVE_ADDR",
+	5: "MP_PRIO",
+	6: "MP_FAIL",
+	7: "MP_FASTCLOSE",
+	8: "MP_TCPRST",
+}
+
+// tcpOption is a kind followed by its data, the length byte left out
+type tcpOption struct {
+	kind byte
+	data []byte
+}
+
+func (p TCPParser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < tcpMinimumHeaderLength {
+		return nil, fmt.Errorf("truncated TCP header: %d bytes", len(buf))
+	}
+	headerLength := int(buf[12]>>4) * 4
+	if headerLength < tcpMinimumHeaderLength || headerLength > len(buf) {
+		return nil, fmt.Errorf("invalid TCP header length %d in a %d bytes segment", headerLength, len(buf))
+	}
+	h := make(map[units.PDUHeaderKey]units.Header, 10)
+	h[srcPortTCP] = buf[0:2]
+	h[dstPortTCP] = buf[2:4]
+	h[sequenceNumberTCP] = buf[4:8]
+	h[acknowledgmentNumberTCP] = buf[8:12]
+	h[dataOffsetTCP] = units.Header{buf[12] >> 4}
+	h[flagsTCP] = units.Header{buf[12] & 0x01, buf[13]}
+	h[windowTCP] = buf[14:16]
+	h[checksumTCP] = buf[16:18]
+	h[urgentPointerTCP] = buf[18:20]
+	if headerLength > tcpMinimumHeaderLength {
+		h[optionsTCP] = buf[tcpMinimumHeaderLength:headerLength]
+	}
+
+	return &units.PDU{
+		Headers:  h,
+		Payload:  buf[headerLength:],
+		Protocol: units.TCP,
+	}, nil
+}
+
+// Ports returns the source and destination ports of the segment
+func (p TCPParser) Ports(pdu *units.PDU) (uint16, uint16) {
+	return binary.BigEndian.Uint16(pdu.Headers[srcPortTCP]), binary.BigEndian.Uint16(pdu.Headers[dstPortTCP])
+}
+
+// parseTCPOptions splits the options, the ones after the end of the list or a malformed one are left out
+func parseTCPOptions(buf []byte) (options []tcpOption, malformed bool) {
+	for len(buf) > 0 {
+		kind := buf[0]
+		switch kind {
+		case tcpOptionEnd:
+			return append(options, tcpOption{kind: kind}), false
+		case tcpOptionNOP:
+			options = append(options, tcpOption{kind: kind})
+			buf = buf[1:]
+			continue
+		}
+		if len(buf) < 2 || buf[1] < 2 || int(buf[1]) > len(buf) {
+			return options, true
+		}
+		options = append(options, tcpOption{kind: kind, data: buf[2:buf[1]]})
+		buf = buf[buf[1]:]
+	}
+	return options, false
+}
+
+// String describes the option the way it's meant, e.g. the shift count of window scale along with its multiplier
+func (o tcpOption) String() string {
+	name, hit := tcpOptionNames[o.kind]
+	if !hit {
+		name = fmt.Sprintf("Unknown option %d", o.kind)
+	}
+	value, ok := o.value()
+	if !ok {
+		return fmt.Sprintf("%s (malformed: %x)", name, o.data)
+	}
+	if value == "" {
+		return name
+	}
+	return fmt.Sprintf("%s: %s", name, value)
+}
+
+// value formats the data of the option, it's not ok when its length doesn't fit its kind
+func (o tcpOption) value() (string, bool) {
+	switch o.kind {
+	case tcpOptionEnd, tcpOptionNOP, tcpOptionSACKPermitted:
+		return "", len(o.data) == 0
+	case tcpOptionMSS:
+		if len(o.data) != 2 {
+			return "", false
+		}
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint16(o.data)), 10), true
+	case tcpOptionWindowScale:
+		if len(o.data) != 1 {
+			return "", false
+		}
+		// Shift counts above 14 are taken as 14
+		return fmt.Sprintf("%d (multiply by %d)", o.data[0], 1<<min(o.data[0], 14)), true
+	case tcpOptionSACK:
+		if len(o.data) == 0 || len(o.data)%8 != 0 {
+			return "", false
+		}
+		blocks := make([]string, 0, len(o.data)/8)
+		for i := 0; i < len(o.data); i += 8 {
+			blocks = append(blocks, fmt.Sprintf("%d-%d",
+				binary.BigEndian.Uint32(o.data[i:i+4]), binary.BigEndian.Uint32(o.data[i+4:i+8])))
+		}
+		return strings.Join(blocks, ", "), true
+	case tcpOptionTimestamps:
+		if len(o.data) != 8 {
+			return "", false
+		}
+		return fmt.Sprintf("TSval %d, TSecr %d",
+			binary.BigEndian.Uint32(o.data[0:4]), binary.BigEndian.Uint32(o.data[4:8])), true
+	case tcpOptionFastOpen:
+		return fastOpenCookie(o.data)
+	case tcpOptionExperimental:
+		// RFC 6994 tells experiments apart by an ID, TCP Fast Open used one before it got a kind of its own
+		if len(o.data) < 2 {
+			return "", false
+		}
+		id := binary.BigEndian.Uint16(o.data)
+		if id == tcpFastOpenExperimentID {
+			cookie, ok := fastOpenCookie(o.data[2:])
+			return "TCP Fast Open, " + cookie, ok
+		}
+		return fmt.Sprintf("ID 0x%04x, %x", id, o.data[2:]), true
+	case tcpOptionMPTCP:
+		return mptcpValue(o.data)
+	}
+	return fmt.Sprintf("%x", o.data), true
+}
+
+// fastOpenCookie tells a client asking for a cookie apart from one presenting it, cookies are 4 to 16 bytes long
+func fastOpenCookie(cookie []byte) (string, bool) {
+	if len(cookie) == 0 {
+		return "cookie request", true
+	}
+	return fmt.Sprintf("cookie %x", cookie), len(cookie) >= 4 && len(cookie) <= 16 && len(cookie)%2 == 0
+}
+
+// mptcpValue names the subtype of a Multipath TCP option, in the upper 4 bits of its first byte, and picks the fields
+// of the most common ones
+func mptcpValue(data []byte) (string, bool) {
+	if len(data) == 0 {
+		return "", false
+	}
+	subtype := data[0] >> 4
+	name, hit := mptcpSubtypeNames[subtype]
+	if !hit {
+		name = fmt.Sprintf("subtype %d", subtype)
+	}
+	switch subtype {
+	case 0:
+		// Version in the lower bits, then flags and the keys
+		value := fmt.Sprintf("%s, version %d", name, data[0]&0x0f)
+		if len(data) >= 10 {
+			value += fmt.Sprintf(", sender key 0x%016x", binary.BigEndian.Uint64(data[2:10]))
+		}
+		if len(data) >= 18 {
+			value += fmt.Sprintf(", receiver key 0x%016x", binary.BigEndian.Uint64(data[10:18]))
+		}
+		return value, true
+	case 1:
+		// Only the SYN form, 12 bytes long, carries the token of the connection after the address ID
+		if len(data) == 10 {
+			return fmt.Sprintf("%s, address ID %d, token 0x%08x", name, data[1], binary.BigEndian.Uint32(data[2:6])), true
+		}
+	case 3, 4:
+		if len(data) >= 2 {
+			return fmt.Sprintf("%s, address ID %d", name, data[1]), true
+		}
+	}
+	if len(data) > 1 {
+		return fmt.Sprintf("%s, %x", name, data[1:]), true
+	}
+	return name, true
+}
+
+// flagNames lists the flags that are set, from the most significant bit down
+func (p TCPParser) flagNames(header units.Header) []string {
+	flags := binary.BigEndian.Uint16(header)
+	var names []string
+	for i, name := range tcpFlagNames {
+		if flags&(1<<(len(tcpFlagNames)-1-i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (p TCPParser) HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu *units.PDU) string {
+	header := pdu.Headers[headerKey]
+	switch headerKey {
+	case srcPortTCP, dstPortTCP, windowTCP, urgentPointerTCP:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint16(header)), 10)
+	case sequenceNumberTCP, acknowledgmentNumberTCP:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(header)), 10)
+	case dataOffsetTCP:
+		return fmt.Sprintf("%d bytes", int(header[0])*4)
+	case flagsTCP:
+		return fmt.Sprintf("[%s]", strings.Join(p.flagNames(header), ", "))
+	case checksumTCP:
+		return fmt.Sprintf("0x%04x", binary.BigEndian.Uint16(header))
+	case optionsTCP:
+		options, malformed := parseTCPOptions(header)
+		values := make([]string, 0, len(options)+1)
+		for _, option := range options {
+			// Padding says nothing
+			if option.kind != tcpOptionNOP && option.kind != tcpOptionEnd {
+				values = append(values, option.String())
+			}
+		}
+		if malformed {
+			values = append(values, "malformed")
+		}
+		return strings.Join(values, ", ")
+	}
+	return ""
+}
+
+func (p TCPParser) GetNextProtocol(pdu *units.PDU) units.Protocol {
+	return units.UNKNOWN
+}
+
+func (p TCPParser) MostSignificantHeaders(pdu *units.PDU) []units.PDUHeaderKey {
+	if binary.BigEndian.Uint16(pdu.Headers[flagsTCP])&0x10 == 0 {
+		// Without ACK the acknowledgment number means nothing
+		return []units.PDUHeaderKey{srcPortTCP, dstPortTCP, flagsTCP, sequenceNumberTCP, windowTCP}
+	}
+	return []units.PDUHeaderKey{srcPortTCP, dstPortTCP, flagsTCP, sequenceNumberTCP, acknowledgmentNumberTCP, windowTCP}
+}
+
+func (p TCPParser) HeaderName(header units.PDUHeaderKey) string {
+	return tcpHeaderNames[header]
+}
+
+func (p TCPParser) breakdown(headerKey units.PDUHeaderKey, pdu *units.PDU) PDUBreakdownOutput {
+	h := pdu.Headers[headerKey]
+	return PDUBreakdownOutput{
+		KeyName: tcpHeaderNames[headerKey],
+		Value:   p.HeaderToHumanReadable(headerKey, pdu),
+		Header:  &h,
+	}
+}
+
+func (p TCPParser) flagsBreakdown(pdu *units.PDU) PDUBreakdownOutput {
+	h := pdu.Headers[flagsTCP]
+	flags := binary.BigEndian.Uint16(h)
+	desc := strings.Join(p.flagNames(h), ", ")
+	output := PDUBreakdownOutput{
+		KeyName:     tcpHeaderNames[flagsTCP],
+		Value:       fmt.Sprintf("0x%03x", flags),
+		Header:      &h,
+		Description: &desc,
+	}
+	for i, name := range tcpFlagNames {
+		bit := (flags >> (len(tcpFlagNames) - 1 - i)) & 1
+		output.InnerBreakdowns = append(output.InnerBreakdowns, PDUBreakdownOutput{
+			KeyName: name,
+			Value:   isFlagSet[byte(bit)],
+		})
+	}
+	return output
+}
+
+func (p TCPParser) optionsBreakdown(pdu *units.PDU) PDUBreakdownOutput {
+	h := pdu.Headers[optionsTCP]
+	options, malformed := parseTCPOptions(h)
+	output := PDUBreakdownOutput{
+		KeyName: tcpHeaderNames[optionsTCP],
+		Value:   fmt.Sprintf("%d bytes", len(h)),
+		Header:  &h,
+	}
+	for _, option := range options {
+		name, value := option.String(), ""
+		if before, after, found := strings.Cut(name, ": "); found {
+			name, value = before, after
+		}
+		output.InnerBreakdowns = append(output.InnerBreakdowns, PDUBreakdownOutput{KeyName: name, Value: value})
+	}
+	if malformed {
+		output.InnerBreakdowns = append(output.InnerBreakdowns, PDUBreakdownOutput{KeyName: "Malformed", Value: "the options that follow are left out"})
+	}
+	return output
+}
+
+func (p TCPParser) PDUBreakdown(pdu *units.PDU) []PDUBreakdownOutput {
+	bdo := []PDUBreakdownOutput{
+		p.breakdown(srcPortTCP, pdu),
+		p.breakdown(dstPortTCP, pdu),
+		p.breakdown(sequenceNumberTCP, pdu),
+		p.breakdown(acknowledgmentNumberTCP, pdu),
+		p.breakdown(dataOffsetTCP, pdu),
+		p.flagsBreakdown(pdu),
+		p.breakdown(windowTCP, pdu),
+		p.breakdown(checksumTCP, pdu),
+		p.breakdown(urgentPointerTCP, pdu),
+	}
+	if _, hit := pdu.Headers[optionsTCP]; hit {
+		bdo = append(bdo, p.optionsBreakdown(pdu))
+	}
+	return append(bdo, PDUBreakdownOutput{KeyName: "Payload", Value: fmt.Sprintf("%d bytes", len(pdu.Payload))})
+}
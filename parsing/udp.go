@@ -0,0 +1,133 @@
+package parsing
+
+import (
+	"encoding/binary"
+	"fmt"
+	units "packet_sniffer/model"
+	"strconv"
+)
+
+type UDPParser struct{}
+
+const udpHeaderLength = 8
+
+const (
+	srcPortUDP units.PDUHeaderKey = iota + 2
+	dstPortUDP
+	lengthUDP
+	checksumUDP
+)
+
+var udpHeaderNames = map[units.PDUHeaderKey]string{
+	srcPortUDP:  "Src Port",
+	dstPortUDP:  "Dst Port",
+	lengthUDP:   "Length",
+	checksumUDP: "Checksum",
+}
+
+// udpPortProtocols are the application protocols told by the well-known port of the server
+var udpPortProtocols = map[uint16]units.Protocol{
+	53:   units.DNS,
+	5353: units.DNS, // multicast DNS
+	5355: units.DNS, // LLMNR, the same messages as DNS
+}
+
+func (p UDPParser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < udpHeaderLength {
+		return nil, fmt.Errorf("truncated UDP header: %d bytes", len(buf))
+	}
+	length := int(binary.BigEndian.Uint16(buf[4:6]))
+	if length < udpHeaderLength {
+		return nil, fmt.Errorf("invalid UDP length %d", length)
+	}
+	h := make(map[units.PDUHeaderKey]units.Header, 4)
+	h[srcPortUDP] = buf[0:2]
+	h[dstPortUDP] = buf[2:4]
+	h[lengthUDP] = buf[4:6]
+	h[checksumUDP] = buf[6:8]
+
+	// A length beyond the IP payload means the datagram was cut short, by the snap length most likely, what's there
+	// is still dissected
+	payload := buf[udpHeaderLength:]
+	if length <= len(buf) {
+		payload = buf[udpHeaderLength:length]
+	}
+
+	return &units.PDU{
+		Headers:  h,
+		Payload:  payload,
+		Protocol: units.UDP,
+	}, nil
+}
+
+// Ports returns the source and destination ports of the datagram
+func (p UDPParser) Ports(pdu *units.PDU) (uint16, uint16) {
+	return binary.BigEndian.Uint16(pdu.Headers[srcPortUDP]), binary.BigEndian.Uint16(pdu.Headers[dstPortUDP])
+}
+
+// truncated tells whether the datagram is longer than the IP payload it came in
+func (p UDPParser) truncated(pdu *units.PDU) bool {
+	return int(binary.BigEndian.Uint16(pdu.Headers[lengthUDP])) > udpHeaderLength+len(pdu.Payload)
+}
+
+func (p UDPParser) HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu *units.PDU) string {
+	header := pdu.Headers[headerKey]
+	switch headerKey {
+	case srcPortUDP, dstPortUDP:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint16(header)), 10)
+	case lengthUDP:
+		length := binary.BigEndian.Uint16(header)
+		if p.truncated(pdu) {
+			return fmt.Sprintf("%d, exceeds the %d bytes of the IP payload", length, udpHeaderLength+len(pdu.Payload))
+		}
+		return strconv.FormatUint(uint64(length), 10)
+	case checksumUDP:
+		checksum := binary.BigEndian.Uint16(header)
+		if checksum == 0 {
+			// Optional over IPv4
+			return "0x0000 (none)"
+		}
+		return fmt.Sprintf("0x%04x", checksum)
+	}
+	return ""
+}
+
+// GetNextProtocol picks the application protocol by port, the lower one first since servers usually listen on the
+// well-known port while clients pick an ephemeral one
+func (p UDPParser) GetNextProtocol(pdu *units.PDU) units.Protocol {
+	source, destination := p.Ports(pdu)
+	ports := []uint16{min(source, destination), max(source, destination)}
+	for _, port := range ports {
+		if protocol, hit := udpPortProtocols[port]; hit && len(pdu.Payload) > 0 {
+			return protocol
+		}
+	}
+	return units.UNKNOWN
+}
+
+func (p UDPParser) MostSignificantHeaders(*units.PDU) []units.PDUHeaderKey {
+	return []units.PDUHeaderKey{srcPortUDP, dstPortUDP, lengthUDP}
+}
+
+func (p UDPParser) HeaderName(header units.PDUHeaderKey) string {
+	return udpHeaderNames[header]
+}
+
+func (p UDPParser) breakdown(headerKey units.PDUHeaderKey, pdu *units.PDU) PDUBreakdownOutput {
+	h := pdu.Headers[headerKey]
+	return PDUBreakdownOutput{
+		KeyName: udpHeaderNames[headerKey],
+		Value:   p.HeaderToHumanReadable(headerKey, pdu),
+		Header:  &h,
+	}
+}
+
+func (p UDPParser) PDUBreakdown(pdu *units.PDU) []PDUBreakdownOutput {
+	return []PDUBreakdownOutput{
+		p.breakdown(srcPortUDP, pdu),
+		p.breakdown(dstPortUDP, pdu),
+		p.breakdown(lengthUDP, pdu),
+		p.breakdown(checksumUDP, pdu),
+		{KeyName: "Payload", Value: fmt.Sprintf("%d bytes", len(pdu.Payload))},
+	}
+}
@@ -8,12 +8,30 @@ func ParserFromProtocol(protocol units.Protocol) Parser {
 	switch protocol {
 	case units.ETHERNET:
 		return EthernetParser{}
+	case units.VLAN_TAGGED:
+		return VLANParser{}
 	case units.IPv4:
 		return IPV4Parser{}
+	case units.IPv6:
+		return IPv6Parser{}
 	case units.ARP:
 		return ArpParser{}
 	case units.ICMP:
 		return ICMPParser{}
+	case units.ICMPv6:
+		return ICMPv6Parser{}
+	case units.TCP:
+		return TCPParser{}
+	case units.UDP:
+		return UDPParser{}
+	case units.DNS:
+		return DNSParser{}
+	case units.LINUX_SLL:
+		return SLLParser{}
+	case units.LINUX_SLL2:
+		return SLL2Parser{}
+	case units.NULL_LOOPBACK:
+		return NullParser{}
 
 	default:
 		return nil
@@ -0,0 +1,127 @@
+package parsing
+
+import (
+	"encoding/binary"
+	"fmt"
+	units "packet_sniffer/model"
+	"strconv"
+)
+
+// VLANParser dissects an 802.1Q tag, or an 802.1ad one of a stacked pair. Each tag of a stack is a layer of its own,
+// the innermost one tells the protocol of the payload
+type VLANParser struct{}
+
+const vlanTagLength = 4
+
+const (
+	// The priority, drop eligible indicator and VLAN ID share the tag control information
+	priorityVLAN units.PDUHeaderKey = iota + 2
+	dropEligibleVLAN
+	idVLAN
+	typeVLAN
+)
+
+var vlanHeaderNames = map[units.PDUHeaderKey]string{
+	priorityVLAN:     "Priority",
+	dropEligibleVLAN: "Drop Eligible",
+	idVLAN:           "ID",
+	typeVLAN:         "Type",
+}
+
+// vlanPriorityNames are the traffic types of the priority code points recommended by 802.1Q
+var vlanPriorityNames = map[uint16]string{
+	0: "Best Effort",
+	1: "Background",
+	2: "Excellent Effort",
+	3: "Critical Applications",
+	4: "Video",
+	5: "Voice",
+	6: "Internetwork Control",
+	7: "Network Control",
+}
+
+func (p VLANParser) Parse(buf []byte) (*units.PDU, error) {
+	if len(buf) < vlanTagLength {
+		return nil, fmt.Errorf("truncated VLAN tag: %d bytes", len(buf))
+	}
+	h := make(map[units.PDUHeaderKey]units.Header, 4)
+	h[priorityVLAN] = buf[0:2]
+	h[dropEligibleVLAN] = buf[0:2]
+	h[idVLAN] = buf[0:2]
+	h[typeVLAN] = buf[2:4]
+
+	return &units.PDU{
+		Headers:  h,
+		Payload:  buf[vlanTagLength:],
+		Protocol: units.VLAN_TAGGED,
+	}, nil
+}
+
+// ID returns the VLAN ID of the tag
+func (p VLANParser) ID(pdu *units.PDU) uint16 {
+	return binary.BigEndian.Uint16(pdu.Headers[idVLAN]) & 0x0fff
+}
+
+func (p VLANParser) HeaderToHumanReadable(headerKey units.PDUHeaderKey, pdu *units.PDU) string {
+	header := pdu.Headers[headerKey]
+	switch headerKey {
+	case priorityVLAN:
+		priority := binary.BigEndian.Uint16(header) >> 13
+		return fmt.Sprintf("%d (%s)", priority, vlanPriorityNames[priority])
+	case dropEligibleVLAN:
+		return isFlagSet[header[0]>>4&1]
+	case idVLAN:
+		id := p.ID(pdu)
+		switch id {
+		case 0:
+			// The tag only carries a priority
+			return "0 (none)"
+		case 0x0fff:
+			return "4095 (reserved)"
+		}
+		return strconv.Itoa(int(id))
+	case typeVLAN:
+		return EthernetParser{}.etherTypeName(header)
+	}
+	return ""
+}
+
+func (p VLANParser) GetNextProtocol(pdu *units.PDU) units.Protocol {
+	return EthernetParser{}.ProtocolFromEtherType(pdu.Headers[typeVLAN])
+}
+
+func (p VLANParser) MostSignificantHeaders(*units.PDU) []units.PDUHeaderKey {
+	return []units.PDUHeaderKey{idVLAN, priorityVLAN, typeVLAN}
+}
+
+func (p VLANParser) HeaderName(header units.PDUHeaderKey) string {
+	return vlanHeaderNames[header]
+}
+
+func (p VLANParser) breakdown(headerKey units.PDUHeaderKey, pdu *units.PDU) PDUBreakdownOutput {
+	h := pdu.Headers[headerKey]
+	return PDUBreakdownOutput{
+		KeyName: vlanHeaderNames[headerKey],
+		Value:   p.HeaderToHumanReadable(headerKey, pdu),
+		Header:  &h,
+	}
+}
+
+func (p VLANParser) PDUBreakdown(pdu *units.PDU) []PDUBreakdownOutput {
+	h := pdu.Headers[idVLAN]
+	desc := fmt.Sprintf("%016b", binary.BigEndian.Uint16(h))
+	return []PDUBreakdownOutput{
+		{
+			KeyName:     "Tag Control Information",
+			Value:       fmt.Sprintf("0x%04x", binary.BigEndian.Uint16(h)),
+			Header:      &h,
+			Description: &desc,
+			InnerBreakdowns: []PDUBreakdownOutput{
+				p.breakdown(priorityVLAN, pdu),
+				p.breakdown(dropEligibleVLAN, pdu),
+				p.breakdown(idVLAN, pdu),
+			},
+		},
+		p.breakdown(typeVLAN, pdu),
+	}
+}
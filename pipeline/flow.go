@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	units "packet_sniffer/model"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+	etherTypeQinQ = 0x88a8
+
+	protocolTCP  = 6
+	protocolUDP  = 17
+	protocolSCTP = 132
+)
+
+// networkHeader finds the network header of a frame and its EtherType, along with the MAC addresses if the frame has
+// them. The offset is negative for frames of link types that aren't known
+func networkHeader(data []byte, linkType units.LinkType) (source []byte, destination []byte, etherType uint16, offset int) {
+	switch linkType {
+	case units.LinkTypeEthernet:
+		if len(data) < 14 {
+			return nil, nil, 0, -1
+		}
+		source, destination = data[6:12], data[0:6]
+		etherType, offset = binary.BigEndian.Uint16(data[12:14]), 14
+	case units.LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, nil, 0, -1
+		}
+		etherType, offset = binary.BigEndian.Uint16(data[14:16]), 16
+	case units.LinkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return nil, nil, 0, -1
+		}
+		etherType, offset = binary.BigEndian.Uint16(data[0:2]), 20
+	case units.LinkTypeRaw, units.LinkTypeIPv4, units.LinkTypeIPv6, units.LinkTypeNull:
+		if linkType == units.LinkTypeNull {
+			offset = 4
+		}
+		// The IP version tells the protocol just as well as the address family of BSD loopback headers
+		if len(data) <= offset {
+			return nil, nil, 0, -1
+		}
+		switch data[offset] >> 4 {
+		case 4:
+			etherType = etherTypeIPv4
+		case 6:
+			etherType = etherTypeIPv6
+		}
+	default:
+		return nil, nil, 0, -1
+	}
+	for (etherType == etherTypeVLAN || etherType == etherTypeQinQ) && len(data) >= offset+4 {
+		etherType = binary.BigEndian.Uint16(data[offset+2 : offset+4])
+		offset += 4
+	}
+	return source, destination, etherType, offset
+}
+
+// flowHash hashes the addresses and ports of a frame, the same way for both directions so that a whole conversation
+// gets the same hash. Frames that aren't IP are hashed by their MAC addresses
+func flowHash(data []byte, info units.CaptureInfo) uint32 {
+	source, destination, etherType, offset := networkHeader(data, info.LinkType)
+	if offset < 0 {
+		return 0
+	}
+
+	var protocol byte
+	transport := -1 // offset of the transport header, if it's there to be looked at
+	switch {
+	case etherType == etherTypeIPv4 && len(data) >= offset+20:
+		headerLength := int(data[offset]&0x0f) * 4
+		source, destination = data[offset+12:offset+16], data[offset+16:offset+20]
+		protocol = data[offset+9]
+		// Only the first fragment has the ports, none of them is looked at to keep the fragments together
+		if fragment := binary.BigEndian.Uint16(data[offset+6 : offset+8]); fragment&0x3fff == 0 {
+			transport = offset + headerLength
+		}
+	case etherType == etherTypeIPv6 && len(data) >= offset+40:
+		source, destination = data[offset+8:offset+24], data[offset+24:offset+40]
+		protocol = data[offset+6]
+		transport = offset + 40
+	}
+
+	var sourcePort, destinationPort []byte
+	if (protocol == protocolTCP || protocol == protocolUDP || protocol == protocolSCTP) && transport >= 0 &&
+		len(data) >= transport+4 {
+		sourcePort, destinationPort = data[transport:transport+2], data[transport+2:transport+4]
+	}
+
+	first := append(append([]byte{}, source...), sourcePort...)
+	second := append(append([]byte{}, destination...), destinationPort...)
+	if bytes.Compare(first, second) > 0 {
+		first, second = second, first
+	}
+	hash := fnv.New32a()
+	hash.Write(first)
+	hash.Write(second)
+	hash.Write([]byte{protocol})
+	return hash.Sum32()
+}
@@ -0,0 +1,230 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"packet_sniffer/capture"
+	units "packet_sniffer/model"
+	"packet_sniffer/parsing"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuffer is how many frames can wait between capturing and parsing
+const DefaultBuffer = 1024
+
+// Pipeline moves frames from a capturer through the writer and the parser to the handler. Capturing runs in a goroutine
+// of its own, so that a slow handler doesn't keep the capturer from emptying the kernel's buffers. Parsing is spread
+// over the workers by flow, every frame of a flow is parsed by the same worker, and the parsed frames are handed over
+// in the order they were captured
+type Pipeline struct {
+	Capturer capture.Capturer
+	Writer   capture.PacketWriter       // optional, gets every frame including the ones that fail to parse
+	Handler  func(pdu *units.PDU) error // optional, frames are not parsed at all without one
+	Buffer   int
+	Workers  int // parser workers, one when not set
+	Limits   Limits
+}
+
+// Limits stop a capture once any of them is reached, the ones left at zero don't apply
+type Limits struct {
+	Packets  int
+	Bytes    int64 // captured bytes, the frame that would go over the budget is left out
+	Duration time.Duration
+	FileSize int64 // bytes written by a capture.SizedWriter, the file ends up larger by the last frame written to it
+}
+
+// reached tells whether the capture is over after the given number of frames and bytes
+func (l Limits) reached(packets int, bytes int64, writer capture.PacketWriter) bool {
+	if l.Packets > 0 && packets >= l.Packets {
+		return true
+	}
+	if l.Bytes > 0 && bytes >= l.Bytes {
+		return true
+	}
+	if sized, ok := writer.(capture.SizedWriter); ok && l.FileSize > 0 && sized.Size() >= l.FileSize {
+		return true
+	}
+	return false
+}
+
+type frame struct {
+	data []byte
+	info units.CaptureInfo
+}
+
+type job struct {
+	sequence uint64
+	frame    frame
+}
+
+type result struct {
+	sequence uint64
+	pdu      *units.PDU // nil if the frame couldn't be parsed
+}
+
+// Run captures until the capturer runs out of frames or fails, the handler fails, a limit is reached or the context is
+// done. The frames captured by then are still written and handed over, except for the ones past a limit, and the writer
+// is closed before Run returns. The capturer is left open for its statistics to be read. Running out of frames, limits
+// and being stopped through the context are not errors
+func (p *Pipeline) Run(ctx context.Context) error {
+	buffer := p.Buffer
+	if buffer == 0 {
+		buffer = DefaultBuffer
+	}
+	workers := max(p.Workers, 1)
+	captureCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if p.Limits.Duration > 0 {
+		captureCtx, cancel = context.WithTimeout(captureCtx, p.Limits.Duration)
+		defer cancel()
+	}
+
+	frames := make(chan frame, buffer)
+	var captureErr error
+	go func() {
+		defer close(frames)
+		for {
+			data, info, err := p.Capturer.Capture(captureCtx)
+			if err != nil {
+				captureErr = err
+				return
+			}
+			frames <- frame{data: data, info: info}
+		}
+	}()
+
+	jobs := make([]chan job, workers)
+	results := make(chan result, buffer)
+	var parsers sync.WaitGroup
+	var handlerFailed atomic.Bool
+	var handlerErr error
+	collected := make(chan struct{})
+	if p.Handler != nil {
+		for i := range jobs {
+			jobs[i] = make(chan job, buffer/workers+1)
+			parsers.Add(1)
+			go p.parse(jobs[i], results, &parsers)
+		}
+		go func() {
+			defer close(collected)
+			handlerErr = p.collect(results, func() {
+				handlerFailed.Store(true)
+				cancel()
+			})
+		}()
+	} else {
+		close(collected)
+	}
+
+	var err error
+	var sequence uint64
+	var packets int
+	var bytes int64
+	stopped := false
+	for f := range frames {
+		// Once something failed or a limit was reached the remaining frames are only taken off the channel for the
+		// capturing goroutine to finish
+		if err != nil || stopped || handlerFailed.Load() {
+			continue
+		}
+		if p.Limits.Bytes > 0 && bytes+int64(len(f.data)) > p.Limits.Bytes {
+			stopped = true
+			cancel()
+			continue
+		}
+		if p.Writer != nil {
+			if err = p.Writer.WritePacket(f.info, f.data); err != nil {
+				cancel()
+				continue
+			}
+		}
+		if p.Handler != nil {
+			jobs[flowHash(f.data, f.info)%uint32(workers)] <- job{sequence: sequence, frame: f}
+			sequence++
+		}
+		packets++
+		bytes += int64(len(f.data))
+		if p.Limits.reached(packets, bytes, p.Writer) {
+			stopped = true
+			cancel()
+		}
+	}
+	if p.Handler != nil {
+		for _, c := range jobs {
+			close(c)
+		}
+		parsers.Wait()
+		close(results)
+	}
+	<-collected
+
+	if err == nil {
+		err = handlerErr
+	}
+	if p.Writer != nil {
+		if closeErr := p.Writer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if stopped || captureErr == io.EOF || errors.Is(captureErr, capture.ErrClosed) || captureCtx.Err() != nil {
+		return nil
+	}
+	return captureErr
+}
+
+// parse is run by every worker
+func (p *Pipeline) parse(jobs <-chan job, results chan<- result, done *sync.WaitGroup) {
+	defer done.Done()
+	parser := parsing.CompositeParser{}
+	for j := range jobs {
+		// A frame the parser can't make sense of is still in the file, there is just nothing to show
+		pdu, err := parseFrame(&parser, j.frame)
+		if err != nil {
+			pdu = nil
+		}
+		results <- result{sequence: j.sequence, pdu: pdu}
+	}
+}
+
+// parseFrame parses a frame, a dissector running past the end of a malformed one fails it rather than the capture
+func parseFrame(parser *parsing.CompositeParser, f frame) (pdu *units.PDU, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pdu, err = nil, fmt.Errorf("malformed frame: %v", r)
+		}
+	}()
+	return parser.Parse(f.data, f.info)
+}
+
+// collect puts the parsed frames back into the order they were captured in and hands them over. After the handler
+// failed the remaining results are only drained
+func (p *Pipeline) collect(results <-chan result, fail func()) error {
+	pending := make(map[uint64]*units.PDU)
+	var next uint64
+	var err error
+	for r := range results {
+		pending[r.sequence] = r.pdu
+		for {
+			pdu, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if err != nil || pdu == nil {
+				continue
+			}
+			if err = p.Handler(pdu); err != nil {
+				fail()
+			}
+		}
+	}
+	return err
+}
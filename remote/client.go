@@ -0,0 +1,176 @@
+package remote
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"packet_sniffer/capture"
+	units "packet_sniffer/model"
+	"sync"
+	"time"
+)
+
+// Client is a capturer of the frames a Server captures for it. The filter is sent to the server when connecting and
+// applied there, Capture returns io.EOF once the server's source runs out of frames
+type Client struct {
+	Address string
+	Filter  string
+	TLS     *tls.Config // plain TCP when nil
+
+	conn      net.Conn
+	linkType  units.LinkType
+	snapLen   int
+	frames    chan receivedFrame
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu    sync.Mutex
+	stats capture.Stats
+}
+
+type receivedFrame struct {
+	data []byte
+	info units.CaptureInfo
+	err  error
+}
+
+// Init connects to the server and negotiates the filter, an error is returned if the server can't capture with it
+func (c *Client) Init() error {
+	address := withDefaultPort(c.Address)
+	dialer := &net.Dialer{Timeout: handshakeTimeout}
+	var err error
+	if c.TLS != nil {
+		c.conn, err = tls.DialWithDialer(dialer, "tcp", address, c.TLS)
+	} else {
+		c.conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(c.conn, 64*1024)
+	if err := c.handshake(reader); err != nil {
+		c.conn.Close()
+		return fmt.Errorf("%s: %v", address, err)
+	}
+	c.frames = make(chan receivedFrame, 1024)
+	c.done = make(chan struct{})
+	go c.receive(reader)
+	return nil
+}
+
+func (c *Client) handshake(reader *bufio.Reader) error {
+	c.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := writeMessage(c.conn, messageHello, encodeHello(c.Filter)); err != nil {
+		return err
+	}
+	reply, err := readMessage(reader)
+	if err == io.EOF && c.TLS == nil {
+		return errors.New("the server hung up, it may expect TLS")
+	}
+	if err != nil {
+		return err
+	}
+	switch reply.kind {
+	case messageAccept:
+	case messageError:
+		return errors.New(string(reply.payload))
+	default:
+		return fmt.Errorf("expected an accept, got a message of type %q", reply.kind)
+	}
+	if c.linkType, c.snapLen, err = decodeAccept(reply.payload); err != nil {
+		return err
+	}
+	return c.conn.SetDeadline(time.Time{})
+}
+
+// receive reads the messages of the server until the connection ends, the frames are queued for Capture
+func (c *Client) receive(reader *bufio.Reader) {
+	for {
+		m, err := readMessage(reader)
+		frame := receivedFrame{err: err}
+		if err == nil {
+			switch m.kind {
+			case messageFrame:
+				frame.data, frame.info, frame.err = decodeFrame(m.payload, c.linkType)
+			case messageStats:
+				stats, err := decodeStats(m.payload)
+				if err == nil {
+					c.mu.Lock()
+					c.stats = stats
+					c.mu.Unlock()
+					continue
+				}
+				frame.err = err
+			case messageEnd:
+				frame.err = io.EOF
+			case messageError:
+				frame.err = fmt.Errorf("remote capture failed: %s", m.payload)
+			default:
+				frame.err = fmt.Errorf("unexpected message of type %q", m.kind)
+			}
+		} else if err == io.EOF {
+			// The server always says why it stops
+			frame.err = io.ErrUnexpectedEOF
+		}
+		select {
+		case c.frames <- frame:
+		case <-c.done:
+			return
+		}
+		if frame.err != nil {
+			return
+		}
+	}
+}
+
+func (c *Client) Capture(ctx context.Context) ([]byte, units.CaptureInfo, error) {
+	select {
+	case frame := <-c.frames:
+		if frame.err != nil {
+			// Later calls get the same error
+			c.frames <- frame
+		}
+		return frame.data, frame.info, frame.err
+	case <-ctx.Done():
+		return nil, units.CaptureInfo{}, ctx.Err()
+	case <-c.done:
+		return nil, units.CaptureInfo{}, capture.ErrClosed
+	}
+}
+
+func (c *Client) LinkType() units.LinkType {
+	return c.linkType
+}
+
+func (c *Client) SnapLen() int {
+	return c.snapLen
+}
+
+// Stats returns the statistics of the server's capture, as of the last time it sent them
+func (c *Client) Stats() (capture.Stats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats, nil
+}
+
+// Close disconnects from the server, which stops capturing
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// withDefaultPort adds DefaultPort to addresses that don't have a port
+func withDefaultPort(address string) string {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return net.JoinHostPort(address, DefaultPort)
+	}
+	return address
+}
@@ -0,0 +1,177 @@
+// Package remote streams captured frames over TCP, so that a capture running on a headless host can be watched from
+// another one.
+//
+// The protocol is made of messages, each one a type byte and the length of its payload as a 32-bit integer followed by
+// the payload. Integers are big endian. The client starts with a hello and the server answers with an accept, or with
+// an error and closes the connection:
+//
+//	hello   'H'  magic "PKTS", version (1 byte), capture filter expression (the rest, may be empty)
+//	accept  'A'  version (1 byte), link type (4 bytes), snap length (4 bytes)
+//	error   'E'  reason, as text
+//
+// The filter is compiled by the server for the link type of its source, its own filter is and'ed with it, so only
+// the matching frames cross the network. From then on the server sends the frames as they are captured, and the
+// statistics of its capture every second:
+//
+//	frame   'F'  timestamp in nanoseconds since the epoch (8 bytes), length on the wire (4 bytes), packet type
+//	             (1 byte), interface index (4 bytes), length of the interface name (1 byte), interface name,
+//	             captured bytes (the rest)
+//	stats   'S'  received, dropped and filtered frames and times the ring was full (8 bytes each)
+//	end     'Z'  the source ran out of frames, no payload
+//	error   'E'  the capture failed, the reason as text
+//
+// The connection is closed after an end or an error. The client never sends anything after its hello, closing the
+// connection stops the capture.
+package remote
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"packet_sniffer/capture"
+	units "packet_sniffer/model"
+	"time"
+)
+
+// DefaultPort is the port served on when the address doesn't have one
+const DefaultPort = "2021"
+
+const (
+	version = 1
+	magic   = "PKTS"
+
+	messageHello  = 'H'
+	messageAccept = 'A'
+	messageError  = 'E'
+	messageFrame  = 'F'
+	messageStats  = 'S'
+	messageEnd    = 'Z'
+
+	messageHeaderLength = 5
+	frameHeaderLength   = 18
+	statsLength         = 32
+	// maxMessageLength bounds what a peer can make the other side allocate, frames are far smaller
+	maxMessageLength = 1 << 24
+)
+
+type message struct {
+	kind    byte
+	payload []byte
+}
+
+func writeMessage(w io.Writer, kind byte, payload []byte) error {
+	header := make([]byte, messageHeaderLength, messageHeaderLength+len(payload))
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:5], uint32(len(payload)))
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+func readMessage(r *bufio.Reader) (message, error) {
+	header := make([]byte, messageHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return message{}, err
+	}
+	length := binary.BigEndian.Uint32(header[1:5])
+	if length > maxMessageLength {
+		return message{}, fmt.Errorf("message of %d bytes is too long", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return message{}, err
+	}
+	return message{kind: header[0], payload: payload}, nil
+}
+
+func encodeHello(filter string) []byte {
+	payload := append([]byte(magic), version)
+	return append(payload, filter...)
+}
+
+func decodeHello(payload []byte) (string, error) {
+	if len(payload) < len(magic)+1 || string(payload[:len(magic)]) != magic {
+		return "", errors.New("not a remote capture client")
+	}
+	if payload[len(magic)] != version {
+		return "", fmt.Errorf("unsupported protocol version %d, expected %d", payload[len(magic)], version)
+	}
+	return string(payload[len(magic)+1:]), nil
+}
+
+func encodeAccept(linkType units.LinkType, snapLen int) []byte {
+	payload := []byte{version}
+	payload = binary.BigEndian.AppendUint32(payload, uint32(linkType))
+	return binary.BigEndian.AppendUint32(payload, uint32(snapLen))
+}
+
+func decodeAccept(payload []byte) (units.LinkType, int, error) {
+	if len(payload) != 9 {
+		return 0, 0, fmt.Errorf("malformed accept message of %d bytes", len(payload))
+	}
+	if payload[0] != version {
+		return 0, 0, fmt.Errorf("unsupported protocol version %d, expected %d", payload[0], version)
+	}
+	return units.LinkType(binary.BigEndian.Uint32(payload[1:5])), int(binary.BigEndian.Uint32(payload[5:9])), nil
+}
+
+func encodeFrame(info units.CaptureInfo, data []byte) []byte {
+	name := info.InterfaceName
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	payload := make([]byte, 0, frameHeaderLength+len(name)+len(data))
+	payload = binary.BigEndian.AppendUint64(payload, uint64(info.Timestamp.UnixNano()))
+	payload = binary.BigEndian.AppendUint32(payload, uint32(info.Length))
+	payload = append(payload, byte(info.PacketType))
+	payload = binary.BigEndian.AppendUint32(payload, uint32(info.InterfaceIndex))
+	payload = append(payload, byte(len(name)))
+	payload = append(payload, name...)
+	return append(payload, data...)
+}
+
+// decodeFrame returns the captured bytes as a slice of the payload
+func decodeFrame(payload []byte, linkType units.LinkType) ([]byte, units.CaptureInfo, error) {
+	if len(payload) < frameHeaderLength {
+		return nil, units.CaptureInfo{}, fmt.Errorf("malformed frame message of %d bytes", len(payload))
+	}
+	nameLength := int(payload[17])
+	if len(payload) < frameHeaderLength+nameLength {
+		return nil, units.CaptureInfo{}, fmt.Errorf("malformed frame message of %d bytes", len(payload))
+	}
+	data := payload[frameHeaderLength+nameLength:]
+	info := units.CaptureInfo{
+		Timestamp:      time.Unix(0, int64(binary.BigEndian.Uint64(payload[0:8]))),
+		CaptureLength:  len(data),
+		Length:         int(binary.BigEndian.Uint32(payload[8:12])),
+		LinkType:       linkType,
+		PacketType:     units.PacketType(payload[12]),
+		InterfaceIndex: int(binary.BigEndian.Uint32(payload[13:17])),
+		InterfaceName:  string(payload[frameHeaderLength : frameHeaderLength+nameLength]),
+	}
+	return data, info, nil
+}
+
+func encodeStats(stats capture.Stats) []byte {
+	payload := make([]byte, 0, statsLength)
+	for _, counter := range []uint64{stats.Received, stats.Dropped, stats.Filtered, stats.FreezeQueueCount} {
+		payload = binary.BigEndian.AppendUint64(payload, counter)
+	}
+	return payload
+}
+
+func decodeStats(payload []byte) (capture.Stats, error) {
+	if len(payload) != statsLength {
+		return capture.Stats{}, fmt.Errorf("malformed stats message of %d bytes", len(payload))
+	}
+	return capture.Stats{
+		Received:         binary.BigEndian.Uint64(payload[0:8]),
+		Dropped:          binary.BigEndian.Uint64(payload[8:16]),
+		Filtered:         binary.BigEndian.Uint64(payload[16:24]),
+		FreezeQueueCount: binary.BigEndian.Uint64(payload[24:32]),
+	}, nil
+}
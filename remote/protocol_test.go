@@ -0,0 +1,173 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"packet_sniffer/capture"
+	units "packet_sniffer/model"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessages(t *testing.T) {
+	messages := []message{
+		{kind: messageHello, payload: encodeHello("tcp port 80")},
+		{kind: messageEnd, payload: []byte{}},
+		{kind: messageFrame, payload: bytes.Repeat([]byte{0xab}, 70000)},
+		{kind: messageError, payload: []byte("capture failed")},
+	}
+	var stream bytes.Buffer
+	for _, m := range messages {
+		if err := writeMessage(&stream, m.kind, m.payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := bufio.NewReader(&stream)
+	for i, want := range messages {
+		got, err := readMessage(r)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("message %d: got %c with %d bytes, want %c with %d bytes", i, got.kind, len(got.payload), want.kind, len(want.payload))
+		}
+	}
+	if _, err := readMessage(r); err != io.EOF {
+		t.Errorf("got %v after the last message, want io.EOF", err)
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream []byte
+		want   string
+	}{
+		{"truncated header", []byte{messageFrame, 0, 0}, io.ErrUnexpectedEOF.Error()},
+		{"truncated payload", []byte{messageFrame, 0, 0, 0, 4, 1, 2}, io.ErrUnexpectedEOF.Error()},
+		{"missing payload", []byte{messageFrame, 0, 0, 0, 4}, io.ErrUnexpectedEOF.Error()},
+		{"too long", []byte{messageFrame, 0x01, 0x00, 0x00, 0x01}, "message of 16777217 bytes is too long"},
+	}
+	for _, test := range tests {
+		_, err := readMessage(bufio.NewReader(bytes.NewReader(test.stream)))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error about %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestHello(t *testing.T) {
+	for _, filter := range []string{"", "tcp port 80", "vlan 100 and (udp or icmp)"} {
+		got, err := decodeHello(encodeHello(filter))
+		if err != nil || got != filter {
+			t.Errorf("%q: got %q, %v", filter, got, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    string
+	}{
+		{"empty", nil, "not a remote capture client"},
+		{"magic only", []byte(magic), "not a remote capture client"},
+		{"bad magic", append([]byte("HTTP"), version), "not a remote capture client"},
+		{"bad version", append([]byte(magic), version+1, 't', 'c', 'p'), "unsupported protocol version 2, expected 1"},
+	}
+	for _, test := range tests {
+		if _, err := decodeHello(test.payload); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error about %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestAccept(t *testing.T) {
+	linkType, snapLen, err := decodeAccept(encodeAccept(units.LinkTypeLinuxSLL2, 262144))
+	if err != nil || linkType != units.LinkTypeLinuxSLL2 || snapLen != 262144 {
+		t.Errorf("got %d, %d, %v", linkType, snapLen, err)
+	}
+
+	payload := encodeAccept(units.LinkTypeEthernet, 65535)
+	payload[0] = version + 1
+	for name, payload := range map[string][]byte{"short": payload[:8], "long": append(payload, 0), "bad version": payload} {
+		if _, _, err := decodeAccept(payload); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+func TestFrame(t *testing.T) {
+	tests := []struct {
+		name string
+		info units.CaptureInfo
+		data []byte
+		// wantName is the interface name read back, longer names are cut to what fits its length byte
+		wantName string
+	}{
+		{
+			name: "received frame",
+			info: units.CaptureInfo{Timestamp: time.Unix(1700000000, 123456789), Length: 1514, PacketType: units.PacketTypeHost,
+				InterfaceIndex: 2, InterfaceName: "eth0"},
+			data: []byte{1, 2, 3, 4, 5}, wantName: "eth0",
+		},
+		{
+			name: "outgoing frame without an interface",
+			info: units.CaptureInfo{Timestamp: time.Unix(0, 1), Length: 60, PacketType: units.PacketTypeOutgoing},
+			data: make([]byte, 60),
+		},
+		{
+			name: "empty frame",
+			info: units.CaptureInfo{Timestamp: time.Unix(1700000000, 0), InterfaceIndex: 1, InterfaceName: "lo"},
+			data: []byte{}, wantName: "lo",
+		},
+		{
+			name: "long interface name",
+			info: units.CaptureInfo{Timestamp: time.Unix(1700000000, 0), Length: 1, InterfaceIndex: 0x7fffffff,
+				InterfaceName: strings.Repeat("x", 300)},
+			data: []byte{0xff}, wantName: strings.Repeat("x", 255),
+		},
+	}
+	for _, test := range tests {
+		data, info, err := decodeFrame(encodeFrame(test.info, test.data), units.LinkTypeEthernet)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(data, test.data) {
+			t.Errorf("%s: got data % x", test.name, data)
+		}
+		want := test.info
+		want.CaptureLength = len(test.data)
+		want.LinkType = units.LinkTypeEthernet
+		want.InterfaceName = test.wantName
+		if !info.Timestamp.Equal(want.Timestamp) {
+			t.Errorf("%s: got timestamp %v, want %v", test.name, info.Timestamp, want.Timestamp)
+		}
+		info.Timestamp, want.Timestamp = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(info, want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, info, want)
+		}
+	}
+
+	// The name length can't point past the payload
+	payload := encodeFrame(units.CaptureInfo{InterfaceName: "eth0"}, nil)
+	for _, malformed := range [][]byte{payload[:frameHeaderLength-1], payload[:frameHeaderLength+3]} {
+		if _, _, err := decodeFrame(malformed, units.LinkTypeEthernet); err == nil {
+			t.Errorf("%d bytes: got no error", len(malformed))
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	stats := capture.Stats{Received: 1, Dropped: 1 << 40, Filtered: 3, FreezeQueueCount: 1<<64 - 1}
+	got, err := decodeStats(encodeStats(stats))
+	if err != nil || got != stats {
+		t.Errorf("got %+v, %v", got, err)
+	}
+	if _, err := decodeStats(make([]byte, statsLength-1)); err == nil {
+		t.Errorf("got no error for a short payload")
+	}
+}
@@ -0,0 +1,203 @@
+package remote
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"packet_sniffer/capture"
+	units "packet_sniffer/model"
+	"packet_sniffer/pipeline"
+	"sync"
+	"time"
+)
+
+const (
+	// handshakeTimeout is how long a client has to say hello, the TLS handshake included
+	handshakeTimeout = 10 * time.Second
+	// flushInterval bounds how long a frame waits in the buffer before it's sent
+	flushInterval = 100 * time.Millisecond
+	statsInterval = time.Second
+)
+
+// Source opens a capture for a client, the filter is the expression the client asked for. The snap length is the one
+// of the frames the capturer hands over
+type Source func(filter string) (capture.Capturer, units.LinkType, int, error)
+
+// Server runs a capture of its own for every client that connects to the listener, and streams it the frames
+type Server struct {
+	Listener net.Listener
+	Open     Source
+	Logf     func(format string, args ...any) // told about clients coming and going, optional
+}
+
+// Serve accepts clients until the context is done, which is not an error. The captures of the clients are stopped
+// before it returns
+func (s *Server) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		s.Listener.Close()
+	})
+	defer stop()
+	var clients sync.WaitGroup
+	defer clients.Wait()
+	for {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			err := s.handle(ctx, conn)
+			if err != nil {
+				s.logf("%s: %v", conn.RemoteAddr(), err)
+			} else {
+				s.logf("%s: disconnected", conn.RemoteAddr())
+			}
+		}()
+	}
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// handle negotiates the filter with a client and streams it the frames until either side stops
+func (s *Server) handle(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	reader := bufio.NewReader(conn)
+	hello, err := readMessage(reader)
+	if err != nil {
+		return fmt.Errorf("no hello: %v", err)
+	}
+	if hello.kind != messageHello {
+		return fmt.Errorf("expected a hello, got a message of type %q", hello.kind)
+	}
+	expression, err := decodeHello(hello.payload)
+	if err != nil {
+		writeMessage(conn, messageError, []byte(err.Error()))
+		return err
+	}
+	capturer, linkType, snapLen, err := s.Open(expression)
+	if err != nil {
+		writeMessage(conn, messageError, []byte(err.Error()))
+		return err
+	}
+	defer capturer.Close()
+	if err := writeMessage(conn, messageAccept, encodeAccept(linkType, snapLen)); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	s.logf("%s: connected, filter %q", conn.RemoteAddr(), expression)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The client doesn't send anything else, reading only tells when it has gone away. Closing the connection also
+	// unblocks a write to a client that stopped reading
+	go func() {
+		reader.ReadByte()
+		cancel()
+	}()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	stream := &streamWriter{buffered: bufio.NewWriterSize(conn, 64*1024)}
+	tickerCtx, stopTicker := context.WithCancel(ctx)
+	ticked := make(chan struct{})
+	go func() {
+		defer close(ticked)
+		stream.tick(tickerCtx, capturer)
+	}()
+	p := pipeline.Pipeline{Capturer: capturer, Writer: stream}
+	err = p.Run(ctx)
+	stopTicker()
+	<-ticked
+	if ctx.Err() != nil {
+		// Gone, or being shut down, either way there's no one to tell
+		return nil
+	}
+	// The final statistics account for every frame sent
+	if reporter, ok := capturer.(capture.StatsReporter); ok {
+		if stats, statsErr := reporter.Stats(); statsErr == nil {
+			stream.send(messageStats, encodeStats(stats))
+		}
+	}
+	if err != nil {
+		stream.send(messageError, []byte(err.Error()))
+		return err
+	}
+	return stream.send(messageEnd, nil)
+}
+
+// streamWriter sends the frames the pipeline writes to the client. They are buffered and flushed every
+// flushInterval along with the statistics of the capture
+type streamWriter struct {
+	mu       sync.Mutex
+	buffered *bufio.Writer
+}
+
+func (w *streamWriter) WritePacket(info units.CaptureInfo, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return writeMessage(w.buffered, messageFrame, encodeFrame(info, data))
+}
+
+// send writes a message right away, along with whatever was buffered
+func (w *streamWriter) send(kind byte, payload []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := writeMessage(w.buffered, kind, payload); err != nil {
+		return err
+	}
+	return w.buffered.Flush()
+}
+
+// tick flushes the frames and sends the statistics of the capturer until the context is done
+func (w *streamWriter) tick(ctx context.Context, capturer capture.Capturer) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var lastStats time.Time
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		reporter, ok := capturer.(capture.StatsReporter)
+		if ok && time.Since(lastStats) >= statsInterval {
+			lastStats = time.Now()
+			if stats, err := reporter.Stats(); err == nil {
+				if err := w.send(messageStats, encodeStats(stats)); err != nil {
+					return
+				}
+				continue
+			}
+		}
+		w.mu.Lock()
+		err := w.buffered.Flush()
+		w.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Close flushes the frames left in the buffer, the connection is closed by the server
+func (w *streamWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.buffered.Flush()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
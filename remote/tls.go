@@ -0,0 +1,89 @@
+package remote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// Listen listens for clients on the address, DefaultPort is used when it doesn't have one. Clients are expected to
+// speak TLS if a configuration is given
+func Listen(address string, config *tls.Config) (net.Listener, error) {
+	listener, err := net.Listen("tcp", withDefaultPort(address))
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+	return listener, nil
+}
+
+// IsLoopback tells whether the address only takes connections from the host itself. An address without a host is
+// every one of the host's
+func IsLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(withDefaultPort(address))
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServerTLS loads the certificate a server presents to its clients along with its private key, both PEM encoded. With
+// a client CA file, clients have to present a certificate issued by one of its PEM encoded certificates
+func ServerTLS(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the TLS certificate: %v", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		if config.ClientCAs, err = loadCertPool(clientCAFile); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientTLS makes a client verify the server against the PEM encoded certificates of the CA file, e.g. the server's
+// own self-signed certificate, or against the system's roots when there is no file. The client presents the
+// certificate of the cert file to servers that ask for one
+func ClientTLS(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the TLS certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	if caFile == "" {
+		return config, nil
+	}
+	var err error
+	if config.RootCAs, err = loadCertPool(caFile); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// loadCertPool reads the PEM encoded certificates of a file
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New(file + ": no PEM encoded certificate")
+	}
+	return pool, nil
+}
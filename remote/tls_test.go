@@ -0,0 +1,24 @@
+package remote
+
+import "testing"
+
+func TestIsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"":                 false,
+		":2021":            false,
+		"0.0.0.0:2021":     false,
+		"[::]:2021":        false,
+		"192.168.1.10":     false,
+		"example.com:2021": false,
+		"127.0.0.1:2021":   true,
+		"127.0.0.1":        true,
+		"127.1.2.3:2021":   true,
+		"[::1]:2021":       true,
+		"localhost":        true,
+	}
+	for address, want := range tests {
+		if got := IsLoopback(address); got != want {
+			t.Errorf("%q: got %t, want %t", address, got, want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package render
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"packet_sniffer/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var interfaceListColumns = []string{"", "Interface:", "State:", "MTU:", "MAC:", "Packets/s:", "Addresses:"}
+
+const (
+	// sparklineLength is the number of packet rates shown for every interface, one per refresh
+	sparklineLength = 20
+	refreshInterval = time.Second
+)
+
+var sparklineLevels = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws the values as bars scaled to the largest one
+func sparkline(values []float64) string {
+	largest := 0.0
+	for _, value := range values {
+		largest = max(largest, value)
+	}
+	var line strings.Builder
+	for _, value := range values {
+		level := 0
+		if largest > 0 {
+			level = int(value / largest * float64(len(sparklineLevels)-1))
+		}
+		line.WriteRune(sparklineLevels[level])
+	}
+	return line.String()
+}
+
+// interfaceList shows the interfaces to pick from along with their state, their addresses and a sparkline of their
+// packet rate. The interfaces are listed again on every refresh, so that the ones that come and go show up
+type interfaceList struct {
+	table    *tview.Table
+	selected map[string]bool
+	rows     []string // names of the interfaces by row, the header left out
+	rates    map[string][]float64
+	counters map[string]utils.InterfaceCounters
+	listed   time.Time
+}
+
+func newInterfaceList() *interfaceList {
+	l := &interfaceList{
+		table:    tview.NewTable(),
+		selected: make(map[string]bool),
+		rates:    make(map[string][]float64),
+		counters: make(map[string]utils.InterfaceCounters),
+	}
+	l.table.SetFixed(1, 0)
+	l.table.SetBorder(true).SetBorderColor(tcell.ColorLightSeaGreen)
+	l.table.SetTitle("Select network interfaces").SetTitleColor(tcell.ColorLightSeaGreen)
+	l.table.SetSelectable(true, false)
+	l.table.SetSelectedFunc(func(row, column int) {
+		l.toggle(row)
+	})
+	l.table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyRune && event.Rune() == ' ' {
+			row, _ := l.table.GetSelection()
+			l.toggle(row)
+			return nil
+		}
+		return event
+	})
+	for i, column := range interfaceListColumns {
+		l.table.SetCell(0, i, tview.NewTableCell(column).SetTextColor(tcell.ColorYellow).SetSelectable(false))
+	}
+	return l
+}
+
+func (l *interfaceList) toggle(row int) {
+	if row < 1 || row > len(l.rows) {
+		return
+	}
+	name := l.rows[row-1]
+	l.selected[name] = !l.selected[name]
+	l.table.GetCell(row, 0).SetText(checkbox(l.selected[name]))
+}
+
+func checkbox(checked bool) string {
+	if checked {
+		return "[x]"
+	}
+	return "[ ]"
+}
+
+// chosen returns the selected interfaces in the order they are listed
+func (l *interfaceList) chosen() []string {
+	var chosen []string
+	for _, name := range l.rows {
+		if l.selected[name] {
+			chosen = append(chosen, name)
+		}
+	}
+	return chosen
+}
+
+// update shows the interfaces as they are now, the packet rates are worked out from the counters of the last update
+func (l *interfaceList) update(ifaces []utils.NetworkInterface, now time.Time) {
+	elapsed := now.Sub(l.listed).Seconds()
+	rates := make(map[string][]float64, len(ifaces))
+	counters := make(map[string]utils.InterfaceCounters, len(ifaces))
+	for _, iface := range ifaces {
+		current := iface.Counters
+		history := l.rates[iface.Name]
+		if previous, hit := l.counters[iface.Name]; hit && elapsed > 0 {
+			// Counters go back to zero when an interface is created again under the same name
+			packets := float64(current.RxPackets+current.TxPackets) - float64(previous.RxPackets+previous.TxPackets)
+			history = append(history, max(packets, 0)/elapsed)
+			if len(history) > sparklineLength {
+				history = history[len(history)-sparklineLength:]
+			}
+		}
+		rates[iface.Name] = history
+		counters[iface.Name] = current
+	}
+	l.rates, l.counters, l.listed = rates, counters, now
+
+	selectedRow, _ := l.table.GetSelection()
+	selectedName := ""
+	if selectedRow >= 1 && selectedRow <= len(l.rows) {
+		selectedName = l.rows[selectedRow-1]
+	}
+	for row := l.table.GetRowCount() - 1; row > 0; row-- {
+		l.table.RemoveRow(row)
+	}
+	l.rows = l.rows[:0]
+	for i, iface := range ifaces {
+		row := i + 1
+		l.rows = append(l.rows, iface.Name)
+		history := rates[iface.Name]
+		rate := ""
+		if len(history) > 0 {
+			rate = fmt.Sprintf("%s %.0f", sparkline(history), history[len(history)-1])
+		}
+		stateColor := tcell.ColorLightGreen
+		if iface.State() != "up" {
+			stateColor = tcell.ColorRed
+		}
+		mtu := ""
+		if iface.MTU > 0 {
+			mtu = strconv.Itoa(iface.MTU)
+		}
+		cells := []*tview.TableCell{
+			tview.NewTableCell(checkbox(l.selected[iface.Name])),
+			tview.NewTableCell(iface.Name).SetTextColor(tcell.ColorLightSeaGreen),
+			tview.NewTableCell(iface.State()).SetTextColor(stateColor),
+			tview.NewTableCell(mtu),
+			tview.NewTableCell(iface.HardwareAddr.String()),
+			tview.NewTableCell(rate),
+			tview.NewTableCell(strings.Join(iface.AddressStrings(), " ")).SetExpansion(1),
+		}
+		for column, cell := range cells {
+			l.table.SetCell(row, column, cell)
+		}
+		// A click on the checkbox toggles it, the row gets selected either way
+		l.table.GetCell(row, 0).SetClickedFunc(func() bool {
+			l.toggle(row)
+			return false
+		})
+		if iface.Name == selectedName {
+			l.table.Select(row, 0)
+		}
+	}
+	if selectedName == "" && len(l.rows) > 0 {
+		l.table.Select(1, 0)
+	}
+}
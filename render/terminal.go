@@ -6,16 +6,22 @@ import (
 	"github.com/rivo/tview"
 	units "packet_sniffer/model"
 	"packet_sniffer/parsing"
+	"packet_sniffer/utils"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-var packetListColumns = []string{"#", "Time:", "Source:", "Destination:", "Protocol:", "Size:"}
+var packetListColumns = []string{"#", "Time:", "Interface:", "VLAN:", "Source:", "Destination:", "Protocol:", "Size:", "Direction:"}
+
+// packetListProtocolColumn is highlighted in every row
+const packetListProtocolColumn = 6
 
 type PacketListPane struct {
 	Application *tview.Application
 	table       *tview.Table
+	title       string
 	rowToPDU    *map[int]*units.PDU
 	PDUHandover func(pdu *units.PDU)
 }
@@ -24,14 +30,15 @@ func (p *PacketListPane) Primitive() *tview.Table {
 	return p.table
 }
 
-func (p *PacketListPane) Init(iface string) {
+func (p *PacketListPane) Init(title string) {
 	rowToPDU := make(map[int]*units.PDU, 1000)
 	p.rowToPDU = &rowToPDU
 
 	p.table = tview.NewTable()
 	p.table.SetFixed(1, 1)
 	p.table.SetBorders(false).SetBorder(true).SetBorderColor(tcell.ColorLightSeaGreen)
-	p.table.SetTitle(fmt.Sprintf("Network interface: %s", iface)).SetTitleColor(tcell.ColorLightSeaGreen)
+	p.title = title
+	p.table.SetTitle(title).SetTitleColor(tcell.ColorLightSeaGreen)
 	p.table.SetSelectable(true, false)
 
 	p.table.SetSelectionChangedFunc(func(row, column int) {
@@ -39,50 +46,47 @@ func (p *PacketListPane) Init(iface string) {
 		p.PDUHandover(pdu)
 	})
 
-	go p.Application.QueueUpdateDraw(func() { p.AddRow(packetListColumns...) })
-
+	// The header has to be in place before any PDU row is queued, otherwise a PDU may end up being the header
+	p.AddRow(packetListColumns...)
 }
 
 func (p *PacketListPane) AddPDU(pdu *units.PDU) {
-	var source string
-	var dest string
-	var protocol string
 	var length string
 
-	currentPDU := pdu
-	for currentPDU != nil {
-		if currentPDU.NextPDU == nil {
-			protocol = units.ProtocolStringMap[currentPDU.Protocol].Shortened
-			length = strconv.FormatInt(int64(len(currentPDU.Payload)), 10)
-		}
-		parser := parsing.ParserFromProtocol(currentPDU.Protocol)
-
-		_, hit := currentPDU.Headers[parsing.SRCHeader]
-		if hit == true {
-			source = parser.HeaderToHumanReadable(parsing.SRCHeader, currentPDU)
-		}
-		_, hit = currentPDU.Headers[parsing.DSTHeader]
-		if hit == true {
-			dest = parser.HeaderToHumanReadable(parsing.DSTHeader, currentPDU)
-		}
-		currentPDU = currentPDU.NextPDU
+	info := pdu.CaptureInfo
+	timestamp := info.Timestamp.Format("2006-01-02 15:04:05.000000")
+	length = strconv.FormatInt(int64(info.Length), 10)
+	direction := units.PacketTypeStringMap[info.PacketType]
+	iface := info.InterfaceName
+	if iface == "" {
+		iface = strconv.Itoa(info.InterfaceIndex)
 	}
+
+	source, dest, protocol := utils.PDUAddresses(pdu)
+	vlans := utils.PDUVLANs(pdu)
 	go func() {
 		p.Application.QueueUpdateDraw(func() {
 			rowToPDU := *p.rowToPDU
 			rowToPDU[p.table.GetRowCount()] = pdu
-			p.AddRow(source, dest, protocol, length)
+			p.AddRow(timestamp, iface, vlans, source, dest, protocol, length, direction)
 		})
 	}()
 }
 
+// SetStatus shows a status, such as capture statistics, after the title
+func (p *PacketListPane) SetStatus(status string) {
+	p.Application.QueueUpdateDraw(func() {
+		p.table.SetTitle(fmt.Sprintf("%s | %s", p.title, status))
+	})
+}
+
 func (p *PacketListPane) AddRow(initialValues ...string) error {
 	rowCount := p.table.GetRowCount()
 	var values []string
 	if rowCount == 0 {
 		values = initialValues
 	} else {
-		values = append([]string{strconv.FormatInt(int64(p.table.GetRowCount()), 10), time.Now().Format("2006-01-02 15:04:05")}, initialValues...)
+		values = append([]string{strconv.FormatInt(int64(p.table.GetRowCount()), 10)}, initialValues...)
 	}
 
 	for i, v := range values {
@@ -96,7 +100,7 @@ func (p *PacketListPane) AddRow(initialValues ...string) error {
 		if rowCount == 0 {
 			cell.SetSelectable(false)
 		} else {
-			if i == 4 {
+			if i == packetListProtocolColumn {
 				color = tcell.ColorLightGreen
 			}
 		}
@@ -139,6 +143,20 @@ func (p *PacketDetailsPane) Init() {
 
 func (p *PacketDetailsPane) AddPDU(pdu *units.PDU) {
 	p.table.Clear()
+	if pdu != nil && pdu.CaptureInfo != nil {
+		info := pdu.CaptureInfo
+		p.AddRow(fmt.Sprintf(
+			"Frame: %d bytes on wire, %d bytes captured, %s, %s, %s",
+			info.Length, info.CaptureLength, interfaceName(info), units.PacketTypeStringMap[info.PacketType],
+			info.Timestamp.Format("2006-01-02 15:04:05.000000000"),
+		))
+		// The frame row has no PDU of its own to break down
+		p.table.GetCell(0, 0).SetSelectable(false)
+		for _, comment := range info.Comments {
+			p.AddRow("Comment: " + comment)
+			p.table.GetCell(p.table.GetRowCount()-1, 0).SetSelectable(false)
+		}
+	}
 	currentPDU := pdu
 	for currentPDU != nil {
 		rowToPDU := *p.rowToPDU
@@ -159,6 +177,13 @@ func (p *PacketDetailsPane) AddPDU(pdu *units.PDU) {
 	}
 }
 
+func interfaceName(info *units.CaptureInfo) string {
+	if info.InterfaceName == "" {
+		return fmt.Sprintf("interface %d", info.InterfaceIndex)
+	}
+	return fmt.Sprintf("interface %s (%d)", info.InterfaceName, info.InterfaceIndex)
+}
+
 func (p *PacketDetailsPane) AddRow(value string) {
 	cell := tview.NewTableCell(value)
 	cell.SetExpansion(1)
@@ -215,14 +240,14 @@ func (p *BreakDownPane) Init() {
 }
 
 type Terminal struct {
-	NetworkInterface  chan string
+	NetworkInterface  chan []string // receives the interfaces selected on Start
 	app               *tview.Application
 	packetListPane    *PacketListPane
 	packetDetailsPane *PacketDetailsPane
 	breakDownPane     *BreakDownPane
 }
 
-func (t *Terminal) InitPanes(iface string) {
+func (t *Terminal) InitPanes(title string) {
 	breakDownPane := BreakDownPane{Application: t.app}
 	breakDownPane.Init()
 	t.breakDownPane = &breakDownPane
@@ -232,7 +257,7 @@ func (t *Terminal) InitPanes(iface string) {
 	t.packetDetailsPane = &packetDetailsPane
 
 	packetListPane := PacketListPane{Application: t.app, PDUHandover: packetDetailsPane.AddPDU}
-	packetListPane.Init(iface)
+	packetListPane.Init(title)
 	t.packetListPane = &packetListPane
 
 	rootFlexBox := tview.NewFlex()
@@ -251,21 +276,103 @@ func (t *Terminal) Init() {
 	t.app.EnableMouse(true)
 }
 
-func (t *Terminal) Start(ifaces []string) {
+// Start lets one or more of the interfaces that listInterfaces returns be selected, the list is refreshed every second
+// until then. The capture panes are shown once the selection is sent to NetworkInterface
+func (t *Terminal) Start(listInterfaces func() []utils.NetworkInterface) {
 	if t.app == nil {
 		t.Init()
 	}
-	modal := tview.NewModal().
-		SetText("Select a network interface").AddButtons(ifaces).SetDoneFunc(
-		func(buttonIndex int, buttonLabel string) {
-			t.InitPanes(buttonLabel)
-			t.NetworkInterface <- buttonLabel
-		})
-	if err := t.app.SetRoot(modal, false).EnableMouse(true).Run(); err != nil {
+	list := newInterfaceList()
+	list.update(listInterfaces(), time.Now())
+	refreshed := make(chan struct{})
+	var stopRefresh sync.Once
+	capture := func() {
+		chosen := list.chosen()
+		if len(chosen) == 0 {
+			return
+		}
+		stopRefresh.Do(func() { close(refreshed) })
+		title := fmt.Sprintf("Network interface: %s", chosen[0])
+		if len(chosen) > 1 {
+			title = fmt.Sprintf("Network interfaces: %s", strings.Join(chosen, ", "))
+		}
+		t.InitPanes(title)
+		t.NetworkInterface <- chosen
+	}
+	button := tview.NewButton("Capture").SetSelectedFunc(capture)
+	help := tview.NewTextView().SetText("Enter or space: select  c: capture  Tab: switch to the button")
+	list.table.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyTab {
+			t.app.SetFocus(button)
+		}
+	})
+	button.SetExitFunc(func(key tcell.Key) {
+		t.app.SetFocus(list.table)
+	})
+	tableInput := list.table.GetInputCapture()
+	list.table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyRune && event.Rune() == 'c' {
+			capture()
+			return nil
+		}
+		return tableInput(event)
+	})
+
+	bottom := tview.NewFlex().
+		AddItem(help, 0, 1, false).
+		AddItem(button, 11, 0, false)
+	root := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(list.table, 0, 1, true).
+		AddItem(bottom, 1, 0, false)
+	t.app.SetRoot(root, true)
+
+	go func() {
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-refreshed:
+				return
+			}
+			ifaces := listInterfaces()
+			t.app.QueueUpdateDraw(func() {
+				select {
+				case <-refreshed:
+				default:
+					list.update(ifaces, time.Now())
+				}
+			})
+		}
+	}()
+	t.Run()
+	stopRefresh.Do(func() { close(refreshed) })
+}
+
+// Open skips the interface selection for sources known upfront, such as capture files. PDUs can be added as soon as
+// it returns, Run has to be called to show them
+func (t *Terminal) Open(title string) {
+	if t.app == nil {
+		t.Init()
+	}
+	t.InitPanes(title)
+}
+
+func (t *Terminal) Run() {
+	if err := t.app.EnableMouse(true).Run(); err != nil {
 		panic(err)
 	}
 }
 
+// Stop gives the terminal back to the shell and makes Run return
+func (t *Terminal) Stop() {
+	t.app.Stop()
+}
+
+func (t *Terminal) SetStatus(status string) {
+	t.packetListPane.SetStatus(status)
+}
+
 func (t *Terminal) AddPDU(pdu *units.PDU) error {
 	t.packetListPane.AddPDU(pdu)
 	return nil
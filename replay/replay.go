@@ -0,0 +1,130 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"packet_sniffer/capture"
+	units "packet_sniffer/model"
+	"time"
+)
+
+// Replayer sends the frames of a capture file out through an injector. By default the frames are sent with the gaps
+// between them that were captured, Speed scales the gaps, PPS replaces them with a fixed rate and TopSpeed drops them
+type Replayer struct {
+	Path     string
+	Injector capture.Injector
+	Speed    float64 // 2 replays twice as fast as captured, 1 when not set
+	PPS      float64 // frames per second, the capture's timing is ignored when set
+	TopSpeed bool
+	Loops    int // times the file is replayed, forever when negative and once when 0
+}
+
+// Stats counts the frames sent so far
+type Stats struct {
+	Packets uint64
+	Bytes   uint64
+	Failed  uint64 // frames the injector refused, e.g. because they are larger than the MTU
+	Elapsed time.Duration
+
+	LastError error // why the last of the failed frames couldn't be sent
+}
+
+func (s Stats) String() string {
+	seconds := s.Elapsed.Seconds()
+	if seconds == 0 {
+		seconds = 1
+	}
+	summary := fmt.Sprintf("%d frames (%d bytes) sent in %s, %.1f pps, %.3f Mbps, %d failed", s.Packets, s.Bytes,
+		s.Elapsed.Round(time.Millisecond), float64(s.Packets)/seconds, float64(s.Bytes)*8/seconds/1e6, s.Failed)
+	if s.LastError != nil {
+		summary += fmt.Sprintf(" (%v)", s.LastError)
+	}
+	return summary
+}
+
+// Run replays the file until every loop is done or the context is done, which is not an error
+func (r *Replayer) Run(ctx context.Context) (Stats, error) {
+	stats := Stats{}
+	start := time.Now()
+	var err error
+	for loop := 0; r.Loops < 0 || loop < max(r.Loops, 1); loop++ {
+		before := stats.Packets + stats.Failed
+		if err = r.replayOnce(ctx, &stats); err != nil || ctx.Err() != nil {
+			break
+		}
+		// There is no point in looping over a file without frames
+		if stats.Packets+stats.Failed == before {
+			break
+		}
+	}
+	stats.Elapsed = time.Since(start)
+	return stats, err
+}
+
+func (r *Replayer) replayOnce(ctx context.Context, stats *Stats) error {
+	reader, err := capture.OpenFile(r.Path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if linkType := reader.LinkType(); linkType != units.LinkTypeEthernet {
+		return fmt.Errorf("%s: frames of link type %d (%s) can't be sent", r.Path, linkType, units.LinkTypeStringMap[linkType])
+	}
+
+	speed := r.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	// Every loop starts its own timeline, so that the gap between the last and the first frame is not waited for
+	start := time.Now()
+	var first time.Time
+	for sent := 0; ; sent++ {
+		data, info, err := reader.Capture(ctx)
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if sent == 0 {
+			first = info.Timestamp
+		}
+
+		var due time.Time
+		switch {
+		case r.TopSpeed:
+		case r.PPS > 0:
+			due = start.Add(time.Duration(float64(sent) / r.PPS * float64(time.Second)))
+		default:
+			due = start.Add(time.Duration(float64(info.Timestamp.Sub(first)) / speed))
+		}
+		if err := sleepUntil(ctx, due); err != nil {
+			return nil
+		}
+
+		if err := r.Injector.Inject(data); err != nil {
+			stats.Failed++
+			stats.LastError = err
+			continue
+		}
+		stats.Packets++
+		stats.Bytes += uint64(len(data))
+	}
+}
+
+// sleepUntil waits for the time to come, it returns right away for times in the past
+func sleepUntil(ctx context.Context, due time.Time) error {
+	wait := time.Until(due)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// netnsDirectory is where ip netns keeps the files of the namespaces it names
+const netnsDirectory = "/var/run/netns"
+
+// NetworkNamespacePath resolves a network namespace given by the PID of a process in it, e.g. a container's, by the
+// name ip netns gave it or by the path of its file
+func NetworkNamespacePath(namespace string) (string, error) {
+	path := namespace
+	if pid, err := strconv.Atoi(namespace); err == nil {
+		if pid <= 0 {
+			return "", fmt.Errorf("invalid PID %d", pid)
+		}
+		path = fmt.Sprintf("/proc/%d/ns/net", pid)
+	} else if !strings.Contains(namespace, "/") {
+		path = filepath.Join(netnsDirectory, namespace)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("no network namespace %q: %v", namespace, err)
+	}
+	return path, nil
+}
+
+// InNetworkNamespace runs fn in the network namespace of the file at path, or in the one of the process when the path
+// is empty. Sockets created by fn stay in the namespace for good. The namespace is entered by an OS thread of its own,
+// which is thrown away afterwards rather than switched back, so no other goroutine ever runs in the namespace
+func InNetworkNamespace(path string, fn func() error) error {
+	if path == "" {
+		return fn()
+	}
+	namespace, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open network namespace %s: %v", path, err)
+	}
+	defer unix.Close(namespace)
+
+	done := make(chan error, 1)
+	go func() {
+		// Never unlocked, the thread exits along with the goroutine
+		runtime.LockOSThread()
+		if err := unix.Setns(namespace, unix.CLONE_NEWNET); err != nil {
+			done <- fmt.Errorf("failed to enter network namespace %s: %v", path, err)
+			return
+		}
+		done <- fn()
+	}()
+	return <-done
+}
@@ -1,12 +1,163 @@
 package utils
 
-import "net"
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
 
-func GetNetworkInterfaces() []string {
-	ifaces, _ := net.Interfaces()
-	output := make([]string, len(ifaces))
-	for i, iface := range ifaces {
-		output[i] = iface.Name
+// NetworkInterface describes an interface the way the kernel reports it
+type NetworkInterface struct {
+	Name         string
+	Index        int
+	MTU          int
+	HardwareAddr net.HardwareAddr
+	Flags        net.Flags
+	Addresses    []net.IPNet
+	Counters     InterfaceCounters
+}
+
+// InterfaceCounters are the totals of the traffic of an interface since it was created
+type InterfaceCounters struct {
+	RxPackets uint64
+	TxPackets uint64
+	RxBytes   uint64
+	TxBytes   uint64
+	RxDropped uint64
+	TxDropped uint64
+}
+
+func (c InterfaceCounters) Add(other InterfaceCounters) InterfaceCounters {
+	return InterfaceCounters{
+		RxPackets: c.RxPackets + other.RxPackets,
+		TxPackets: c.TxPackets + other.TxPackets,
+		RxBytes:   c.RxBytes + other.RxBytes,
+		TxBytes:   c.TxBytes + other.TxBytes,
+		RxDropped: c.RxDropped + other.RxDropped,
+		TxDropped: c.TxDropped + other.TxDropped,
+	}
+}
+
+// State sums up whether the interface can carry traffic
+func (i NetworkInterface) State() string {
+	switch {
+	case i.Flags&net.FlagUp == 0:
+		return "down"
+	case i.Flags&net.FlagRunning == 0:
+		return "no carrier"
+	}
+	return "up"
+}
+
+// AddressStrings returns the addresses of the interface in CIDR notation, the IPv4 ones first
+func (i NetworkInterface) AddressStrings() []string {
+	var v4, v6 []string
+	for _, address := range i.Addresses {
+		if address.IP.To4() != nil {
+			v4 = append(v4, address.String())
+		} else {
+			v6 = append(v6, address.String())
+		}
 	}
+	return append(v4, v6...)
+}
+
+// GetNetworkInterfaces lists the interfaces of the network namespace of the file at path, or of the process' own one
+// when the path is empty. Whatever can't be found out about an interface is left out
+func GetNetworkInterfaces(namespace string) []NetworkInterface {
+	var output []NetworkInterface
+	InNetworkNamespace(namespace, func() error {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return err
+		}
+		// sysfs shows the interfaces of the namespace it was mounted in, the counters of another namespace's interfaces
+		// come from the procfs of the thread that entered it
+		var counters map[string]InterfaceCounters
+		if namespace != "" {
+			counters, _ = readNetDev("/proc/thread-self/net/dev")
+		}
+		output = make([]NetworkInterface, len(ifaces))
+		for i, iface := range ifaces {
+			output[i] = NetworkInterface{
+				Name:         iface.Name,
+				Index:        iface.Index,
+				MTU:          iface.MTU,
+				HardwareAddr: iface.HardwareAddr,
+				Flags:        iface.Flags,
+			}
+			if addresses, err := iface.Addrs(); err == nil {
+				for _, address := range addresses {
+					if ipNet, ok := address.(*net.IPNet); ok {
+						output[i].Addresses = append(output[i].Addresses, *ipNet)
+					}
+				}
+			}
+			if namespace != "" {
+				output[i].Counters = counters[iface.Name]
+				continue
+			}
+			output[i].Counters = readSysfsCounters(filepath.Join("/sys/class/net", iface.Name, "statistics"))
+		}
+		return nil
+	})
 	return output
 }
+
+func readSysfsCounters(directory string) InterfaceCounters {
+	read := func(name string) uint64 {
+		content, err := os.ReadFile(filepath.Join(directory, name))
+		if err != nil {
+			return 0
+		}
+		value, _ := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+		return value
+	}
+	return InterfaceCounters{
+		RxPackets: read("rx_packets"),
+		TxPackets: read("tx_packets"),
+		RxBytes:   read("rx_bytes"),
+		TxBytes:   read("tx_bytes"),
+		RxDropped: read("rx_dropped"),
+		TxDropped: read("tx_dropped"),
+	}
+}
+
+// readNetDev parses the counters of /proc/net/dev, a two line header followed by a line per interface:
+// "name: rx bytes packets errs drop fifo frame compressed multicast tx bytes packets errs drop ..."
+func readNetDev(path string) (map[string]InterfaceCounters, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	counters := make(map[string]InterfaceCounters)
+	scanner := bufio.NewScanner(file)
+	for line := 0; scanner.Scan(); line++ {
+		name, values, found := strings.Cut(scanner.Text(), ":")
+		if line < 2 || !found {
+			continue
+		}
+		fields := strings.Fields(values)
+		if len(fields) < 12 {
+			return nil, fmt.Errorf("%s: unexpected line %q", path, scanner.Text())
+		}
+		parsed := make([]uint64, 12)
+		for i := range parsed {
+			parsed[i], _ = strconv.ParseUint(fields[i], 10, 64)
+		}
+		counters[strings.TrimSpace(name)] = InterfaceCounters{
+			RxBytes:   parsed[0],
+			RxPackets: parsed[1],
+			RxDropped: parsed[3],
+			TxBytes:   parsed[8],
+			TxPackets: parsed[9],
+			TxDropped: parsed[11],
+		}
+	}
+	return counters, scanner.Err()
+}
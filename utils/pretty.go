@@ -2,11 +2,26 @@ package utils
 
 import (
 	"fmt"
+	"net"
 	units "packet_sniffer/model"
 	"packet_sniffer/parsing"
+	"strconv"
+	"strings"
 )
 
 func PDUPrettyPrint(pdu *units.PDU) {
+	if info := pdu.CaptureInfo; info != nil {
+		fmt.Printf("Frame: %s\n", info.Timestamp.Format("2006-01-02 15:04:05.000000000"))
+		fmt.Printf("Length: %d bytes on wire, %d bytes captured\n", info.Length, info.CaptureLength)
+		if info.InterfaceName != "" {
+			fmt.Printf("Interface: %s (%d)\n", info.InterfaceName, info.InterfaceIndex)
+		}
+		fmt.Printf("Direction: %s\n", units.PacketTypeStringMap[info.PacketType])
+		for _, comment := range info.Comments {
+			fmt.Printf("Comment: %s\n", comment)
+		}
+		fmt.Println()
+	}
 	currentPDU := pdu
 	for currentPDU != nil {
 		fmt.Printf("Protocol: %s\n", units.ProtocolStringMap[currentPDU.Protocol].Full)
@@ -21,3 +36,61 @@ func PDUPrettyPrint(pdu *units.PDU) {
 	fmt.Println()
 	fmt.Println()
 }
+
+// PDUAddresses returns the innermost source and destination addresses of a PDU and its innermost protocol
+func PDUAddresses(pdu *units.PDU) (source string, destination string, protocol string) {
+	currentPDU := pdu
+	for currentPDU != nil {
+		if currentPDU.NextPDU == nil {
+			protocol = units.ProtocolStringMap[currentPDU.Protocol].Shortened
+		}
+		parser := parsing.ParserFromProtocol(currentPDU.Protocol)
+
+		if _, hit := currentPDU.Headers[parsing.SRCHeader]; hit {
+			source = parser.HeaderToHumanReadable(parsing.SRCHeader, currentPDU)
+		}
+		if _, hit := currentPDU.Headers[parsing.DSTHeader]; hit {
+			destination = parser.HeaderToHumanReadable(parsing.DSTHeader, currentPDU)
+		}
+		if ports, ok := parser.(parsing.PortParser); ok {
+			sourcePort, destinationPort := ports.Ports(currentPDU)
+			source = net.JoinHostPort(source, strconv.Itoa(int(sourcePort)))
+			destination = net.JoinHostPort(destination, strconv.Itoa(int(destinationPort)))
+		}
+		currentPDU = currentPDU.NextPDU
+	}
+	return source, destination, protocol
+}
+
+// PDUVLANs returns the VLAN IDs a PDU is tagged with, the outermost first
+func PDUVLANs(pdu *units.PDU) string {
+	var ids []string
+	for currentPDU := pdu; currentPDU != nil; currentPDU = currentPDU.NextPDU {
+		if currentPDU.Protocol == units.VLAN_TAGGED {
+			ids = append(ids, strconv.Itoa(int(parsing.VLANParser{}.ID(currentPDU))))
+		}
+	}
+	return strings.Join(ids, "/")
+}
+
+// PDUSummaryPrint prints a PDU on a single line, the way tcpdump does
+func PDUSummaryPrint(pdu *units.PDU) {
+	source, destination, protocol := PDUAddresses(pdu)
+	line := fmt.Sprintf("%s %s > %s", protocol, source, destination)
+	if vlans := PDUVLANs(pdu); vlans != "" {
+		line = fmt.Sprintf("vlan %s %s", vlans, line)
+	}
+	if info := pdu.CaptureInfo; info != nil {
+		iface := info.InterfaceName
+		if iface == "" {
+			iface = strconv.Itoa(info.InterfaceIndex)
+		}
+		line = fmt.Sprintf("%s %s %s %s, length %d", info.Timestamp.Format("15:04:05.000000"), iface,
+			units.PacketTypeStringMap[info.PacketType], line, info.Length)
+		// Comments tell about what happened before the frame, e.g. to the interface it was captured on
+		for _, comment := range info.Comments {
+			fmt.Printf("# %s\n", comment)
+		}
+	}
+	fmt.Println(line)
+}